@@ -7,6 +7,9 @@ require (
 	github.com/googolgl/go-i2c v0.1.1
 	github.com/googolgl/go-pca9685 v0.1.6
 	github.com/googollee/go-socket.io v1.8.0-rc.1
+	github.com/pion/ice/v2 v2.3.11
+	github.com/pion/interceptor v0.1.21
+	github.com/pion/rtcp v1.2.10
 	github.com/pion/webrtc/v3 v3.2.21
 	github.com/prometheus/procfs v0.12.0
 	github.com/stianeikeland/go-rpio/v4 v4.6.0
@@ -20,12 +23,9 @@ require (
 	github.com/gorilla/websocket v1.5.0 // indirect
 	github.com/pion/datachannel v1.5.5 // indirect
 	github.com/pion/dtls/v2 v2.2.7 // indirect
-	github.com/pion/ice/v2 v2.3.11 // indirect
-	github.com/pion/interceptor v0.1.21 // indirect
 	github.com/pion/logging v0.2.2 // indirect
 	github.com/pion/mdns v0.0.9 // indirect
 	github.com/pion/randutil v0.1.0 // indirect
-	github.com/pion/rtcp v1.2.10 // indirect
 	github.com/pion/rtp v1.8.2 // indirect
 	github.com/pion/sctp v1.8.9 // indirect
 	github.com/pion/sdp/v3 v3.0.6 // indirect
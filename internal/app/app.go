@@ -7,22 +7,31 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/Speshl/gorrc_client/internal/cam"
 	"github.com/Speshl/gorrc_client/internal/command/pca9685"
 	pipwm "github.com/Speshl/gorrc_client/internal/command/pi_pwm"
+	"github.com/Speshl/gorrc_client/internal/command/sim"
 	"github.com/Speshl/gorrc_client/internal/config"
+	"github.com/Speshl/gorrc_client/internal/failsafe"
 	"github.com/Speshl/gorrc_client/internal/gst"
 	"github.com/Speshl/gorrc_client/internal/mic"
 	"github.com/Speshl/gorrc_client/internal/models"
+	"github.com/Speshl/gorrc_client/internal/recorder"
+	"github.com/Speshl/gorrc_client/internal/sensors"
+	"github.com/Speshl/gorrc_client/internal/sensors/ads1115"
+	"github.com/Speshl/gorrc_client/internal/sensors/ina219"
+	simsensor "github.com/Speshl/gorrc_client/internal/sensors/sim"
 	"github.com/Speshl/gorrc_client/internal/speaker"
 	"github.com/Speshl/gorrc_client/internal/vehicle"
 	"github.com/Speshl/gorrc_client/internal/vehicle/crawler"
 	smallracer "github.com/Speshl/gorrc_client/internal/vehicle/smallRacer"
-	"github.com/google/uuid"
 	socketio "github.com/googollee/go-socket.io"
+	"github.com/pion/ice/v2"
 	"github.com/pion/webrtc/v3"
 	"golang.org/x/sync/errgroup"
 )
@@ -40,20 +49,65 @@ type App struct {
 	vehicleInfo models.Car
 	trackInfo   models.Track
 
-	client *socketio.Client
+	client            *socketio.Client
+	serverLock        sync.Mutex //held while connecting, closing or emitting
+	serverOpened      bool       //client.Close panics before the first successful connect
+	serverConnected   atomic.Bool
+	serverDisconnects chan struct{}
+
+	health healthState
+	ready  atomic.Bool //false while the server is not answering health checks, offers are refused
+
+	shutdownOnce  sync.Once
+	shutdownTimer atomic.Pointer[time.Timer] //exits the process if the shutdown hangs
 
 	speakerChannel chan string
 	speaker        *speaker.Speaker
 	mic            *mic.Mic
 	cams           []*cam.Cam
 	command        vehicle.CommandDriverIFace
-
-	seats         []models.Seat //number of available connections to this vehicle
-	userConns     []*Connection
-	userPeerConns map[uuid.UUID]*webrtc.PeerConnection
+	failsafe       *failsafe.Driver
+	recorder       *recorder.Recorder
+	battery        *sensors.Monitor
+	buttons        Buttons
+
+	seats []models.Seat //number of available connections to this vehicle
+	conns *ConnectionRegistry
+
+	codecs    codecs
+	api       *webrtc.API //shared by every seat
+	udpMux    ice.UDPMux
+	iceLock   sync.RWMutex //the server can push a new ice config while offers are handled
+	iceConfig webrtc.Configuration
 }
 
-func NewApp(cfg config.Config, client *socketio.Client) *App {
+func NewApp(cfg config.Config, client *socketio.Client) (*App, error) {
+	buttons, err := newButtons(cfg.SmallRacerCfg.VehicleConfig, vehicleButtons(cfg.SmallRacerCfg.VehicleType))
+	if err != nil {
+		return nil, fmt.Errorf("invalid button config: %w", err)
+	}
+
+	codecs, err := newCodecs(cfg.CamCfgs)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting codecs: %w", err)
+	}
+	mediaEngine, err := codecs.mediaEngine()
+	if err != nil {
+		return nil, fmt.Errorf("failed creating media engine: %w", err)
+	}
+	api, udpMux, err := newAPI(cfg.ICECfg, mediaEngine)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating webrtc api: %w", err)
+	}
+
+	sensor, err := newSensor(cfg.SensorCfg)
+	if err != nil {
+		if udpMux != nil {
+			udpMux.Close()
+		}
+		return nil, fmt.Errorf("failed creating battery sensor: %w", err)
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 
 	speakerChannel := make(chan string, 100)
@@ -62,6 +116,11 @@ func NewApp(cfg config.Config, client *socketio.Client) *App {
 		cfg.ServerCfg.SeatCount = config.DefaultSeatCount
 	}
 
+	if cfg.ServerCfg.SeatPolicy != SeatPolicyKeep && cfg.ServerCfg.SeatPolicy != SeatPolicyQueue {
+		log.Printf("warning: unknown seat policy %s, using %s\n", cfg.ServerCfg.SeatPolicy, config.DefaultSeatPolicy)
+		cfg.ServerCfg.SeatPolicy = config.DefaultSeatPolicy
+	}
+
 	seats := make([]models.Seat, 0, cfg.ServerCfg.SeatCount)
 	for i := 0; i < cfg.ServerCfg.SeatCount; i++ {
 		seats = append(seats, models.Seat{
@@ -73,19 +132,34 @@ func NewApp(cfg config.Config, client *socketio.Client) *App {
 		})
 	}
 
-	return &App{
+	recorder := recorder.NewRecorder(cfg.RecordCfg)
+	failsafe := failsafe.NewDriver(cfg.CommandCfg, recorder.WrapDriver(newCommand(cfg.CommandCfg))) //commands are only logged while recording
+
+	app := &App{
 		cfg:            cfg,
 		client:         client,
 		ctx:            ctx,
 		ctxCancel:      cancel,
 		speakerChannel: speakerChannel,
-		vehicle:        newVehicle(cfg, seats),
+		vehicle:        newVehicle(cfg, seats, failsafe),
 		seats:          seats,
 		speaker:        speaker.NewSpeaker(cfg.SpeakerCfg, speakerChannel),
+		failsafe:       failsafe,
+		recorder:       recorder,
+		battery:        sensors.NewMonitor(cfg.SensorCfg, sensor, failsafe),
+		buttons:        buttons,
 		cams:           make([]*cam.Cam, 0, len(cfg.CamCfgs)),
-		userConns:      make([]*Connection, cfg.ServerCfg.SeatCount),
-		userPeerConns:  make(map[uuid.UUID]*webrtc.PeerConnection, 2),
+		conns:          NewConnectionRegistry(cfg.ServerCfg.SeatCount),
+		codecs:         codecs,
+		api:            api,
+		udpMux:         udpMux,
+
+		serverDisconnects: make(chan struct{}, 1),
 	}
+	app.ready.Store(true)
+	app.setICEConfig(configICE(cfg.ICECfg), "config")
+	app.conns.OnEvent(app.onConnectionEvent)
+	return app, nil
 }
 
 func (a *App) RegisterHandlers() error {
@@ -100,25 +174,32 @@ func (a *App) RegisterHandlers() error {
 
 	a.client.OnEvent("register_success", a.onRegisterSuccess)
 
-	log.Println("attempting to connect to server...")
-	err := a.client.Connect() //Client must have atleast 1 event handler to work
-	if err != nil {
-		return fmt.Errorf("error: failed connecting to server - %w", err)
-	}
-	log.Println("connected to server")
-	return nil
+	a.client.OnEvent("car_healthy_reply", a.onHealthyReply)
+
+	a.client.OnEvent("record_start", a.onRecordStart)
+
+	a.client.OnEvent("record_stop", a.onRecordStop)
+
+	a.client.OnDisconnect(a.onServerDisconnect)
+
+	a.client.OnError(a.onServerError)
+
+	return a.connect() //a failed connect is retried once started
 }
 
 func (a *App) Start() error {
+	defer a.failsafe.RecoverPanic()
+	defer a.finishShutdown() //runs after every other deferred cleanup below
 	group, groupCtx := errgroup.WithContext(a.ctx)
 	log.Println("starting...")
 
 	for i, camCfg := range a.cfg.CamCfgs {
 		if camCfg.Enabled { //start enabled cameras
-			cam, err := cam.NewCam(camCfg)
+			cam, err := cam.NewCam(i, camCfg)
 			if err != nil {
 				return fmt.Errorf("error: failed creating cam %d: %w\n", i, err)
 			}
+			cam.SetFrameSink(a.recorder)
 			a.cams = append(a.cams, cam)
 
 			for i := range a.seats { //add all camera video tracks to each seat
@@ -138,7 +219,23 @@ func (a *App) Start() error {
 
 	defer func() {
 		log.Println("stopping...")
-		a.client.Close()
+		a.closeServer()
+		if a.udpMux != nil {
+			a.udpMux.Close()
+		}
+	}()
+
+	if a.cfg.RecordCfg.Enabled && a.cfg.RecordCfg.AutoStart {
+		err = a.recorder.Start()
+		if err != nil {
+			log.Printf("error: failed starting recording: %s\n", err.Error())
+		}
+	}
+	defer func() {
+		err := a.recorder.Stop()
+		if err != nil {
+			log.Printf("error: failed stopping recording: %s\n", err.Error())
+		}
 	}()
 
 	//Start gstreamer loops
@@ -151,6 +248,7 @@ func (a *App) Start() error {
 		}()
 		return nil
 	})
+	defer gst.StopMainRecieveLoop()
 
 	group.Go(func() error {
 		return a.speaker.Start(groupCtx)
@@ -164,8 +262,8 @@ func (a *App) Start() error {
 		select {
 		case sig := <-signalChannel:
 			log.Printf("received signal: %s\n", sig)
-			a.ctxCancel()
-			return fmt.Errorf("received signal: %s\n", sig)
+			a.shutdown(fmt.Sprintf("received signal: %s", sig))
+			return nil
 		case <-groupCtx.Done():
 			log.Printf("closing signal goroutine\n")
 			return groupCtx.Err()
@@ -174,6 +272,7 @@ func (a *App) Start() error {
 
 	//Start Cameras
 	for i, cam := range a.cams {
+		i, cam := i, cam
 		group.Go(func() error {
 			log.Printf("starting camera %d\n", i)
 			return cam.Start(groupCtx)
@@ -187,12 +286,14 @@ func (a *App) Start() error {
 		select {
 		case <-groupCtx.Done():
 			log.Printf("stopping mic")
+			a.mic.Stop()
 			return nil
 		}
 	})
 
 	//Start car
 	group.Go(func() error {
+		defer a.failsafe.RecoverPanic()
 		log.Printf("Starting car")
 		err := a.vehicle.Init()
 		if err != nil {
@@ -202,27 +303,19 @@ func (a *App) Start() error {
 		return a.vehicle.Start(groupCtx)
 	})
 
-	//Send connect and send healthchecks
+	//Watch the battery
 	group.Go(func() error {
-		encodedMsg, _ := encode(models.ConnectReq{
-			Key:       a.cfg.ServerCfg.Key,
-			Password:  a.cfg.ServerCfg.Password,
-			SeatCount: a.cfg.ServerCfg.SeatCount,
-		})
-		a.client.Emit("car_connect", encodedMsg)
-
-		healthTicker := time.NewTicker(30 * time.Second)
-
-		for {
-			select {
-			case <-groupCtx.Done():
-				log.Println("health checker stopped")
-				return groupCtx.Err()
-			case <-healthTicker.C:
-				//log.Println("server healthcheck: healthy")
-				a.client.Emit("car_healthy", "")
-			}
-		}
+		return a.battery.Start(groupCtx)
+	})
+
+	//Keep connected and registered with the server
+	group.Go(func() error {
+		return a.superviseServer(groupCtx)
+	})
+
+	//Send healthchecks
+	group.Go(func() error {
+		return a.startHealthChecks(groupCtx)
 	})
 
 	if !a.cfg.ServerCfg.SilentStart {
@@ -242,6 +335,9 @@ func (a *App) Start() error {
 	}()
 
 	err = group.Wait()
+	if err != nil && !errors.Is(err, context.Canceled) {
+		a.shutdown(err.Error()) //already done when stopped by a signal
+	}
 	if err != nil {
 		if errors.Is(err, context.Canceled) {
 			log.Println("context was cancelled")
@@ -252,7 +348,7 @@ func (a *App) Start() error {
 	}
 
 	log.Println("shutting down")
-	return a.client.Close()
+	return a.closeServer()
 }
 
 func newCommand(cfg config.CommandConfig) vehicle.CommandDriverIFace {
@@ -263,19 +359,53 @@ func newCommand(cfg config.CommandConfig) vehicle.CommandDriverIFace {
 	case "pipwm":
 		log.Println("command driver: pipwm")
 		return pipwm.NewCommand(cfg)
+	case "sim":
+		log.Println("command driver: sim")
+		return sim.NewCommand(cfg)
 	default:
 		log.Println("warning: no servo driver selected, defaulting to pi pwm")
 		return pipwm.NewCommand(cfg)
 	}
 }
 
-func newVehicle(cfg config.Config, seats []models.Seat) vehicle.Vehicle {
+// newSensor picks the battery sensor, nil when there is none
+func newSensor(cfg config.SensorConfig) (sensors.Sensor, error) {
+	switch cfg.Driver {
+	case "ina219":
+		log.Println("battery sensor: ina219")
+		return ina219.NewSensor(cfg)
+	case "ads1115":
+		log.Println("battery sensor: ads1115")
+		return ads1115.NewSensor(cfg)
+	case "sim":
+		log.Println("battery sensor: sim")
+		return simsensor.NewSensor(cfg), nil
+	case "none", "":
+		log.Println("warning: no battery sensor, battery is not monitored")
+		return nil, nil
+	default:
+		log.Printf("warning: unknown battery sensor %s, battery is not monitored\n", cfg.Driver)
+		return nil, nil
+	}
+}
+
+// vehicleButtons are the buttons newVehicle's vehicle reads
+func vehicleButtons(vehicleType string) []int {
+	switch vehicleType {
+	case "crawler":
+		return crawler.Buttons
+	default:
+		return smallracer.Buttons
+	}
+}
+
+func newVehicle(cfg config.Config, seats []models.Seat, commandDriver vehicle.CommandDriverIFace) vehicle.Vehicle {
 	switch cfg.SmallRacerCfg.VehicleType {
 	case "crawler":
-		return crawler.NewCrawler(cfg.CrawlerCfg, newCommand(cfg.CommandCfg), seats)
+		return crawler.NewCrawler(cfg.CrawlerCfg, commandDriver, seats)
 	case "smallracer":
 		fallthrough
 	default:
-		return smallracer.NewSmallRacer(cfg.SmallRacerCfg, newCommand(cfg.CommandCfg), seats)
+		return smallracer.NewSmallRacer(cfg.SmallRacerCfg, commandDriver, seats)
 	}
 }
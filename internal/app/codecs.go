@@ -0,0 +1,160 @@
+package app
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/Speshl/gorrc_client/internal/cam"
+	"github.com/Speshl/gorrc_client/internal/config"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	firstH264PayloadType = 102
+	opusPayloadType      = 111
+	opusFmtpLine         = "minptime=10;useinbandfec=1"
+)
+
+// codecs are what the car sends: h264 at each enabled camera's profile and opus from the mic
+type codecs struct {
+	profileLevelIDs []string
+}
+
+func newCodecs(camCfgs []config.CamConfig) (codecs, error) {
+	c := codecs{
+		profileLevelIDs: make([]string, 0, len(camCfgs)),
+	}
+	for i := range camCfgs {
+		if !camCfgs[i].Enabled {
+			continue
+		}
+		profileLevelID, err := cam.ProfileLevelID(camCfgs[i])
+		if err != nil {
+			return codecs{}, fmt.Errorf("cam %d: %w", i, err)
+		}
+		if !slices.Contains(c.profileLevelIDs, profileLevelID) {
+			c.profileLevelIDs = append(c.profileLevelIDs, profileLevelID)
+		}
+	}
+	log.Printf("sending h264 profile-level-ids %s and opus\n", strings.Join(c.profileLevelIDs, ", "))
+	return c, nil
+}
+
+// mediaEngine registers only the codecs the car sends, so pion can not answer with a profile the cameras
+// do not produce
+func (c codecs) mediaEngine() (*webrtc.MediaEngine, error) {
+	videoFeedback := []webrtc.RTCPFeedback{
+		{Type: "goog-remb"},
+		{Type: "ccm", Parameter: "fir"},
+		{Type: "nack"},
+		{Type: "nack", Parameter: "pli"},
+	}
+
+	mediaEngine := &webrtc.MediaEngine{}
+	for i, profileLevelID := range c.profileLevelIDs {
+		err := mediaEngine.RegisterCodec(webrtc.RTPCodecParameters{
+			RTPCodecCapability: webrtc.RTPCodecCapability{
+				MimeType:     webrtc.MimeTypeH264,
+				ClockRate:    90000,
+				SDPFmtpLine:  fmt.Sprintf("level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=%s", profileLevelID),
+				RTCPFeedback: videoFeedback,
+			},
+			PayloadType: webrtc.PayloadType(firstH264PayloadType + i),
+		}, webrtc.RTPCodecTypeVideo)
+		if err != nil {
+			return nil, fmt.Errorf("failed registering h264 %s: %w", profileLevelID, err)
+		}
+	}
+
+	err := mediaEngine.RegisterCodec(webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{
+			MimeType:    webrtc.MimeTypeOpus,
+			ClockRate:   48000,
+			Channels:    2,
+			SDPFmtpLine: opusFmtpLine,
+		},
+		PayloadType: opusPayloadType,
+	}, webrtc.RTPCodecTypeAudio)
+	if err != nil {
+		return nil, fmt.Errorf("failed registering opus: %w", err)
+	}
+	return mediaEngine, nil
+}
+
+// checkOffer makes sure an offer with video can take every camera's profile, and one with audio can take
+// opus. Pion falls back to any h264 when the profile does not match, which the browser then can not decode.
+func (c codecs) checkOffer(offer webrtc.SessionDescription) error {
+	parsed, err := offer.Unmarshal()
+	if err != nil {
+		return fmt.Errorf("failed parsing offer: %w", err)
+	}
+
+	offered := make([]string, 0, 8) //h264 profile-level-ids with packetization-mode 1
+	video, audio, opus := false, false, false
+	for _, media := range parsed.MediaDescriptions {
+		switch media.MediaName.Media {
+		case "video":
+			video = true
+		case "audio":
+			audio = true
+		}
+		payloadTypes := make(map[string]string, 8) //payload type to codec name
+		fmtps := make(map[string]string, 8)
+		for _, attribute := range media.Attributes {
+			payloadType, value, found := strings.Cut(attribute.Value, " ")
+			if !found {
+				continue
+			}
+			switch attribute.Key {
+			case "rtpmap":
+				codec, _, _ := strings.Cut(value, "/")
+				payloadTypes[payloadType] = strings.ToLower(codec)
+			case "fmtp":
+				fmtps[payloadType] = value
+			}
+		}
+
+		for payloadType, codec := range payloadTypes {
+			switch {
+			case media.MediaName.Media == "audio" && codec == "opus":
+				opus = true
+			case media.MediaName.Media == "video" && codec == "h264":
+				params := fmtpParams(fmtps[payloadType])
+				if params["packetization-mode"] == "1" {
+					offered = append(offered, strings.ToLower(params["profile-level-id"]))
+				}
+			}
+		}
+	}
+
+	for _, profileLevelID := range c.profileLevelIDs {
+		if video && !profileOffered(profileLevelID, offered) {
+			return fmt.Errorf("offer has no h264 compatible with profile-level-id %s (offered: %s)", profileLevelID, strings.Join(offered, ", "))
+		}
+	}
+	if audio && !opus { //an offer without video or audio just does not get the cameras or mic
+		return fmt.Errorf("offer has audio without opus")
+	}
+	return nil
+}
+
+func fmtpParams(fmtp string) map[string]string {
+	params := make(map[string]string, 4)
+	for _, param := range strings.Split(fmtp, ";") {
+		key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		params[strings.ToLower(key)] = value
+	}
+	return params
+}
+
+// profileOffered reports whether any h264 in the offer can play a stream with the profile-level-id
+func profileOffered(profileLevelID string, offered []string) bool {
+	for _, offer := range offered {
+		if cam.ProfileCompatible(profileLevelID, offer) {
+			return true
+		}
+	}
+	return false
+}
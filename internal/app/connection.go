@@ -5,19 +5,41 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/Speshl/gorrc_client/internal/cam"
+	"github.com/Speshl/gorrc_client/internal/clocksync"
+	"github.com/Speshl/gorrc_client/internal/config"
+	"github.com/Speshl/gorrc_client/internal/failsafe"
+	"github.com/Speshl/gorrc_client/internal/hud"
 	"github.com/Speshl/gorrc_client/internal/models"
+	"github.com/Speshl/gorrc_client/internal/recorder"
+	"github.com/Speshl/gorrc_client/internal/sensors"
+	"github.com/google/uuid"
 	socketio "github.com/googollee/go-socket.io"
 	"github.com/pion/webrtc/v3"
 )
 
+const maxButtons = 32 //bit buttons are a uint32
+
+// Buttons are the controller buttons a connection handles itself instead of passing to the vehicle
+type Buttons struct {
+	CamSwitch int //-1 when unused
+	Record    int
+}
+
 type AudioPlayer func(*webrtc.TrackRemote, *webrtc.RTPReceiver)
 
 type CommandHandler func(models.ControlState)
 
+type Emitter func(event string, args ...interface{})
+
 type Connection struct {
 	SeatNumber     int
+	UserId         uuid.UUID
 	Socket         socketio.Conn
 	PeerConnection *webrtc.PeerConnection
 	Ctx            context.Context
@@ -25,18 +47,54 @@ type Connection struct {
 	CommandChannel chan models.ControlState
 	HudChannel     chan models.Hud
 
-	Speaker AudioPlayer
+	Speaker  AudioPlayer
+	Recorder *recorder.Recorder
+	Failsafe *failsafe.Driver
+	Battery  *sensors.Monitor
+	Emit     Emitter
+
+	PingInput chan int64
+
+	outputLock   sync.RWMutex //outputs are set from data channel callbacks and used by the updater
+	outputs      map[string]*webrtc.DataChannel
+	disconnected atomic.Bool
+	onDisconnect func(*Connection) //set before pion can call Disconnect, never changed
 
-	HudOutput  *webrtc.DataChannel
-	PingOutput *webrtc.DataChannel
-	PingInput  chan int64
+	candidateLock   sync.Mutex //local candidates wait for the answer so the remote side can use them
+	answered        bool
+	localCandidates []webrtc.ICECandidateInit
+
+	camLock       sync.RWMutex
+	cams          map[string]*cam.Cam
+	videoTrackIDs []string
+	primaryCam    int
+	buttons       Buttons
+	lastButtons   uint32
+	lastSeq       uint32 //button presses are only taken from commands in order
+
+	staleLimit      time.Duration
+	commandLock     sync.Mutex
+	lastCommandAt   time.Time
+	minCommandDelay int64 //lowest arrival minus browser timestamp seen, the link delay when it is quiet
+	haveDelay       bool
+
+	clock         *clocksync.Estimator //browser clock from the ping channel
+	clockSynced   atomic.Bool
+	maxCommandAge time.Duration
+	inputLatency  time.Duration //age of the last command, only known once the clock is synced
+	oldCommands   int           //dropped for being older than maxCommandAge
+	droppingOld   bool
 }
 
-func NewConnection(seatNum int, socketConn socketio.Conn, commandChan chan models.ControlState, hudChan chan models.Hud, speakers AudioPlayer, peerConn *webrtc.PeerConnection) (*Connection, error) {
+func NewConnection(seatNum int, userId uuid.UUID, socketConn socketio.Conn, commandChan chan models.ControlState, hudChan chan models.Hud, speakers AudioPlayer, peerConn *webrtc.PeerConnection, cams []*cam.Cam, recorder *recorder.Recorder, failsafe *failsafe.Driver, battery *sensors.Monitor, buttons Buttons, emit Emitter, onDisconnect func(*Connection), staleLimit time.Duration, maxCommandAge time.Duration) (*Connection, error) {
 	log.Printf("creating user connection %s for seat %d\n", socketConn.ID(), seatNum)
+	if staleLimit <= 0 {
+		staleLimit = config.DefaultFailsafeStale * time.Millisecond
+	}
 	ctx, cancel := context.WithCancel(context.Background())
 	conn := &Connection{
 		SeatNumber:     seatNum,
+		UserId:         userId,
 		Socket:         socketConn,
 		PeerConnection: peerConn,
 		Ctx:            ctx,
@@ -44,30 +102,119 @@ func NewConnection(seatNum int, socketConn socketio.Conn, commandChan chan model
 		CommandChannel: commandChan,
 		HudChannel:     hudChan,
 		Speaker:        speakers,
+		Recorder:       recorder,
+		Failsafe:       failsafe,
+		Battery:        battery,
+		Emit:           emit,
+		onDisconnect:   onDisconnect,
+		buttons:        buttons,
 		PingInput:      make(chan int64, 10),
+		outputs:        make(map[string]*webrtc.DataChannel, 3),
+		cams:           make(map[string]*cam.Cam, len(cams)),
+		staleLimit:     staleLimit,
+		clock:          clocksync.NewEstimator(clocksync.DefaultWindow),
+		maxCommandAge:  maxCommandAge,
+	}
+	for i := range cams {
+		conn.cams[cams[i].VideoTrack.ID()] = cams[i]
 	}
 	return conn, nil
 }
 
+// newButtons checks the configured buttons are real and not read by the vehicle, a shared button would
+// switch cameras or toggle recording on every shift or trim
+func newButtons(cfg config.VehicleConfig, vehicleButtons []int) (Buttons, error) {
+	buttons := Buttons{CamSwitch: cfg.CamSwitchButton, Record: cfg.RecordButton}
+	named := []struct {
+		name   string
+		button int
+	}{
+		{name: "CAMSWITCH_BUTTON", button: buttons.CamSwitch},
+		{name: "RECORD_BUTTON", button: buttons.Record},
+	}
+	for _, n := range named {
+		if n.button < 0 {
+			continue
+		}
+		if n.button >= maxButtons {
+			return Buttons{}, fmt.Errorf("%s %d is past the last button %d", n.name, n.button, maxButtons-1)
+		}
+		if slices.Contains(vehicleButtons, n.button) {
+			return Buttons{}, fmt.Errorf("%s %d is used by the %s vehicle", n.name, n.button, cfg.VehicleType)
+		}
+	}
+	if buttons.CamSwitch >= 0 && buttons.CamSwitch == buttons.Record {
+		return Buttons{}, fmt.Errorf("CAMSWITCH_BUTTON and RECORD_BUTTON are both %d", buttons.Record)
+	}
+	return buttons, nil
+}
+
+// pressed reports whether a button in use went down
+func (b Buttons) pressed(button int, pressed uint32) bool {
+	return button >= 0 && pressed&(1<<button) != 0
+}
+
+// Disconnect tears the connection down once, closing the peer connection calls back in here from pion
 func (c *Connection) Disconnect() {
-	log.Println("user disconnecting from seat %d", c.SeatNumber)
+	if !c.disconnected.CompareAndSwap(false, true) {
+		return
+	}
+	log.Printf("user disconnecting from seat %d\n", c.SeatNumber)
 	c.CtxCancel()
 	c.PeerConnection.Close()
+	for _, cam := range c.cams {
+		cam.RemovePeer(c.peerName())
+	}
+	if c.onDisconnect != nil {
+		c.onDisconnect(c)
+	}
+}
+
+// output returns the open data channel with the given label, nil until it opens
+func (c *Connection) output(label string) *webrtc.DataChannel {
+	c.outputLock.RLock()
+	defer c.outputLock.RUnlock()
+	return c.outputs[label]
+}
+
+func (c *Connection) setOutput(label string, d *webrtc.DataChannel) {
+	c.outputLock.Lock()
+	defer c.outputLock.Unlock()
+	c.outputs[label] = d
+}
+
+// peerName identifies this connection in camera quality feedback
+func (c *Connection) peerName() string {
+	return fmt.Sprintf("seat%d", c.SeatNumber)
 }
 
 func (c *Connection) RegisterHandlers(audioTracks []*webrtc.TrackLocalStaticSample, videoTracks []*webrtc.TrackLocalStaticSample) error {
 
 	log.Println("adding car audio tracks")
-	_, err := c.PeerConnection.AddTrack(audioTracks[0]) //TODO add all audio tracks
-	if err != nil {
-		return fmt.Errorf("error adding audio track: %w", err)
+	for i := range audioTracks {
+		sender, err := c.PeerConnection.AddTrack(audioTracks[i])
+		if err != nil {
+			return fmt.Errorf("error adding audio track %s: %w", audioTracks[i].ID(), err)
+		}
+		go c.readRTCP(sender, audioTracks[i].ID())
 	}
 
 	log.Println("adding car video tracks")
-	_, err = c.PeerConnection.AddTrack(videoTracks[0]) //TODO add all video tracks
-	if err != nil {
-		return fmt.Errorf("error adding video track: %w", err)
+	c.camLock.Lock()
+	c.videoTrackIDs = make([]string, 0, len(videoTracks))
+	for i := range videoTracks {
+		sender, err := c.PeerConnection.AddTrack(videoTracks[i])
+		if err != nil {
+			c.camLock.Unlock()
+			return fmt.Errorf("error adding video track %s: %w", videoTracks[i].ID(), err)
+		}
+		c.videoTrackIDs = append(c.videoTrackIDs, videoTracks[i].ID())
+		go c.readRTCP(sender, videoTracks[i].ID())
+	}
+	if len(c.videoTrackIDs) > 0 {
+		c.primaryCam = c.SeatNumber % len(c.videoTrackIDs) //passengers start on a different camera when there is one
 	}
+	c.camLock.Unlock()
 
 	log.Println("set user audio track player")
 	c.PeerConnection.OnTrack(c.Speaker) //TODO: Update this to kick out video tracks
@@ -86,13 +233,96 @@ func (c *Connection) RegisterHandlers(audioTracks []*webrtc.TrackLocalStaticSamp
 	return nil
 }
 
+// readRTCP drains rtcp from a sender, pion interceptors only see packets that are read. Feedback for camera
+// tracks is handed to the camera for quality adaption.
+func (c *Connection) readRTCP(sender *webrtc.RTPSender, trackID string) {
+	cam := c.cams[trackID]
+	for {
+		packets, _, err := sender.ReadRTCP()
+		if err != nil {
+			log.Printf("stopped reading rtcp for %s on seat %d: %s\n", trackID, c.SeatNumber, err.Error())
+			return
+		}
+		if cam != nil {
+			cam.OnRTCP(c.peerName(), packets)
+		}
+	}
+}
+
+// videoTier returns the quality tier name of the primary camera
+func (c *Connection) videoTier() string {
+	cam, ok := c.cams[c.cameraState().Primary]
+	if !ok {
+		return "none"
+	}
+	return cam.Tier().Name
+}
+
+// SelectCamera makes the given camera the primary camera for this seat
+func (c *Connection) SelectCamera(index int) error {
+	c.camLock.Lock()
+	if index < 0 || index >= len(c.videoTrackIDs) {
+		c.camLock.Unlock()
+		return fmt.Errorf("camera %d not available", index)
+	}
+	c.primaryCam = index
+	c.camLock.Unlock()
+
+	log.Printf("seat %d switched to camera %d\n", c.SeatNumber, index)
+	return c.sendCameraState()
+}
+
+// NextCamera cycles the primary camera for this seat
+func (c *Connection) NextCamera() error {
+	c.camLock.RLock()
+	count := len(c.videoTrackIDs)
+	next := c.primaryCam + 1
+	c.camLock.RUnlock()
+
+	if count == 0 {
+		return fmt.Errorf("no cameras available")
+	}
+	return c.SelectCamera(next % count)
+}
+
+func (c *Connection) cameraState() models.CameraState {
+	c.camLock.RLock()
+	defer c.camLock.RUnlock()
+
+	state := models.CameraState{
+		Index:  c.primaryCam,
+		Tracks: append([]string{}, c.videoTrackIDs...),
+	}
+	if c.primaryCam < len(c.videoTrackIDs) {
+		state.Primary = c.videoTrackIDs[c.primaryCam]
+	}
+	return state
+}
+
+func (c *Connection) sendCameraState() error {
+	cameraOutput := c.output("camera")
+	if cameraOutput == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(c.cameraState())
+	if err != nil {
+		return fmt.Errorf("failed marshalling camera state: %w", err)
+	}
+	return cameraOutput.Send(data)
+}
+
 func (c *Connection) StartUserUpdater() {
 	go func() {
+		defer c.Failsafe.RecoverPanic()
 		pingTicker := time.NewTicker(1 * time.Second)
 		hudTicker := time.NewTicker(33 * time.Millisecond) //30hz
+		failsafeTicker := time.NewTicker(c.staleLimit / 2)
+		defer failsafeTicker.Stop()
 		sent := true
 		hudToSend := models.Hud{}
 		lastPing := int64(0)
+		lastHudSent := time.Time{}
 		for {
 			select {
 			case <-c.Ctx.Done():
@@ -100,20 +330,40 @@ func (c *Connection) StartUserUpdater() {
 				return
 			case hud, ok := <-c.HudChannel:
 				if !ok {
-					log.Println("hud channel closed for seat %d", c.SeatNumber)
+					log.Printf("hud channel closed for seat %d\n", c.SeatNumber)
 					return
 				}
-				if c.HudOutput != nil {
+				if c.output("hud") != nil {
 					hudToSend = hud
 					sent = false
 				}
+			case now := <-failsafeTicker.C:
+				c.checkCommandsStopped(now)
 			case <-pingTicker.C:
-				if c.PingOutput != nil {
+				hudOutput := c.output("hud")
+				if hudOutput != nil && time.Since(lastHudSent) > time.Second {
+					//the seat stops sending hud updates once inactive, keep showing why the car is not responding
+					failsafeAlerts := c.Failsafe.HudAlerts()
+					if len(failsafeAlerts) > 0 {
+						failsafeHud := models.Hud{Version: models.HudVersion, Alerts: failsafeAlerts}
+						failsafeHud.Lines = hud.Render(failsafeHud)
+						encodedMsg, err := encode(failsafeHud)
+						if err == nil {
+							err = hudOutput.SendText(encodedMsg)
+						}
+						if err != nil {
+							log.Printf("error: failed sending failsafe hud: error - %s\n", err.Error())
+						}
+						lastHudSent = time.Now()
+					}
+				}
+				pingOutput := c.output("ping")
+				if pingOutput != nil {
 					data, err := json.Marshal(models.Ping{
 						TimeStamp: time.Now().UnixMilli(),
 						Source:    PingSourceName,
 					})
-					err = c.PingOutput.Send(data)
+					err = pingOutput.Send(data)
 					if err != nil {
 						log.Printf("error: failed sending ping: error - %s\n", err.Error())
 						continue
@@ -121,18 +371,18 @@ func (c *Connection) StartUserUpdater() {
 				}
 			case recievedPing, ok := <-c.PingInput:
 				if !ok {
-					log.Println("ping channel closed for seat %d", c.SeatNumber)
+					log.Printf("ping channel closed for seat %d\n", c.SeatNumber)
 					return
 				}
 				lastPing = recievedPing
 			case <-hudTicker.C:
-				if !sent && c.HudOutput != nil {
-					if len(hudToSend.Lines) > 0 {
-						hudToSend.Lines[0] = fmt.Sprintf("%s | Ping:%dms", hudToSend.Lines[0], lastPing)
-					}
+				hudOutput := c.output("hud")
+				if !sent && hudOutput != nil {
+					c.fillHud(&hudToSend, lastPing)
 					encodedMsg, err := encode(hudToSend)
 					sent = true
-					err = c.HudOutput.SendText(encodedMsg)
+					lastHudSent = time.Now()
+					err = hudOutput.SendText(encodedMsg)
 					if err != nil {
 						log.Printf("error: failed sending hud: error - %s\n", err.Error())
 						continue
@@ -142,3 +392,105 @@ func (c *Connection) StartUserUpdater() {
 		}
 	}()
 }
+
+// failsafeReason is the failsafe reason for a link problem on this seat. Reasons are shared by every
+// connection to the seat, so a new connection clears what the old one left engaged.
+func (c *Connection) failsafeReason(problem string) string {
+	return fmt.Sprintf("seat %d %s", c.SeatNumber, problem)
+}
+
+// engageFailsafe reports a lost control link. It stays engaged until the seat sends commands again, so the
+// car also brakes when the driver leaves. Only the driver seat moves the car, passengers fall back to their
+// own seat timeout.
+func (c *Connection) engageFailsafe(problem string) {
+	if c.SeatNumber != DriverSeatNum {
+		return
+	}
+	c.Failsafe.Engage(c.failsafeReason(problem))
+}
+
+// clearFailsafe releases every link reason for this seat after a fresh command arrives
+func (c *Connection) clearFailsafe() {
+	for _, problem := range linkProblems {
+		c.Failsafe.Clear(c.failsafeReason(problem))
+	}
+}
+
+// commandIsStale compares how long the command took to arrive against the quickest command seen on this
+// connection. Browser and car clocks are not synced, but the difference between the two cancels out.
+func (c *Connection) commandIsStale(state models.ControlState, at time.Time) bool {
+	c.commandLock.Lock()
+	defer c.commandLock.Unlock()
+
+	c.lastCommandAt = at
+	if state.TimeStamp == 0 {
+		return false
+	}
+
+	delay := at.UnixMilli() - state.TimeStamp
+	if !c.haveDelay || delay < c.minCommandDelay {
+		c.minCommandDelay = delay
+		c.haveDelay = true
+	}
+	return time.Duration(delay-c.minCommandDelay)*time.Millisecond > c.staleLimit
+}
+
+// commandTooOld drops commands the browser sent more than maxCommandAge ago on the synced clock. Until the
+// ping channel has synced the clock every command is kept and only the relative stale check applies.
+func (c *Connection) commandTooOld(state models.ControlState, at time.Time) bool {
+	if state.TimeStamp == 0 {
+		return false
+	}
+	sentAt, ok := c.clock.Local(state.TimeStamp)
+	if !ok {
+		return false
+	}
+	age := max(at.Sub(sentAt), 0) //the offset is only known to within the one way delay
+
+	c.commandLock.Lock()
+	defer c.commandLock.Unlock()
+	c.inputLatency = age
+	tooOld := c.maxCommandAge > 0 && age > c.maxCommandAge
+	if tooOld {
+		c.oldCommands++
+		if !c.droppingOld {
+			log.Printf("warning: dropping commands older than %s for seat %d: %s old\n", c.maxCommandAge, c.SeatNumber, age.Round(time.Millisecond))
+		}
+	} else if c.droppingOld {
+		log.Printf("commands for seat %d back under %s, dropped %d\n", c.SeatNumber, c.maxCommandAge, c.oldCommands)
+	}
+	c.droppingOld = tooOld
+	return tooOld
+}
+
+// fillHud adds what the connection knows to a seat hud and renders the lines for older clients
+func (c *Connection) fillHud(seatHud *models.Hud, lastPing int64) {
+	seatHud.Version = models.HudVersion
+	if seatHud.Link == nil {
+		seatHud.Link = &models.HudLink{}
+	}
+	seatHud.Link.Ping = hud.Latency(lastPing, PingWarningThreshold.Milliseconds())
+	if c.clockSynced.Load() {
+		c.commandLock.Lock()
+		inputLatency := c.inputLatency
+		c.commandLock.Unlock()
+		seatHud.Link.Input = hud.Latency(inputLatency.Milliseconds(), c.maxCommandAge.Milliseconds())
+	}
+	seatHud.Camera = c.cameraState().Primary
+	seatHud.Video = c.videoTier()
+	seatHud.Recording = c.Recorder.Recording()
+	c.Battery.Hud(seatHud)
+	seatHud.Alerts = append(seatHud.Alerts, c.Failsafe.HudAlerts()...)
+	seatHud.Lines = hud.Render(*seatHud)
+}
+
+// checkCommandsStopped engages failsafe when an open command channel goes quiet
+func (c *Connection) checkCommandsStopped(now time.Time) {
+	c.commandLock.Lock()
+	lastCommandAt := c.lastCommandAt
+	c.commandLock.Unlock()
+
+	if !lastCommandAt.IsZero() && now.Sub(lastCommandAt) > c.staleLimit {
+		c.engageFailsafe(FailsafeCommandsStopped)
+	}
+}
@@ -0,0 +1,52 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/Speshl/gorrc_client/internal/config"
+	"github.com/Speshl/gorrc_client/internal/vehicle/crawler"
+	smallracer "github.com/Speshl/gorrc_client/internal/vehicle/smallRacer"
+)
+
+func TestNewButtons(t *testing.T) {
+	tests := []struct {
+		name      string
+		vehicle   string
+		camSwitch int
+		record    int
+		wantErr   bool
+	}{
+		{name: "defaults on the small racer", vehicle: "smallracer", camSwitch: config.DefaultCamSwitchButton, record: config.DefaultRecordButton},
+		{name: "defaults on the crawler", vehicle: "crawler", camSwitch: config.DefaultCamSwitchButton, record: config.DefaultRecordButton},
+		{name: "free on the crawler only", vehicle: "crawler", camSwitch: smallracer.SixthGear, record: -1},
+		{name: "shift on the small racer", vehicle: "smallracer", camSwitch: smallracer.SixthGear, record: config.DefaultRecordButton, wantErr: true},
+		{name: "trim on the crawler", vehicle: "crawler", camSwitch: config.DefaultCamSwitchButton, record: crawler.TrimLeft, wantErr: true},
+		{name: "both the same", vehicle: "crawler", camSwitch: 15, record: 15, wantErr: true},
+		{name: "both off", vehicle: "crawler", camSwitch: -1, record: -1},
+		{name: "past the last button", vehicle: "crawler", camSwitch: 32, record: -1, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := config.VehicleConfig{VehicleType: test.vehicle, CamSwitchButton: test.camSwitch, RecordButton: test.record}
+			buttons, err := newButtons(cfg, vehicleButtons(test.vehicle))
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", buttons)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if buttons.CamSwitch != test.camSwitch || buttons.Record != test.record {
+				t.Fatalf("expected buttons %d and %d, got %+v", test.camSwitch, test.record, buttons)
+			}
+		})
+	}
+
+	off := Buttons{CamSwitch: -1, Record: 14}
+	if off.pressed(off.CamSwitch, 1<<31|1) || !off.pressed(off.Record, 1<<14) {
+		t.Fatal("expected only buttons in use to be pressed")
+	}
+}
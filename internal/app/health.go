@@ -0,0 +1,137 @@
+package app
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Speshl/gorrc_client/internal/models"
+	socketio "github.com/googollee/go-socket.io"
+)
+
+const ServerHoldReason = "server connection lost"
+
+type healthState struct {
+	lock    sync.Mutex
+	seq     int64 //last health check sent
+	replied int64 //last health check the server answered
+	missed  int
+	latency time.Duration
+	outage  bool
+}
+
+// startHealthChecks sends car_healthy on an interval and expects car_healthy_reply back with the same seq.
+// After too many missed replies the car is held centered and stops taking new users until the server
+// answers again. Misses only count once the server has replied, older servers do not send the reply.
+func (a *App) startHealthChecks(ctx context.Context) error {
+	interval := time.Duration(a.cfg.ServerCfg.HealthInterval) * time.Millisecond
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+
+	healthTicker := time.NewTicker(interval)
+	defer healthTicker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("health checker stopped")
+			return ctx.Err()
+		case <-healthTicker.C:
+			a.health.lock.Lock()
+			if a.health.replied == 0 { //servers without car_healthy_reply never answer, only count once one has
+				if a.health.seq == 1 {
+					log.Println("server has not answered a health check, missed checks are not counted until it does")
+				}
+			} else if a.health.seq > a.health.replied {
+				a.health.missed++
+				log.Printf("warning: server missed health check %d (%d in a row)\n", a.health.seq, a.health.missed)
+			}
+			startOutage := !a.health.outage && a.cfg.ServerCfg.HealthMissed > 0 && a.health.missed >= a.cfg.ServerCfg.HealthMissed
+			if startOutage {
+				a.health.outage = true
+			}
+			a.health.seq++
+			check := models.HealthCheck{
+				Seq:       a.health.seq,
+				TimeStamp: time.Now().UnixMilli(),
+			}
+			a.health.lock.Unlock()
+
+			if startOutage {
+				a.onServerOutage(ctx)
+			}
+
+			encodedMsg, err := encode(check)
+			if err != nil {
+				log.Printf("error: failed encoding health check: %s\n", err.Error())
+				continue
+			}
+			a.emit("car_healthy", encodedMsg)
+		}
+	}
+}
+
+func (a *App) onHealthyReply(socketConn socketio.Conn, msgs []string) {
+	if len(msgs) != 1 {
+		log.Printf("error: health reply from %s had to many msgs: %d\n", socketConn.ID(), len(msgs))
+		return
+	}
+
+	reply := models.HealthCheck{}
+	err := decode(msgs[0], &reply)
+	if err != nil {
+		log.Printf("error: health reply from %s failed unmarshaling: %s\n", socketConn.ID(), msgs[0])
+		return
+	}
+
+	a.health.lock.Lock()
+	if reply.Seq <= a.health.replied || reply.Seq > a.health.seq {
+		a.health.lock.Unlock()
+		return //late or unknown reply
+	}
+	a.health.replied = reply.Seq
+	a.health.missed = 0
+	a.health.latency = time.Since(time.UnixMilli(reply.TimeStamp))
+	recovered := a.health.outage
+	a.health.outage = false
+	latency := a.health.latency
+	a.health.lock.Unlock()
+
+	if latency > PingWarningThreshold {
+		log.Printf("warning: server health check %d latency %dms\n", reply.Seq, latency.Milliseconds())
+	}
+	if recovered {
+		a.onServerRecovered()
+	}
+}
+
+func (a *App) onServerOutage(ctx context.Context) {
+	log.Printf("error: server did not answer %d health checks, holding car\n", a.cfg.ServerCfg.HealthMissed)
+	a.ready.Store(false)
+	a.vehicle.Hold(ServerHoldReason)
+
+	if !a.cfg.ServerCfg.SilentConnect {
+		go func() {
+			err := a.speaker.Play(ctx, "server_disconnected")
+			if err != nil {
+				log.Printf("failed playing server disconnected sound: %s\n", err.Error())
+			}
+		}()
+	}
+}
+
+func (a *App) onServerRecovered() {
+	log.Println("server answering health checks again, releasing car")
+	a.vehicle.Release(ServerHoldReason)
+	a.ready.Store(true)
+
+	if !a.cfg.ServerCfg.SilentConnect {
+		go func() {
+			err := a.speaker.Play(a.ctx, "server_connected")
+			if err != nil {
+				log.Printf("failed playing server connected sound: %s\n", err.Error())
+			}
+		}()
+	}
+}
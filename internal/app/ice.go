@@ -0,0 +1,228 @@
+package app
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/Speshl/gorrc_client/internal/config"
+	"github.com/Speshl/gorrc_client/internal/models"
+	"github.com/pion/ice/v2"
+	"github.com/pion/interceptor"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	ICEPolicyAll   = "all"
+	ICEPolicyRelay = "relay" //only turn candidates, for cars behind carrier nat
+
+	MDNSDisabled = "disabled"
+	MDNSQuery    = "query"  //accept the user's mdns candidates, gather plain ips
+	MDNSGather   = "gather" //also hide our host candidates behind mdns names
+)
+
+// newPeerConn is the PeerFactory for every user, peer connections keep the ice config they were made with
+func (a *App) newPeerConn() (*webrtc.PeerConnection, error) {
+	a.iceLock.RLock()
+	iceConfig := a.iceConfig
+	a.iceLock.RUnlock()
+	return a.api.NewPeerConnection(iceConfig)
+}
+
+// newAPI builds the webrtc api shared by every seat, with the network settings from the config and only the
+// given codecs. The udp mux is returned so it can be closed on shutdown, nil when not used.
+func newAPI(cfg config.ICEConfig, mediaEngine *webrtc.MediaEngine) (*webrtc.API, ice.UDPMux, error) {
+	settings := webrtc.SettingEngine{}
+
+	interfaceFilter := func(name string) bool {
+		return slices.Contains(cfg.Interfaces, name)
+	}
+	if len(cfg.Interfaces) > 0 {
+		settings.SetInterfaceFilter(interfaceFilter)
+	}
+
+	ipFilter, err := newIPFilter(cfg.IPs)
+	if err != nil {
+		return nil, nil, err
+	}
+	if ipFilter != nil {
+		settings.SetIPFilter(ipFilter)
+	}
+
+	if len(cfg.NAT1To1IPs) > 0 {
+		candidateType := webrtc.ICECandidateTypeHost
+		switch cfg.NAT1To1Type {
+		case "host":
+		case "srflx":
+			candidateType = webrtc.ICECandidateTypeSrflx
+		default:
+			return nil, nil, fmt.Errorf("unknown nat 1:1 candidate type %s, expected host or srflx", cfg.NAT1To1Type)
+		}
+		settings.SetNAT1To1IPs(cfg.NAT1To1IPs, candidateType)
+	}
+
+	switch cfg.MDNS {
+	case MDNSDisabled:
+		settings.SetICEMulticastDNSMode(ice.MulticastDNSModeDisabled)
+	case MDNSQuery, "":
+		settings.SetICEMulticastDNSMode(ice.MulticastDNSModeQueryOnly)
+	case MDNSGather:
+		settings.SetICEMulticastDNSMode(ice.MulticastDNSModeQueryAndGather)
+	default:
+		return nil, nil, fmt.Errorf("unknown mdns mode %s, expected %s, %s or %s", cfg.MDNS, MDNSDisabled, MDNSQuery, MDNSGather)
+	}
+
+	if cfg.PortMin != 0 || cfg.PortMax != 0 {
+		if cfg.PortMin < 1 || cfg.PortMax > 65535 || cfg.PortMin > cfg.PortMax {
+			return nil, nil, fmt.Errorf("invalid udp port range %d-%d", cfg.PortMin, cfg.PortMax)
+		}
+		err = settings.SetEphemeralUDPPortRange(uint16(cfg.PortMin), uint16(cfg.PortMax))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed setting udp port range: %w", err)
+		}
+	}
+
+	var udpMux ice.UDPMux
+	if cfg.UDPMuxPort != 0 {
+		if cfg.PortMin != 0 || cfg.PortMax != 0 {
+			log.Println("warning: udp mux port set, the udp port range is not used")
+		}
+		options := make([]ice.UDPMuxFromPortOption, 0, 2)
+		if len(cfg.Interfaces) > 0 {
+			options = append(options, ice.UDPMuxFromPortWithInterfaceFilter(interfaceFilter))
+		}
+		if ipFilter != nil {
+			options = append(options, ice.UDPMuxFromPortWithIPFilter(ipFilter))
+		}
+		udpMux, err = ice.NewMultiUDPMuxFromPort(cfg.UDPMuxPort, options...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed opening udp mux on port %d: %w", cfg.UDPMuxPort, err)
+		}
+		settings.SetICEUDPMux(udpMux)
+		log.Printf("sharing udp port %d across all peer connections\n", cfg.UDPMuxPort)
+	}
+
+	//same interceptors webrtc.NewPeerConnection uses
+	interceptors := &interceptor.Registry{}
+	err = webrtc.RegisterDefaultInterceptors(mediaEngine, interceptors)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed registering interceptors: %w", err)
+	}
+
+	api := webrtc.NewAPI(
+		webrtc.WithSettingEngine(settings),
+		webrtc.WithMediaEngine(mediaEngine),
+		webrtc.WithInterceptorRegistry(interceptors),
+	)
+	return api, udpMux, nil
+}
+
+// newIPFilter allows the listed ips and cidrs, nil when every ip is allowed
+func newIPFilter(allowed []string) (func(net.IP) bool, error) {
+	if len(allowed) == 0 {
+		return nil, nil
+	}
+
+	networks := make([]*net.IPNet, 0, len(allowed))
+	for _, entry := range allowed {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid ice ip filter %s", entry)
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ice ip filter %s: %w", entry, err)
+		}
+		networks = append(networks, network)
+	}
+
+	return func(ip net.IP) bool {
+		for _, network := range networks {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// setICEConfig replaces the ice config used for new peer connections
+func (a *App) setICEConfig(iceConfig webrtc.Configuration, source string) {
+	a.iceLock.Lock()
+	defer a.iceLock.Unlock()
+	a.iceConfig = iceConfig
+	log.Printf("using ice config from %s: %d servers, %s transport policy\n", source, len(iceConfig.ICEServers), iceConfig.ICETransportPolicy)
+}
+
+func configICE(cfg config.ICEConfig) webrtc.Configuration {
+	servers := make([]webrtc.ICEServer, 0, len(cfg.Servers))
+	for _, server := range cfg.Servers {
+		servers = append(servers, iceServer(server.URLs, server.Username, server.Credential))
+	}
+	return iceConfiguration(servers, cfg.TransportPolicy)
+}
+
+func serverICE(cfg models.ICEConfig) webrtc.Configuration {
+	servers := make([]webrtc.ICEServer, 0, len(cfg.Servers))
+	for _, server := range cfg.Servers {
+		servers = append(servers, iceServer(server.URLs, server.Username, server.Credential))
+	}
+	return iceConfiguration(servers, cfg.TransportPolicy)
+}
+
+func iceServer(urls []string, username, credential string) webrtc.ICEServer {
+	server := webrtc.ICEServer{
+		URLs: urls,
+	}
+	if username != "" || credential != "" {
+		server.Username = username
+		server.Credential = credential
+		server.CredentialType = webrtc.ICECredentialTypePassword
+	}
+	for _, url := range urls {
+		if strings.HasPrefix(url, "turn") && (username == "" || credential == "") {
+			log.Printf("warning: turn server %s has no username or credential, peer connections will fail\n", url)
+		}
+	}
+	return server
+}
+
+func iceConfiguration(servers []webrtc.ICEServer, policy string) webrtc.Configuration {
+	iceConfig := webrtc.Configuration{
+		ICEServers:         servers,
+		ICETransportPolicy: webrtc.ICETransportPolicyAll,
+	}
+
+	switch strings.ToLower(policy) {
+	case ICEPolicyAll, "":
+	case ICEPolicyRelay:
+		iceConfig.ICETransportPolicy = webrtc.ICETransportPolicyRelay
+		if !hasTURN(servers) {
+			log.Println("warning: relay only ice policy without a turn server, users will not be able to connect")
+		}
+	default:
+		log.Printf("warning: unknown ice transport policy %s, using %s\n", policy, ICEPolicyAll)
+	}
+
+	if len(servers) == 0 {
+		log.Println("no ice servers configured, only host candidates will be gathered")
+	}
+	return iceConfig
+}
+
+func hasTURN(servers []webrtc.ICEServer) bool {
+	for _, server := range servers {
+		for _, url := range server.URLs {
+			if strings.HasPrefix(url, "turn") {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,317 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Speshl/gorrc_client/internal/models"
+	"github.com/google/uuid"
+	socketio "github.com/googollee/go-socket.io"
+	"github.com/pion/webrtc/v3"
+)
+
+type ConnectionEventType string
+
+const (
+	EventSeatAssigned ConnectionEventType = "seat_assigned"
+	EventSeatReleased ConnectionEventType = "seat_released"
+	EventPeerCreated  ConnectionEventType = "peer_created"
+	EventPeerClosed   ConnectionEventType = "peer_closed"
+
+	NoSeat = -1
+
+	SeatPolicyKeep  = "keep"  //the first user keeps the seat, others are turned away
+	SeatPolicyQueue = "queue" //others wait for the seat in order
+
+	MaxPendingCandidates = 64 //per user, candidates for an offer that never comes are not kept forever
+)
+
+var ErrSeatBusy = errors.New("seat is taken by another user")
+
+type ConnectionEvent struct {
+	Type   ConnectionEventType
+	Seat   int //NoSeat for peer events
+	UserId uuid.UUID
+}
+
+type PeerFactory func() (*webrtc.PeerConnection, error)
+
+// waitingOffer is an offer held until its seat frees up
+type waitingOffer struct {
+	socketConn socketio.Conn
+	offer      models.Offer
+	queuedAt   time.Time
+}
+
+// ConnectionRegistry owns which connection sits in each seat and the peer connection of each user. Offers
+// and candidates arrive on socket.io goroutines, disconnects on pion goroutines and the supervisor closes
+// everything from its own, so all of them go through here.
+type ConnectionRegistry struct {
+	lock      sync.Mutex
+	seats     []*Connection
+	peers     map[uuid.UUID]*webrtc.PeerConnection
+	waiting   [][]waitingOffer                        //per seat, oldest first
+	pending   map[uuid.UUID][]webrtc.ICECandidateInit //remote candidates that beat the offer
+	listeners []func(ConnectionEvent)
+}
+
+func NewConnectionRegistry(seatCount int) *ConnectionRegistry {
+	return &ConnectionRegistry{
+		seats:   make([]*Connection, seatCount),
+		peers:   make(map[uuid.UUID]*webrtc.PeerConnection, seatCount),
+		waiting: make([][]waitingOffer, seatCount),
+		pending: make(map[uuid.UUID][]webrtc.ICECandidateInit, seatCount),
+	}
+}
+
+// OnEvent registers a listener for seat and peer changes, listeners are called without the lock held
+func (r *ConnectionRegistry) OnEvent(listener func(ConnectionEvent)) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.listeners = append(r.listeners, listener)
+}
+
+func (r *ConnectionRegistry) notify(events ...ConnectionEvent) {
+	r.lock.Lock()
+	listeners := append([]func(ConnectionEvent){}, r.listeners...)
+	r.lock.Unlock()
+
+	for _, event := range events {
+		for _, listener := range listeners {
+			listener(event)
+		}
+	}
+}
+
+// Peer returns the user's open peer connection, creating one when there is none
+func (r *ConnectionRegistry) Peer(userId uuid.UUID, create PeerFactory) (*webrtc.PeerConnection, error) {
+	r.lock.Lock()
+	peerConn, ok := r.peers[userId]
+	if ok && peerConn.ConnectionState() != webrtc.PeerConnectionStateClosed {
+		r.lock.Unlock()
+		return peerConn, nil
+	}
+
+	log.Printf("creating new peer connection for user %s\n", userId)
+	peerConn, err := create()
+	if err != nil {
+		r.lock.Unlock()
+		return nil, fmt.Errorf("failed creating peer connection for user %s: %w", userId, err)
+	}
+	r.peers[userId] = peerConn
+	r.lock.Unlock()
+
+	r.notify(ConnectionEvent{Type: EventPeerCreated, Seat: NoSeat, UserId: userId})
+	return peerConn, nil
+}
+
+// NewPeer closes any peer connection the user had and creates a fresh one, an offer always starts over
+func (r *ConnectionRegistry) NewPeer(userId uuid.UUID, create PeerFactory) (*webrtc.PeerConnection, error) {
+	r.lock.Lock()
+	old, ok := r.peers[userId]
+	delete(r.peers, userId)
+	r.lock.Unlock()
+
+	if ok {
+		log.Printf("close and recreating peer connection for user %s\n", userId)
+		old.Close()
+		r.notify(ConnectionEvent{Type: EventPeerClosed, Seat: NoSeat, UserId: userId})
+	}
+	return r.Peer(userId, create)
+}
+
+// Busy reports whether a seat is held by a user other than the one asking, a takeover ignores the occupant
+func Busy(occupant *Connection, userId uuid.UUID, takeover bool) bool {
+	return occupant != nil && occupant.UserId != userId && !takeover
+}
+
+// AddCandidate applies a remote candidate to the user's peer connection, holding it until the offer has
+// been applied when the peer connection is not there or not ready for it yet
+func (r *ConnectionRegistry) AddCandidate(userId uuid.UUID, candidate webrtc.ICECandidateInit) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	peerConn, ok := r.peers[userId]
+	if ok && peerConn.RemoteDescription() != nil {
+		return peerConn.AddICECandidate(candidate)
+	}
+
+	if len(r.pending[userId]) >= MaxPendingCandidates {
+		return fmt.Errorf("too many candidates waiting for an offer, dropping")
+	}
+	r.pending[userId] = append(r.pending[userId], candidate)
+	return nil
+}
+
+// SetRemoteDescription applies the user's offer and any candidates that arrived ahead of it. Holding the
+// lock across both means a candidate is either buffered and applied here or applied directly, never lost.
+func (r *ConnectionRegistry) SetRemoteDescription(userId uuid.UUID, peerConn *webrtc.PeerConnection, desc webrtc.SessionDescription) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	err := peerConn.SetRemoteDescription(desc)
+	if err != nil {
+		return err
+	}
+
+	candidates := r.pending[userId]
+	delete(r.pending, userId)
+	for i := range candidates {
+		err = peerConn.AddICECandidate(candidates[i])
+		if err != nil {
+			log.Printf("error: failed adding buffered ice candidate for user %s: %s\n", userId, err.Error())
+		}
+	}
+	if len(candidates) > 0 {
+		log.Printf("applied %d buffered ice candidates for user %s\n", len(candidates), userId)
+	}
+	return nil
+}
+
+// Assign puts the connection in its seat and returns whoever was sitting there, the caller decides what
+// happens to them. A seat held by another user is only given up on a takeover, otherwise the occupant is
+// returned with ErrSeatBusy and the connection is left unseated.
+func (r *ConnectionRegistry) Assign(conn *Connection, takeover bool) (*Connection, error) {
+	r.lock.Lock()
+	if conn.SeatNumber < 0 || conn.SeatNumber >= len(r.seats) {
+		r.lock.Unlock()
+		return nil, fmt.Errorf("seat %d does not exist", conn.SeatNumber)
+	}
+	if conn.disconnected.Load() { //its Release may already have run, seating it now would leave it there
+		r.lock.Unlock()
+		return nil, fmt.Errorf("connection for seat %d already disconnected", conn.SeatNumber)
+	}
+	previous := r.seats[conn.SeatNumber]
+	if Busy(previous, conn.UserId, takeover) {
+		r.lock.Unlock()
+		return previous, ErrSeatBusy
+	}
+	r.seats[conn.SeatNumber] = conn
+	r.lock.Unlock()
+
+	r.notify(ConnectionEvent{Type: EventSeatAssigned, Seat: conn.SeatNumber, UserId: conn.UserId})
+	return previous, nil
+}
+
+// Seat returns the connection in a seat, nil when empty
+func (r *ConnectionRegistry) Seat(seat int) *Connection {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if seat < 0 || seat >= len(r.seats) {
+		return nil
+	}
+	return r.seats[seat]
+}
+
+// Connections returns every occupied seat
+func (r *ConnectionRegistry) Connections() []*Connection {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	conns := make([]*Connection, 0, len(r.seats))
+	for _, conn := range r.seats {
+		if conn != nil {
+			conns = append(conns, conn)
+		}
+	}
+	return conns
+}
+
+// Wait queues an offer for a taken seat and returns its place in line, starting at 1. A user already
+// waiting for the seat keeps their place with the newer offer.
+func (r *ConnectionRegistry) Wait(socketConn socketio.Conn, offer models.Offer) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if offer.SeatNumber < 0 || offer.SeatNumber >= len(r.waiting) {
+		return 0, fmt.Errorf("seat %d does not exist", offer.SeatNumber)
+	}
+
+	waiting := waitingOffer{
+		socketConn: socketConn,
+		offer:      offer,
+		queuedAt:   time.Now(),
+	}
+	for i := range r.waiting[offer.SeatNumber] {
+		if r.waiting[offer.SeatNumber][i].offer.UserId == offer.UserId {
+			r.waiting[offer.SeatNumber][i] = waiting
+			return i + 1, nil
+		}
+	}
+	r.waiting[offer.SeatNumber] = append(r.waiting[offer.SeatNumber], waiting)
+	return len(r.waiting[offer.SeatNumber]), nil
+}
+
+// NextWaiting takes the oldest queued offer for a seat, dropping any that waited longer than maxAge
+func (r *ConnectionRegistry) NextWaiting(seat int, maxAge time.Duration) (socketio.Conn, models.Offer, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if seat < 0 || seat >= len(r.waiting) {
+		return nil, models.Offer{}, false
+	}
+
+	for len(r.waiting[seat]) > 0 {
+		next := r.waiting[seat][0]
+		r.waiting[seat] = r.waiting[seat][1:]
+		if time.Since(next.queuedAt) <= maxAge {
+			return next.socketConn, next.offer, true
+		}
+		log.Printf("dropping queued offer from user %s for seat %d, waited %s\n", next.offer.UserId, seat, time.Since(next.queuedAt).Round(time.Millisecond))
+	}
+	return nil, models.Offer{}, false
+}
+
+// Release forgets a disconnected connection and its peer connection, unless either was already replaced
+func (r *ConnectionRegistry) Release(conn *Connection) {
+	events := make([]ConnectionEvent, 0, 2)
+
+	r.lock.Lock()
+	if conn.SeatNumber >= 0 && conn.SeatNumber < len(r.seats) && r.seats[conn.SeatNumber] == conn {
+		r.seats[conn.SeatNumber] = nil
+		events = append(events, ConnectionEvent{Type: EventSeatReleased, Seat: conn.SeatNumber, UserId: conn.UserId})
+	}
+	if r.peers[conn.UserId] == conn.PeerConnection {
+		delete(r.peers, conn.UserId)
+		delete(r.pending, conn.UserId)
+		events = append(events, ConnectionEvent{Type: EventPeerClosed, Seat: NoSeat, UserId: conn.UserId})
+	}
+	r.lock.Unlock()
+
+	r.notify(events...)
+}
+
+// CloseAll disconnects every seat, closes every peer connection and empties the seat queues
+func (r *ConnectionRegistry) CloseAll() {
+	r.lock.Lock()
+	events := make([]ConnectionEvent, 0, len(r.seats)+len(r.peers))
+	for i := range r.waiting { //queued offers are for peers being closed
+		r.waiting[i] = nil
+	}
+	clear(r.pending)
+	conns := make([]*Connection, 0, len(r.seats))
+	for i, conn := range r.seats {
+		if conn != nil {
+			conns = append(conns, conn)
+			events = append(events, ConnectionEvent{Type: EventSeatReleased, Seat: i, UserId: conn.UserId})
+			r.seats[i] = nil
+		}
+	}
+	peers := make([]*webrtc.PeerConnection, 0, len(r.peers))
+	for userId, peerConn := range r.peers {
+		peers = append(peers, peerConn)
+		events = append(events, ConnectionEvent{Type: EventPeerClosed, Seat: NoSeat, UserId: userId})
+		delete(r.peers, userId)
+	}
+	r.lock.Unlock()
+
+	for _, conn := range conns { //Release finds nothing left to do
+		conn.Disconnect()
+	}
+	for _, peerConn := range peers {
+		peerConn.Close()
+	}
+	r.notify(events...)
+	log.Println("closed all user connections")
+}
@@ -0,0 +1,263 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/pion/webrtc/v3"
+)
+
+func newTestPeer() (*webrtc.PeerConnection, error) {
+	return webrtc.NewPeerConnection(webrtc.Configuration{})
+}
+
+// newTestOffer is an offer from a browser like peer with one data channel, mid 0
+func newTestOffer(t *testing.T) webrtc.SessionDescription {
+	t.Helper()
+	browser, err := newTestPeer()
+	if err != nil {
+		t.Fatalf("failed creating browser peer: %s", err)
+	}
+	defer browser.Close()
+	_, err = browser.CreateDataChannel("command", nil)
+	if err != nil {
+		t.Fatalf("failed creating data channel: %s", err)
+	}
+	offer, err := browser.CreateOffer(nil)
+	if err != nil {
+		t.Fatalf("failed creating offer: %s", err)
+	}
+	return offer
+}
+
+func testCandidate(i int) webrtc.ICECandidateInit {
+	mid := "0"
+	return webrtc.ICECandidateInit{
+		Candidate: fmt.Sprintf("candidate:%d 1 udp 2130706431 192.0.2.%d 5%04d typ host", i+1, i%250+1, i),
+		SDPMid:    &mid,
+	}
+}
+
+func newTestConnection(r *ConnectionRegistry, seat int, userId uuid.UUID, peerConn *webrtc.PeerConnection) *Connection {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Connection{
+		SeatNumber:     seat,
+		UserId:         userId,
+		PeerConnection: peerConn,
+		Ctx:            ctx,
+		CtxCancel:      cancel,
+		onDisconnect:   r.Release,
+	}
+}
+
+func TestRegistryAssign(t *testing.T) {
+	r := NewConnectionRegistry(2)
+	first, second := uuid.New(), uuid.New()
+
+	firstPeer, err := r.NewPeer(first, newTestPeer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	firstConn := newTestConnection(r, 0, first, firstPeer)
+	previous, err := r.Assign(firstConn, false)
+	if err != nil || previous != nil {
+		t.Fatalf("expected an empty seat, got %v, %v", previous, err)
+	}
+
+	secondPeer, err := r.NewPeer(second, newTestPeer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondConn := newTestConnection(r, 0, second, secondPeer)
+	previous, err = r.Assign(secondConn, false)
+	if !errors.Is(err, ErrSeatBusy) || previous != firstConn {
+		t.Fatalf("expected the seat to be busy with the first user, got %v, %v", previous, err)
+	}
+	if r.Seat(0) != firstConn {
+		t.Fatal("expected the first user to keep the seat")
+	}
+
+	previous, err = r.Assign(secondConn, true)
+	if err != nil || previous != firstConn {
+		t.Fatalf("expected the takeover to return the first user, got %v, %v", previous, err)
+	}
+	firstConn.Disconnect() //the replaced connection releasing must not empty the seat
+	if r.Seat(0) != secondConn {
+		t.Fatal("expected the second user to keep the seat after the first disconnected")
+	}
+
+	secondConn.Disconnect()
+	if r.Seat(0) != nil {
+		t.Fatal("expected the seat to be empty")
+	}
+	if _, err = r.Assign(newTestConnection(r, 2, first, firstPeer), false); err == nil {
+		t.Fatal("expected an error for a seat that does not exist")
+	}
+}
+
+func TestRegistryCandidatesBeforeOffer(t *testing.T) {
+	r := NewConnectionRegistry(1)
+	userId := uuid.New()
+
+	for i := 0; i < 3; i++ { //candidates can beat the offer and even the peer connection
+		err := r.AddCandidate(userId, testCandidate(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	peerConn, err := r.NewPeer(userId, newTestPeer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peerConn.Close()
+	err = r.AddCandidate(userId, testCandidate(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.pending[userId]) != 4 {
+		t.Fatalf("expected 4 buffered candidates, got %d", len(r.pending[userId]))
+	}
+
+	err = r.SetRemoteDescription(userId, peerConn, newTestOffer(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.pending[userId]) != 0 {
+		t.Fatalf("expected the buffered candidates to be applied, %d left", len(r.pending[userId]))
+	}
+	err = r.AddCandidate(userId, testCandidate(4)) //straight to the peer connection now
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.pending[userId]) != 0 {
+		t.Fatal("expected a candidate after the offer not to be buffered")
+	}
+
+	other := uuid.New()
+	for i := 0; i < MaxPendingCandidates; i++ {
+		err = r.AddCandidate(other, testCandidate(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if r.AddCandidate(other, testCandidate(MaxPendingCandidates)) == nil {
+		t.Fatal("expected candidates past the limit to be refused")
+	}
+}
+
+// TestRegistryConcurrent runs offers, candidates, disconnects and shutdowns from many goroutines the way
+// socket.io, pion and the supervisor do, run it with -race
+func TestRegistryConcurrent(t *testing.T) {
+	const (
+		seats  = 2
+		users  = 8
+		rounds = 5
+	)
+	r := NewConnectionRegistry(seats)
+	var events atomic.Int64
+	r.OnEvent(func(ConnectionEvent) { events.Add(1) })
+
+	offers := make([]webrtc.SessionDescription, users)
+	userIds := make([]uuid.UUID, users)
+	for i := range offers {
+		offers[i] = newTestOffer(t)
+		userIds[i] = uuid.New()
+	}
+
+	var group sync.WaitGroup
+	for i := 0; i < users; i++ {
+		i := i
+		group.Add(2)
+		go func() { //candidates trickle in while the offer is handled
+			defer group.Done()
+			for round := 0; round < rounds; round++ {
+				_ = r.AddCandidate(userIds[i], testCandidate(round))
+			}
+		}()
+		go func() { //offer, seat and disconnect, again and again
+			defer group.Done()
+			for round := 0; round < rounds; round++ {
+				peerConn, err := r.NewPeer(userIds[i], newTestPeer)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				_ = r.SetRemoteDescription(userIds[i], peerConn, offers[i]) //fails when closed under it
+
+				conn := newTestConnection(r, i%seats, userIds[i], peerConn)
+				previous, err := r.Assign(conn, round%2 == 0)
+				if err == nil && previous != nil && previous.UserId != conn.UserId {
+					previous.Disconnect()
+				}
+				r.Seat(i % seats)
+				r.Connections()
+				if round%3 == 2 {
+					conn.Disconnect()
+				}
+			}
+		}()
+	}
+	group.Add(1)
+	go func() { //the supervisor closing everything on a server disconnect
+		defer group.Done()
+		for round := 0; round < rounds; round++ {
+			r.CloseAll()
+		}
+	}()
+	group.Wait()
+
+	r.CloseAll()
+	if len(r.Connections()) != 0 {
+		t.Fatalf("expected no seated connections, got %d", len(r.Connections()))
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if len(r.peers) != 0 || len(r.pending) != 0 {
+		t.Fatalf("expected no peers or pending candidates, got %d peers and %d pending", len(r.peers), len(r.pending))
+	}
+	if events.Load() == 0 {
+		t.Fatal("expected connection events")
+	}
+}
+
+// TestRegistryDisconnectWhileAssigning closes the peer from pion's side while socket.io seats the user, run
+// it with -race. Whichever wins, a disconnected connection must not be left in the seat.
+func TestRegistryDisconnectWhileAssigning(t *testing.T) {
+	const rounds = 50
+	r := NewConnectionRegistry(1)
+	userId := uuid.New()
+
+	for round := 0; round < rounds; round++ {
+		peerConn, err := r.NewPeer(userId, newTestPeer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn := newTestConnection(r, 0, userId, peerConn)
+
+		var group sync.WaitGroup
+		group.Add(2)
+		go func() {
+			defer group.Done()
+			conn.Disconnect()
+		}()
+		go func() {
+			defer group.Done()
+			_, _ = r.Assign(conn, true)
+		}()
+		group.Wait()
+
+		if r.Seat(0) != nil {
+			t.Fatalf("round %d: expected the disconnected connection to leave the seat empty", round)
+		}
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if len(r.peers) != 0 {
+		t.Fatalf("expected every closed peer to be released, got %d", len(r.peers))
+	}
+}
@@ -0,0 +1,61 @@
+package app
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/Speshl/gorrc_client/internal/config"
+	"github.com/Speshl/gorrc_client/internal/models"
+)
+
+const ShutdownReason = "shutting down"
+
+// shutdown takes the car out of service while the server and peers are still reachable, then cancels the
+// app context so the cameras, mic, vehicle loop and supervisor stop. Start finishes the rest once they
+// have. The process exits after the shutdown timeout if Start has not finished its cleanup by then.
+func (a *App) shutdown(reason string) {
+	a.shutdownOnce.Do(func() {
+		timeout := time.Duration(a.cfg.ServerCfg.ShutdownTimeout) * time.Millisecond
+		if timeout <= 0 {
+			timeout = config.DefaultShutdownTimeout * time.Millisecond
+		}
+		a.shutdownTimer.Store(time.AfterFunc(timeout, func() {
+			log.Printf("error: shutdown did not finish within %s, exiting\n", timeout)
+			os.Exit(1)
+		}))
+
+		log.Printf("shutting down: %s\n", reason)
+		a.ready.Store(false) //refuse offers that race the shutdown
+
+		log.Println("shutdown: applying failsafe")
+		a.vehicle.Hold(ShutdownReason)
+		a.failsafe.Engage(ShutdownReason) //written to the command driver before this returns
+
+		log.Println("shutdown: closing user connections")
+		a.conns.CloseAll()
+
+		log.Println("shutdown: notifying server")
+		encodedMsg, err := encode(models.DisconnectReq{
+			Key:    a.cfg.ServerCfg.Key,
+			Reason: reason,
+		})
+		if err != nil {
+			log.Printf("error: failed encoding car disconnect: %s\n", err.Error())
+		} else {
+			a.emit("car_disconnect", encodedMsg)
+		}
+
+		log.Println("shutdown: stopping cameras, mic and vehicle")
+		a.ctxCancel()
+	})
+}
+
+// finishShutdown stops the shutdown timer once Start has cleaned up, so a finished shutdown is not
+// reported as a crash
+func (a *App) finishShutdown() {
+	timer := a.shutdownTimer.Load()
+	if timer != nil {
+		timer.Stop()
+	}
+}
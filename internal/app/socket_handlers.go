@@ -1,16 +1,16 @@
 package app
 
 import (
-	"fmt"
+	"errors"
 	"log"
+	"time"
 
 	"github.com/Speshl/gorrc_client/internal/models"
-	"github.com/google/uuid"
 	socketio "github.com/googollee/go-socket.io"
-	"github.com/pion/webrtc/v3"
 )
 
 func (a *App) onOffer(socketConn socketio.Conn, msgs []string) {
+	defer a.failsafe.RecoverPanic()
 	log.Println("offer recieved")
 	if len(msgs) != 1 {
 		log.Printf("error: offer from %s had to many msgs: %d\n", socketConn.ID(), len(msgs))
@@ -24,78 +24,182 @@ func (a *App) onOffer(socketConn socketio.Conn, msgs []string) {
 		log.Printf("error: offer from %s failed unmarshaling: %s\n - msg - %s", socketConn.ID(), err.Error(), string(msg))
 		return
 	}
+	a.acceptOffer(socketConn, offer)
+}
+
+// acceptOffer seats the user and answers, unless the seat belongs to someone else and the offer is not a
+// priority takeover
+func (a *App) acceptOffer(socketConn socketio.Conn, offer models.Offer) {
+	if !a.ready.Load() {
+		log.Printf("error: refusing offer for seat %d, car is not ready\n", offer.SeatNumber)
+		return
+	}
 
 	if offer.SeatNumber < 0 || offer.SeatNumber >= a.cfg.ServerCfg.SeatCount || offer.SeatNumber >= len(a.seats) {
 		log.Printf("error: offer was for unsupported seat number: %d\n", offer.SeatNumber)
 		return
 	}
 
-	peerConn, err := a.CreatePeerConn(offer.UserId)
+	occupant := a.conns.Seat(offer.SeatNumber)
+	if Busy(occupant, offer.UserId, offer.Priority) { //turn them away before touching their peer connection
+		a.seatBusy(socketConn, offer, occupant)
+		return
+	}
+
+	err := a.codecs.checkOffer(offer.Offer)
+	if err != nil {
+		log.Printf("error: refusing offer for seat %d from user %s: %s\n", offer.SeatNumber, offer.UserId, err.Error())
+		a.offerError(offer, err)
+		return
+	}
+
+	peerConn, err := a.conns.NewPeer(offer.UserId, a.newPeerConn)
 	if err != nil {
 		log.Printf("error: failed getting or creating peer conn: %s\n", err.Error())
 		return
 	}
 
-	newConnection, err := NewConnection(offer.SeatNumber, socketConn, a.seats[offer.SeatNumber].CommandChannel, a.seats[offer.SeatNumber].HudChannel, a.speaker.TrackPlayer, peerConn)
+	//Release on disconnect also cleans up a connection that never gets a seat
+	newConnection, err := NewConnection(offer.SeatNumber, offer.UserId, socketConn, a.seats[offer.SeatNumber].CommandChannel, a.seats[offer.SeatNumber].HudChannel, a.speaker.TrackPlayer, peerConn, a.cams, a.recorder, a.failsafe, a.battery, a.buttons, a.emit, a.conns.Release, time.Duration(a.cfg.CommandCfg.FailsafeStale)*time.Millisecond, time.Duration(a.cfg.CommandCfg.CommandMaxAge)*time.Millisecond)
 	if err != nil {
 		log.Printf("error: failed creating connection on offer for seat %d: %s\n", offer.SeatNumber, err.Error())
 		return
 	}
-	a.userConns[offer.SeatNumber] = newConnection
+	previous, err := a.conns.Assign(newConnection, offer.Priority)
+	if errors.Is(err, ErrSeatBusy) { //someone else got the seat first
+		newConnection.Disconnect()
+		a.seatBusy(socketConn, offer, previous)
+		return
+	}
+	if err != nil {
+		log.Printf("error: failed assigning seat %d: %s\n", offer.SeatNumber, err.Error())
+		newConnection.Disconnect()
+		return
+	}
+	if previous != nil {
+		if previous.UserId != offer.UserId {
+			log.Printf("user %s took seat %d from user %s\n", offer.UserId, offer.SeatNumber, previous.UserId)
+			a.seatTaken(offer, previous)
+		} else {
+			log.Printf("replacing connection for seat %d\n", offer.SeatNumber)
+		}
+		previous.Disconnect()
+	}
 
 	log.Printf("registering handlers for seat %d\n", offer.SeatNumber)
 
-	err = a.userConns[offer.SeatNumber].RegisterHandlers(a.seats[offer.SeatNumber].AudioTracks, a.seats[offer.SeatNumber].VideoTracks)
+	err = newConnection.RegisterHandlers(a.seats[offer.SeatNumber].AudioTracks, a.seats[offer.SeatNumber].VideoTracks)
 	if err != nil {
 		log.Printf("error: failed registering handelers for seat %d: %s\n", offer.SeatNumber, err.Error())
 		return
 	}
 
 	log.Printf("setting remote description for seat %d\n", offer.SeatNumber)
-	err = a.userConns[offer.SeatNumber].PeerConnection.SetRemoteDescription(offer.Offer)
+	err = a.conns.SetRemoteDescription(offer.UserId, newConnection.PeerConnection, offer.Offer)
 	if err != nil {
 		log.Printf("error: failed to set remote description for seat %d: %s\n", offer.SeatNumber, err)
 		return
 	}
 
 	log.Printf("creating answer for seat %d\n", offer.SeatNumber)
-	answer, err := a.userConns[offer.SeatNumber].PeerConnection.CreateAnswer(nil)
+	answer, err := newConnection.PeerConnection.CreateAnswer(nil)
 	if err != nil {
 		log.Printf("error: failed to create answer for seat %d: %s\n", offer.SeatNumber, err)
 		return
 	}
 
-	// Create channel that is blocked until ICE Gathering is complete
-	gatherComplete := webrtc.GatheringCompletePromise(a.userConns[offer.SeatNumber].PeerConnection)
-
 	log.Printf("setting local description for seat %d\n", offer.SeatNumber)
 
-	// Sets the LocalDescription, and starts our UDP listeners
-	err = a.userConns[offer.SeatNumber].PeerConnection.SetLocalDescription(answer)
+	// Sets the LocalDescription, and starts our UDP listeners. Candidates trickle out as they are gathered.
+	err = newConnection.PeerConnection.SetLocalDescription(answer)
 	if err != nil {
 		log.Printf("error: failed to set local description for seat %d: %s\n", offer.SeatNumber, err.Error())
 		return
 	}
 
-	// Block until ICE Gathering is complete, disabling trickle ICE
-	// we do this because we only can exchange one signaling message
-	// in a production application you should exchange ICE Candidates via OnICECandidate
-	log.Printf("waiting for ice gathering for seat %d\n", offer.SeatNumber)
-	<-gatherComplete
-	log.Printf("ice gathering complete for seat %d\n", offer.SeatNumber)
-
 	answerReq := models.Answer{
-		Answer:     a.userConns[offer.SeatNumber].PeerConnection.LocalDescription(),
+		Answer:     newConnection.PeerConnection.LocalDescription(),
 		SeatNumber: offer.SeatNumber,
 	}
 
 	encodedAnswer, err := encode(answerReq)
 	if err != nil {
-		log.Printf("error: failed encoding answer for seat %d: %s", err.Error(), offer.SeatNumber)
+		log.Printf("error: failed encoding answer for seat %d: %s\n", offer.SeatNumber, err.Error())
 		return
 	}
 	log.Printf("accepting/answering offer for seat %d\n", offer.SeatNumber)
-	a.client.Emit("answer", encodedAnswer)
+	a.emit("answer", encodedAnswer)
+	newConnection.Answered()
+}
+
+// offerError tells the server why an offer got no answer
+func (a *App) offerError(offer models.Offer, offerErr error) {
+	encodedMsg, err := encode(models.OfferError{
+		SeatNumber: offer.SeatNumber,
+		UserId:     offer.UserId,
+		Error:      offerErr.Error(),
+	})
+	if err != nil {
+		log.Printf("error: failed encoding offer error for seat %d: %s\n", offer.SeatNumber, err.Error())
+		return
+	}
+	a.emit("offer_error", encodedMsg)
+}
+
+// seatBusy tells the server an offer lost out to the seat's occupant, queueing it when configured to
+func (a *App) seatBusy(socketConn socketio.Conn, offer models.Offer, occupant *Connection) {
+	busy := models.SeatBusy{
+		SeatNumber: offer.SeatNumber,
+		UserId:     offer.UserId,
+	}
+	if occupant != nil {
+		busy.Occupant = occupant.UserId
+	}
+
+	if a.cfg.ServerCfg.SeatPolicy == SeatPolicyQueue {
+		position, err := a.conns.Wait(socketConn, offer)
+		if err != nil {
+			log.Printf("error: failed queueing offer for seat %d: %s\n", offer.SeatNumber, err.Error())
+		} else {
+			busy.Queued = true
+			busy.Position = position
+		}
+	}
+	log.Printf("seat %d is taken by user %s, user %s turned away (queued: %t)\n", offer.SeatNumber, busy.Occupant, offer.UserId, busy.Queued)
+
+	encodedMsg, err := encode(busy)
+	if err != nil {
+		log.Printf("error: failed encoding seat busy for seat %d: %s\n", offer.SeatNumber, err.Error())
+		return
+	}
+	a.emit("seat_busy", encodedMsg)
+}
+
+// seatTaken tells the server a priority offer evicted the seat's occupant
+func (a *App) seatTaken(offer models.Offer, previous *Connection) {
+	encodedMsg, err := encode(models.SeatTaken{
+		SeatNumber:     offer.SeatNumber,
+		UserId:         offer.UserId,
+		PreviousUserId: previous.UserId,
+	})
+	if err != nil {
+		log.Printf("error: failed encoding seat taken for seat %d: %s\n", offer.SeatNumber, err.Error())
+		return
+	}
+	a.emit("seat_taken", encodedMsg)
+}
+
+// seatNextWaiting hands a freed seat to the oldest queued offer
+func (a *App) seatNextWaiting(seat int) {
+	socketConn, offer, ok := a.conns.NextWaiting(seat, time.Duration(a.cfg.ServerCfg.SeatQueueTimeout)*time.Millisecond)
+	if !ok {
+		return
+	}
+	log.Printf("seat %d free, seating queued user %s\n", seat, offer.UserId)
+	go func() {
+		defer a.failsafe.RecoverPanic()
+		a.acceptOffer(socketConn, offer)
+	}()
 }
 
 func (a *App) onICECandidate(socketConn socketio.Conn, msgs []string) {
@@ -113,25 +217,17 @@ func (a *App) onICECandidate(socketConn socketio.Conn, msgs []string) {
 		return
 	}
 
-	peerConn, err := a.getOrCreatePeerConn(userIceCandidate.UserId)
-	if err != nil {
-		log.Printf("error: failed getting or creating peer conn: %s\n", err.Error())
-		return
-	}
-
 	if userIceCandidate.Candidate.Candidate == "" {
 		log.Println("warning: recieved empty ice candidate")
 		return
 	}
 
-	err = peerConn.AddICECandidate(userIceCandidate.Candidate)
+	err = a.conns.AddCandidate(userIceCandidate.UserId, userIceCandidate.Candidate)
 	if err != nil {
 		log.Printf("error: failed to add ice candidate for user %s: %s\n", userIceCandidate.UserId.String(), err.Error())
 		return
 	}
 
-	//a.userPeerConns[userIceCandidate.UserId] = peerConn
-
 	//log.Printf("recieved ice candidate: %s\n", userIceCandidate.Candidate.Candidate)
 }
 
@@ -150,51 +246,34 @@ func (a *App) onRegisterSuccess(socketConn socketio.Conn, msgs []string) {
 
 	a.vehicleInfo = decodedMsg.Car
 	a.trackInfo = decodedMsg.Track
+	if decodedMsg.ICE != nil {
+		a.setICEConfig(serverICE(*decodedMsg.ICE), "server")
+	}
 	log.Printf("car connected as %s(%s) @ %s(%s) with %d seats available\n", a.vehicleInfo.Name, a.vehicleInfo.ShortName, a.trackInfo.Name, a.trackInfo.ShortName, a.cfg.ServerCfg.SeatCount)
 }
 
-func (a *App) getOrCreatePeerConn(userId uuid.UUID) (*webrtc.PeerConnection, error) {
-	var err error
-
-	peerConn, ok := a.userPeerConns[userId]
-	if !ok || peerConn.ConnectionState() == webrtc.PeerConnectionStateClosed {
-		log.Printf("creating new peer connection for user %s\n", userId)
-		peerConn, err = webrtc.NewPeerConnection(webrtc.Configuration{
-			ICEServers: []webrtc.ICEServer{
-				{
-					URLs: []string{"stun:stun.l.google.com:19302"},
-				},
-			},
-		})
-		if err != nil {
-			return nil, fmt.Errorf("error: failed creating peer connection for user %s on ice candidate: %w\n", userId, err)
-		}
-		a.userPeerConns[userId] = peerConn
-	} else {
-		log.Printf("reusing peer connection for user %s\n", userId)
+func (a *App) onRecordStart(socketConn socketio.Conn, msgs []string) {
+	log.Println("record start requested by server")
+	err := a.recorder.Start()
+	if err != nil {
+		log.Printf("error: failed starting recording: %s\n", err.Error())
 	}
-	return peerConn, nil
 }
 
-func (a *App) CreatePeerConn(userId uuid.UUID) (*webrtc.PeerConnection, error) {
-	var err error
-
-	peerConn, ok := a.userPeerConns[userId]
-	if ok {
-		log.Printf("close and recreating peer connection for user %s\n", userId)
-		peerConn.Close()
-	}
-	peerConn, err = webrtc.NewPeerConnection(webrtc.Configuration{
-		ICEServers: []webrtc.ICEServer{
-			{
-				URLs: []string{"stun:stun.l.google.com:19302"},
-			},
-		},
-	})
+func (a *App) onRecordStop(socketConn socketio.Conn, msgs []string) {
+	log.Println("record stop requested by server")
+	err := a.recorder.Stop()
 	if err != nil {
-		return nil, fmt.Errorf("error: failed creating peer connection for user %s on ice candidate: %w\n", userId, err)
+		log.Printf("error: failed stopping recording: %s\n", err.Error())
 	}
-	a.userPeerConns[userId] = peerConn
+}
 
-	return peerConn, nil
+func (a *App) onConnectionEvent(event ConnectionEvent) {
+	switch event.Type {
+	case EventSeatAssigned, EventSeatReleased:
+		log.Printf("%s: seat %d user %s, %d of %d seats taken\n", event.Type, event.Seat, event.UserId, len(a.conns.Connections()), len(a.seats))
+	}
+	if event.Type == EventSeatReleased {
+		a.seatNextWaiting(event.Seat)
+	}
 }
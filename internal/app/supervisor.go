@@ -0,0 +1,155 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/Speshl/gorrc_client/internal/models"
+	socketio "github.com/googollee/go-socket.io"
+)
+
+const stableConnectionTime = 10 * time.Second
+
+// backoff doubles the wait after each failed attempt up to max, with jitter so a fleet of cars does not
+// reconnect in lockstep after a server restart
+type backoff struct {
+	min      time.Duration
+	max      time.Duration
+	attempts int
+}
+
+func (b *backoff) Next() time.Duration {
+	wait := b.min << min(b.attempts, 16)
+	if wait <= 0 || wait > b.max {
+		wait = b.max
+	}
+	b.attempts++
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
+
+func (b *backoff) Reset() {
+	b.attempts = 0
+}
+
+// connect opens a new socket.io connection, closing the old one first so it can not linger
+func (a *App) connect() error {
+	a.serverLock.Lock()
+	defer a.serverLock.Unlock()
+
+	if a.serverOpened {
+		a.client.Close() //runs the disconnect handler
+	}
+
+	log.Println("attempting to connect to server...")
+	err := a.client.Connect() //Client must have atleast 1 event handler to work
+	if err != nil {
+		return fmt.Errorf("error: failed connecting to server - %w", err)
+	}
+	a.serverOpened = true
+	a.serverConnected.Store(true)
+
+	select { //drop the signal from closing the old connection
+	case <-a.serverDisconnects:
+	default:
+	}
+	log.Println("connected to server")
+	return nil
+}
+
+func (a *App) isServerConnected() bool {
+	return a.serverConnected.Load()
+}
+
+// emit sends an event to the server, dropping it while disconnected
+func (a *App) emit(event string, args ...interface{}) {
+	a.serverLock.Lock()
+	defer a.serverLock.Unlock()
+	if !a.serverConnected.Load() {
+		log.Printf("warning: not connected to server, dropping %s\n", event)
+		return
+	}
+	a.client.Emit(event, args...)
+}
+
+func (a *App) closeServer() error {
+	a.serverLock.Lock()
+	defer a.serverLock.Unlock()
+	if !a.serverOpened {
+		return nil
+	}
+	a.serverConnected.Store(false)
+	return a.client.Close()
+}
+
+func (a *App) onServerDisconnect(socketConn socketio.Conn, reason string) {
+	log.Printf("disconnected from server: %s\n", reason)
+	a.serverConnected.Store(false) //no lock, this runs inside client.Close
+
+	select {
+	case a.serverDisconnects <- struct{}{}:
+	default:
+	}
+}
+
+func (a *App) onServerError(socketConn socketio.Conn, err error) {
+	log.Printf("error: server connection: %s\n", err.Error())
+}
+
+// superviseServer keeps the car registered with the server. After a disconnect it drops every peer, since
+// users will have to offer again, then reconnects with backoff and re-sends car_connect. The vehicle loop
+// keeps running and centers the seats once their commands stop.
+func (a *App) superviseServer(ctx context.Context) error {
+	retry := backoff{
+		min: time.Duration(a.cfg.ServerCfg.ReconnectMin) * time.Millisecond,
+		max: time.Duration(a.cfg.ServerCfg.ReconnectMax) * time.Millisecond,
+	}
+
+	for {
+		if !a.isServerConnected() {
+			err := a.connect()
+			if err != nil {
+				wait := retry.Next()
+				log.Printf("%s, retrying in %s\n", err.Error(), wait.Round(time.Millisecond))
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(wait):
+				}
+				continue
+			}
+		}
+		connectedAt := time.Now()
+
+		encodedMsg, _ := encode(models.ConnectReq{
+			Key:       a.cfg.ServerCfg.Key,
+			Password:  a.cfg.ServerCfg.Password,
+			SeatCount: a.cfg.ServerCfg.SeatCount,
+		})
+		a.emit("car_connect", encodedMsg)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-a.serverDisconnects:
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			a.conns.CloseAll()
+		}
+
+		if time.Since(connectedAt) < stableConnectionTime { //keep backing off if the server drops us right away
+			wait := retry.Next()
+			log.Printf("server connection dropped quickly, reconnecting in %s\n", wait.Round(time.Millisecond))
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+		} else {
+			retry.Reset()
+		}
+	}
+}
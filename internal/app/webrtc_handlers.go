@@ -2,37 +2,90 @@ package app
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"time"
 
+	"github.com/Speshl/gorrc_client/internal/control"
 	"github.com/Speshl/gorrc_client/internal/models"
+	"github.com/Speshl/gorrc_client/internal/vehicle"
 	"github.com/pion/webrtc/v3"
 )
 
 const PingSourceName = "car"
 const PingWarningThreshold = 100 * time.Millisecond
 
+const (
+	FailsafeChannelClosed   = "command channel closed"
+	FailsafeICEDisconnected = "ice disconnected"
+	FailsafeICEFailed       = "ice failed"
+	FailsafeStaleCommands   = "stale commands"
+	FailsafeCommandsStopped = "commands stopped"
+)
+
+var linkProblems = []string{FailsafeChannelClosed, FailsafeICEDisconnected, FailsafeICEFailed, FailsafeStaleCommands, FailsafeCommandsStopped}
+
 func (c *Connection) onICEConnectionStateChange(connectionState webrtc.ICEConnectionState) {
 	log.Printf("Connection State has changed: %s\n", connectionState.String())
 
 	switch connectionState {
 	case webrtc.ICEConnectionStateFailed:
+		c.engageFailsafe(FailsafeICEFailed)
 		c.Disconnect()
 	case webrtc.ICEConnectionStateChecking:
 	case webrtc.ICEConnectionStateCompleted:
 	case webrtc.ICEConnectionStateConnected:
+		for _, cam := range c.cams { //new viewer should not wait for the next natural keyframe
+			cam.RequestKeyframe()
+		}
 	case webrtc.ICEConnectionStateClosed:
 		c.Disconnect()
 	case webrtc.ICEConnectionStateDisconnected:
+		c.engageFailsafe(FailsafeICEDisconnected)
 	case webrtc.ICEConnectionStateNew:
 	default:
 	}
 }
 
+// onICECandidate trickles each local candidate to the user as it is gathered
 func (c *Connection) onICECandidate(candidate *webrtc.ICECandidate) {
-	if candidate != nil {
-		//log.Printf("recieved ICE candidate from client: %s (not used)\n", candidate.String())
+	if candidate == nil {
+		log.Printf("ice gathering complete for seat %d\n", c.SeatNumber)
+		return
+	}
+
+	c.candidateLock.Lock()
+	defer c.candidateLock.Unlock()
+	if !c.answered {
+		c.localCandidates = append(c.localCandidates, candidate.ToJSON())
+		return
+	}
+	c.sendCandidate(candidate.ToJSON())
+}
+
+// Answered sends the candidates gathered before the answer went out, later ones go straight through
+func (c *Connection) Answered() {
+	c.candidateLock.Lock()
+	defer c.candidateLock.Unlock()
+	c.answered = true
+	for i := range c.localCandidates {
+		c.sendCandidate(c.localCandidates[i])
+	}
+	c.localCandidates = nil
+}
+
+// sendCandidate emits a local candidate, candidateLock must be held to keep them in order
+func (c *Connection) sendCandidate(candidate webrtc.ICECandidateInit) {
+	encodedMsg, err := encode(models.IceCandidate{
+		Candidate: candidate,
+		SeatNum:   c.SeatNumber,
+		UserId:    c.UserId,
+	})
+	if err != nil {
+		log.Printf("error: failed encoding ice candidate for seat %d: %s\n", c.SeatNumber, err.Error())
+		return
 	}
+	c.Emit("candidate", encodedMsg)
 }
 
 func (c *Connection) onDataChannel(d *webrtc.DataChannel) {
@@ -42,47 +95,150 @@ func (c *Connection) onDataChannel(d *webrtc.DataChannel) {
 	d.OnOpen(func() {
 		log.Printf("data channel open for seat %d: %s\n", c.SeatNumber, d.Label())
 		switch d.Label() {
-		case "hud":
-			c.HudOutput = d
-		case "ping":
-			c.PingOutput = d
+		case "hud", "ping":
+			c.setOutput(d.Label(), d)
+		case "camera":
+			c.setOutput(d.Label(), d)
+			err := c.sendCameraState()
+			if err != nil {
+				log.Printf("error: failed sending camera state for seat %d: %s\n", c.SeatNumber, err.Error())
+			}
 		}
 	})
 
 	// Register text message handling
 	switch d.Label() {
 	case "command":
-		d.OnMessage(func(msg webrtc.DataChannelMessage) { c.onCommandHandler(msg.Data) })
+		decode := control.DecodeJSON
+		if d.Protocol() == control.Protocol {
+			decode = control.NewDecoder()
+		}
+		log.Printf("command channel for seat %d using protocol %q, %s\n", c.SeatNumber, d.Protocol(), channelMode(d))
+		d.OnMessage(func(msg webrtc.DataChannelMessage) { c.onCommandHandler(decode, msg.Data) })
+		d.OnClose(func() {
+			log.Printf("command channel closed for seat %d\n", c.SeatNumber)
+			c.engageFailsafe(FailsafeChannelClosed)
+		})
 	case "ping":
 		d.OnMessage(func(msg webrtc.DataChannelMessage) { c.onPingHandler(msg.Data) })
+	case "camera":
+		d.OnMessage(func(msg webrtc.DataChannelMessage) { c.onCameraHandler(msg.Data) })
 	case "hud":
 	default:
 		log.Printf("recieved message on unsupported channel for seat %d: %s\n", c.SeatNumber, d.Label())
 	}
 }
 
-func (c *Connection) onCommandHandler(data []byte) {
-	state := models.ControlState{}
-	err := json.Unmarshal(data, &state)
+func (c *Connection) onCommandHandler(decode control.Decoder, data []byte) {
+	defer c.Failsafe.RecoverPanic()
+	state, err := decode(data)
 	if err != nil {
-		log.Printf("error: failed unmarshalling data channel msg: %s\n", data)
+		log.Printf("error: failed decoding command for seat %d: %s\n", c.SeatNumber, err.Error())
+		return
+	}
+
+	c.Recorder.RecordControl(c.SeatNumber, state)
+
+	receivedAt := time.Now()
+	if c.commandIsStale(state, receivedAt) {
+		c.engageFailsafe(FailsafeStaleCommands)
+		return
+	}
+	c.clearFailsafe()
+
+	if c.commandTooOld(state, receivedAt) {
+		return
+	}
+
+	if state.Seq != 0 && c.lastSeq != 0 && vehicle.SeqLate(state.Seq, c.lastSeq) {
+		c.CommandChannel <- state //late on an unordered channel, the seat counts and drops it
 		return
 	}
+	c.lastSeq = state.Seq
+
+	pressed := state.BitButton &^ c.lastButtons
+	c.lastButtons = state.BitButton
+	if c.buttons.pressed(c.buttons.CamSwitch, pressed) {
+		err = c.NextCamera()
+		if err != nil {
+			log.Printf("error: failed switching camera for seat %d: %s\n", c.SeatNumber, err.Error())
+		}
+	}
+	if c.buttons.pressed(c.buttons.Record, pressed) {
+		err = c.Recorder.Toggle()
+		if err != nil {
+			log.Printf("error: failed toggling recording from seat %d: %s\n", c.SeatNumber, err.Error())
+		}
+	}
+
 	c.CommandChannel <- state
 }
 
+// channelMode describes the delivery guarantees the user's browser picked for a data channel
+func channelMode(d *webrtc.DataChannel) string {
+	mode := "ordered"
+	if !d.Ordered() {
+		mode = "unordered"
+	}
+	switch {
+	case d.MaxRetransmits() != nil:
+		return fmt.Sprintf("%s, %d retransmits", mode, *d.MaxRetransmits())
+	case d.MaxPacketLifeTime() != nil:
+		return fmt.Sprintf("%s, %dms packet lifetime", mode, *d.MaxPacketLifeTime())
+	default:
+		return fmt.Sprintf("%s, reliable", mode)
+	}
+}
+
+func (c *Connection) onCameraHandler(data []byte) {
+	selection := models.CameraSelect{}
+	err := json.Unmarshal(data, &selection)
+	if err != nil {
+		log.Printf("error: failed unmarshalling data channel msg: %s\n", data)
+		return
+	}
+
+	err = c.SelectCamera(selection.Index)
+	if err != nil {
+		log.Printf("error: failed selecting camera for seat %d: %s\n", c.SeatNumber, err.Error())
+	}
+}
+
 func (c *Connection) onPingHandler(data []byte) {
+	receivedAt := time.Now().UnixMilli()
 	ping := models.Ping{}
 	err := json.Unmarshal(data, &ping)
 	if err != nil {
 		log.Printf("error: failed unmarshalling data channel msg: %s\n", data)
 		return
 	}
-	if ping.Source == PingSourceName {
-		roundTripTime := time.Now().UnixMilli() - ping.TimeStamp
-		if roundTripTime > PingWarningThreshold.Milliseconds() {
-			log.Printf("warning: user ping > %d for seat %d: %d ms\n", PingWarningThreshold.Microseconds(), c.SeatNumber, roundTripTime)
+	if ping.Source != PingSourceName { //the browser's own ping, echo it with our times so it can sync too
+		ping.ReceivedAt = receivedAt
+		ping.RepliedAt = time.Now().UnixMilli()
+		reply, err := json.Marshal(ping)
+		if err == nil {
+			if pingOutput := c.output("ping"); pingOutput != nil {
+				err = pingOutput.Send(reply)
+			}
+		}
+		if err != nil {
+			log.Printf("error: failed echoing ping for seat %d: %s\n", c.SeatNumber, err.Error())
+		}
+		return
+	}
+
+	roundTripTime := receivedAt - ping.TimeStamp
+	if roundTripTime > PingWarningThreshold.Milliseconds() {
+		log.Printf("warning: user ping > %d for seat %d: %d ms\n", PingWarningThreshold.Milliseconds(), c.SeatNumber, roundTripTime)
+	}
+	c.PingInput <- roundTripTime
+
+	if ping.ReceivedAt != 0 && ping.RepliedAt != 0 { //older browsers echo without their times
+		c.clock.Add(ping.TimeStamp, ping.ReceivedAt, ping.RepliedAt, receivedAt)
+		if c.clockSynced.CompareAndSwap(false, true) {
+			offset, _ := c.clock.Offset()
+			delay, _ := c.clock.OneWayDelay()
+			log.Printf("browser clock synced for seat %d: offset %s, one way delay %s\n", c.SeatNumber, offset, delay)
 		}
-		c.PingInput <- roundTripTime
 	}
 }
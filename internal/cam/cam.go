@@ -4,10 +4,9 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"strconv"
-	"time"
 
 	"github.com/Speshl/gorrc_client/internal/config"
+	"github.com/Speshl/gorrc_client/internal/h264"
 	"github.com/pion/webrtc/v3"
 	"github.com/pion/webrtc/v3/pkg/media"
 )
@@ -17,52 +16,89 @@ const DefaultFPS = 30
 
 type Cam struct {
 	VideoTrack   *webrtc.TrackLocalStaticSample
-	videoChannel chan []byte
+	videoChannel chan media.Sample
 	cfg          config.CamConfig
+	advertised   string //profile-level-id in the track's fmtp
+	source       VideoSource
+	quality      *quality
+	keyframes    keyframes
+	pipeline     pipelineKeyframer //only set while the gstreamer backend is running
+	sink         FrameSink
 }
 
-func NewCam(cfg config.CamConfig) (*Cam, error) {
-	// Create a video track
-	videoTrack, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264}, "video", "pion")
+// FrameSink receives every access unit a camera streams, used for recording
+type FrameSink interface {
+	WriteFrame(trackID string, width, height int, au h264.AccessUnit)
+}
+
+func NewCam(index int, cfg config.CamConfig) (*Cam, error) {
+	// Create a video track, the id stays the same across restarts so clients can tell cameras apart
+	trackID := fmt.Sprintf("cam%d", index)
+	advertised, err := ProfileLevelID(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error getting video codec: %w", err)
+	}
+	fmtpLine, err := FmtpLine(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error getting video codec: %w", err)
+	}
+	videoTrack, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000, SDPFmtpLine: fmtpLine}, trackID, trackID)
 	if err != nil {
 		return nil, fmt.Errorf("error creating first video track: %w", err)
 	}
 
+	var source VideoSource
+	if cfg.Backend != BackendGStreamer {
+		source, err = newVideoSource(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("error creating video source: %w", err)
+		}
+	}
+
 	cam := Cam{
 		VideoTrack:   videoTrack,
-		videoChannel: make(chan []byte, 5),
+		videoChannel: make(chan media.Sample, 5),
 		cfg:          cfg,
+		advertised:   advertised,
+		source:       source,
+		quality:      newQuality(cfg),
 	}
 	cam.cfg.Level = DefaultLevel
 	return &cam, nil
 }
 
+// SetFrameSink must be called before Start. Frames from the gstreamer backend go straight to the track and
+// are not passed to the sink.
+func (c *Cam) SetFrameSink(sink FrameSink) {
+	c.sink = sink
+}
+
 func (c *Cam) Start(ctx context.Context) error {
+	go c.StartQualityController(ctx)
+
+	if c.cfg.Backend == BackendGStreamer {
+		return c.StartPipeline(ctx)
+	}
+
 	go c.StartVideoDataListener(ctx)
 	return c.StartStreaming(ctx)
 }
 
 func (c *Cam) StartVideoDataListener(ctx context.Context) {
-	fps, err := strconv.ParseInt(c.cfg.Fps, 10, 32)
-	if err != nil {
-		fps = DefaultFPS
-	}
-
 	log.Println("started video data listener")
-	duration := int(1000 / fps)
 	for {
 		select {
 		case <-ctx.Done():
 			log.Println("video data listener done due to ctx")
 			return
-		case data, ok := <-c.videoChannel:
+		case sample, ok := <-c.videoChannel:
 			if !ok {
 				log.Println("video data channel closed, stopping")
 				return
 			}
 
 			//log.Println("writing video sample")
-			err := c.VideoTrack.WriteSample(media.Sample{Data: data, Duration: time.Millisecond * time.Duration(duration)})
+			err := c.VideoTrack.WriteSample(sample)
 			if err != nil {
 				log.Printf("error writing sample to track: %s\n", err.Error())
 				return
@@ -0,0 +1,62 @@
+package cam
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/Speshl/gorrc_client/internal/h264"
+)
+
+// fileSource loops a pre-recorded Annex-B H.264 file, paced to the configured fps
+type fileSource struct {
+	path string
+	fps  int
+}
+
+// Open ignores the tier, a recording can only be played as it was encoded
+func (s *fileSource) Open(ctx context.Context, tier Tier) (io.ReadCloser, error) {
+	file, err := os.Open(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed opening video file: %w", err)
+	}
+	defer file.Close()
+
+	units, err := h264.NewAccessUnitReader(h264.NewParser(file), s.fps).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing video file: %w", err)
+	}
+	if len(units) == 0 {
+		return nil, fmt.Errorf("no access units found in %s", s.path)
+	}
+
+	log.Printf("streaming %d access units from %s at %d fps\n", len(units), s.path, s.fps)
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(s.stream(ctx, writer, units))
+	}()
+	return reader, nil
+}
+
+func (s *fileSource) stream(ctx context.Context, w io.Writer, units []h264.AccessUnit) error {
+	ticker := time.NewTicker(time.Second / time.Duration(s.fps))
+	defer ticker.Stop()
+
+	for {
+		for _, au := range units {
+			_, err := w.Write(au.Bytes())
+			if err != nil {
+				return err
+			}
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-ticker.C:
+			}
+		}
+	}
+}
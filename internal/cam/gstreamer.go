@@ -0,0 +1,51 @@
+package cam
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/Speshl/gorrc_client/internal/gst"
+	"github.com/pion/webrtc/v3"
+)
+
+// StartPipeline runs the gstreamer backend, which encodes and writes straight to the video track
+// instead of going through the NAL splitter. Tier changes rebuild the pipeline with the new caps and bitrate.
+func (c *Cam) StartPipeline(ctx context.Context) error {
+	for {
+		tier := c.Tier()
+		pipeline := gst.CreateSendPipeline("h264", []*webrtc.TrackLocalStaticSample{c.VideoTrack}, c.pipelineSrc(tier), tier.Bitrate, intraPeriod(c.cfg, tier))
+		pipeline.Start()
+		c.setPipeline(pipeline)
+		log.Printf("started gstreamer camera pipeline at %s tier\n", tier.Name)
+
+		select {
+		case <-ctx.Done():
+			log.Println("stopping gstreamer camera pipeline due to context")
+			c.setPipeline(nil)
+			pipeline.Stop()
+			return ctx.Err()
+		case <-c.quality.changed:
+			c.setPipeline(nil)
+			pipeline.Stop()
+		}
+	}
+}
+
+// pipelineSrc returns the configured pipeline source, or one built for the device. It must output raw video.
+func (c *Cam) pipelineSrc(tier Tier) string {
+	if c.cfg.Pipeline != "" {
+		return c.cfg.Pipeline
+	}
+
+	device := v4l2Device(c.cfg.Device)
+	scale := fmt.Sprintf("videoconvert ! videoscale ! videorate ! video/x-raw,width=%d,height=%d,framerate=%d/1", tier.Width, tier.Height, tier.Fps)
+	switch c.cfg.Format {
+	case FormatMJPEG:
+		return fmt.Sprintf("v4l2src device=%s ! image/jpeg ! jpegdec ! %s", device, scale)
+	case FormatH264:
+		return fmt.Sprintf("v4l2src device=%s ! video/x-h264 ! h264parse ! avdec_h264 ! %s", device, scale)
+	default:
+		return fmt.Sprintf("v4l2src device=%s ! %s", device, scale)
+	}
+}
@@ -0,0 +1,98 @@
+package cam
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Speshl/gorrc_client/internal/config"
+	"github.com/Speshl/gorrc_client/internal/h264"
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+const keyframeRequestInterval = 500 * time.Millisecond
+
+// keyframes caches the latest parameter sets so a new viewer can be sent them right away
+type keyframes struct {
+	lock        sync.Mutex
+	sps         []byte
+	pps         []byte
+	profile     string //profile-level-id of the last sps, checked against the advertised one when it changes
+	lastRequest time.Time
+}
+
+// cacheParameterSets keeps a copy of the SPS and PPS as they go by
+func (c *Cam) cacheParameterSets(au h264.AccessUnit) {
+	for _, nal := range au.NALs {
+		switch nal.Type() {
+		case h264.NALTypeSPS:
+			c.keyframes.lock.Lock()
+			c.keyframes.sps = append(c.keyframes.sps[:0], nal.Data...)
+			profile, _ := nal.ProfileLevelID()
+			changed := profile != c.keyframes.profile
+			c.keyframes.profile = profile
+			c.keyframes.lock.Unlock()
+			if changed && !ProfileCompatible(profile, c.advertised) { //a camera passed through untouched picks its own profile
+				log.Printf("warning: %s streams h264 %s but advertises %s, browsers may not decode it, set PROFILE to match\n", c.VideoTrack.ID(), profile, c.advertised)
+			}
+		case h264.NALTypePPS:
+			c.keyframes.lock.Lock()
+			c.keyframes.pps = append(c.keyframes.pps[:0], nal.Data...)
+			c.keyframes.lock.Unlock()
+		}
+	}
+}
+
+// RequestKeyframe re-sends the cached SPS/PPS and asks the encoder for an IDR frame where the backend can
+// do it on demand, which only the gstreamer pipeline can. libcamera-vid and ffmpeg are not restarted for
+// it since every viewer would lose a second or more of video, so the wait for a picture is bounded by the
+// intra period instead: INTRA frames, half a second when unset. An H.264 camera copied through by the v4l2
+// backend keeps its own keyframe period.
+func (c *Cam) RequestKeyframe() {
+	c.keyframes.lock.Lock()
+	if time.Since(c.keyframes.lastRequest) < keyframeRequestInterval {
+		c.keyframes.lock.Unlock()
+		return
+	}
+	c.keyframes.lastRequest = time.Now()
+
+	var headers []byte
+	if len(c.keyframes.sps) > 0 && len(c.keyframes.pps) > 0 {
+		headers = h264.AccessUnit{NALs: []h264.NALUnit{{Data: c.keyframes.sps}, {Data: c.keyframes.pps}}}.Bytes()
+	}
+	pipeline := c.pipeline
+	c.keyframes.lock.Unlock()
+
+	log.Printf("keyframe requested for %s\n", c.VideoTrack.ID())
+	if pipeline != nil {
+		pipeline.ForceKeyframe()
+		return
+	}
+
+	if len(headers) > 0 {
+		select {
+		case c.videoChannel <- media.Sample{Data: headers}: //written by the data listener so samples stay in order, no duration so timestamps do not move
+		default:
+			log.Printf("%s video channel full, not re-sending parameter sets\n", c.VideoTrack.ID())
+		}
+	}
+}
+
+// intraPeriod is the number of frames between keyframes for encoders that can not be asked for one, it is
+// the longest a new viewer or a lossy link waits for a picture
+func intraPeriod(cfg config.CamConfig, tier Tier) int {
+	if cfg.Intra > 0 {
+		return cfg.Intra
+	}
+	return max(tier.Fps/2, 1)
+}
+
+func (c *Cam) setPipeline(pipeline pipelineKeyframer) {
+	c.keyframes.lock.Lock()
+	defer c.keyframes.lock.Unlock()
+	c.pipeline = pipeline
+}
+
+type pipelineKeyframer interface {
+	ForceKeyframe()
+}
@@ -0,0 +1,30 @@
+package cam
+
+import (
+	"testing"
+
+	"github.com/Speshl/gorrc_client/internal/config"
+)
+
+func TestIntraPeriod(t *testing.T) {
+	tests := []struct {
+		name  string
+		intra int
+		fps   int
+		want  int
+	}{
+		{name: "half a second by default", fps: 30, want: 15},
+		{name: "low tier fps", fps: 15, want: 7},
+		{name: "never below one frame", fps: 1, want: 1},
+		{name: "configured", intra: 10, fps: 30, want: 10},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := intraPeriod(config.CamConfig{Intra: test.intra}, Tier{Fps: test.fps})
+			if got != test.want {
+				t.Fatalf("expected %d frames, got %d", test.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package cam
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Speshl/gorrc_client/internal/config"
+	"github.com/Speshl/gorrc_client/internal/h264"
+)
+
+const (
+	ProfileBaseline = "baseline"
+	ProfileMain     = "main"
+	ProfileHigh     = "high"
+
+	profileIdcBaseline = 0x42
+	profileIdcMain     = 0x4d
+	profileIdcExtended = 0x58
+	profileIdcHigh     = 0x64
+
+	//constraint_set4 (frame_mbs_only) and constraint_set5 (no B slices) for high, none of the encoders the
+	//backends run interlace or use B frames so every stream meets them
+	streamConstraints = 0x0c
+)
+
+// profile_idc and constraint flags as they appear in a profile-level-id, baseline is sent as constrained
+// baseline since that is what browsers decode
+var profileIDs = map[string]string{
+	ProfileBaseline: "42e0",
+	ProfileMain:     "4d00",
+	ProfileHigh:     "6400",
+}
+
+// ProfileLevelID is the h264 profile-level-id of the stream a camera config produces
+func ProfileLevelID(cfg config.CamConfig) (string, error) {
+	switch cfg.Backend {
+	case BackendTestPattern:
+		return fmt.Sprintf("%s%02x", profileIDs[ProfileBaseline], baselineLevel), nil
+	case BackendFile: //a recording can only be sent as it was encoded
+		return fileProfileLevelID(cfg.File)
+	case BackendGStreamer:
+		cfg.Profile = ProfileBaseline //pinned in the x264enc caps, the one profile every browser decodes
+	}
+
+	profile, ok := profileIDs[strings.ToLower(cfg.Profile)]
+	if !ok {
+		return "", fmt.Errorf("unsupported h264 profile %s, expected %s, %s or %s", cfg.Profile, ProfileBaseline, ProfileMain, ProfileHigh)
+	}
+
+	level := cfg.Level
+	if level == "" {
+		level = DefaultLevel
+	}
+	levelIdc, err := strconv.ParseFloat(level, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid h264 level %s: %w", level, err)
+	}
+	return fmt.Sprintf("%s%02x", profile, int(levelIdc*10+0.5)), nil
+}
+
+// fileProfileLevelID reads the profile-level-id from the first SPS in an Annex-B file
+func fileProfileLevelID(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed opening video file: %w", err)
+	}
+	defer file.Close()
+
+	parser := h264.NewParser(file)
+	for {
+		nal, err := parser.ReadNAL()
+		if err == io.EOF {
+			return "", fmt.Errorf("no sps found in %s", path)
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed parsing video file: %w", err)
+		}
+		profileLevelID, ok := nal.ProfileLevelID()
+		if ok {
+			return profileLevelID, nil
+		}
+	}
+}
+
+// ProfileCompatible reports whether a decoder offering one profile-level-id plays a stream with another.
+// Levels are ignored since level-asymmetry-allowed is set. Constrained baseline plays on every profile and
+// main plays on high, otherwise profile_idc has to match and every constraint flag the offer sets has to be
+// one the stream meets, so a high stream plays on the constrained high safari offers.
+func ProfileCompatible(stream string, offered string) bool {
+	s, err := hex.DecodeString(stream)
+	if err != nil || len(s) != 3 {
+		return false
+	}
+	o, err := hex.DecodeString(offered)
+	if err != nil || len(o) != 3 {
+		return false
+	}
+
+	switch {
+	case constrainedBaseline(s):
+		return true
+	case s[0] == profileIdcMain && o[0] == profileIdcHigh:
+		return true
+	}
+	return s[0] == o[0] && o[1]&^(s[1]|streamConstraints) == 0
+}
+
+// constrainedBaseline follows the profile_idc and constraint flag combinations of H.264 A.2.1.1
+func constrainedBaseline(profileLevelID []byte) bool {
+	idc, flags := profileLevelID[0], profileLevelID[1]
+	switch idc {
+	case profileIdcBaseline:
+		return flags&0x40 != 0
+	case profileIdcMain:
+		return flags&0x80 != 0
+	case profileIdcExtended:
+		return flags&0xc0 == 0xc0
+	}
+	return false
+}
+
+// FmtpLine is the sdp fmtp a camera's track is negotiated with
+func FmtpLine(cfg config.CamConfig) (string, error) {
+	profileLevelID, err := ProfileLevelID(cfg)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=%s", profileLevelID), nil
+}
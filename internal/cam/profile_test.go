@@ -0,0 +1,80 @@
+package cam
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Speshl/gorrc_client/internal/config"
+)
+
+func TestProfileCompatible(t *testing.T) {
+	tests := []struct {
+		name       string
+		stream     string
+		offered    string
+		compatible bool
+	}{
+		{name: "same profile other level", stream: "640028", offered: "64001f", compatible: true},
+		{name: "high on safari constrained high", stream: "64002a", offered: "640c1f", compatible: true},
+		{name: "constrained baseline on firefox baseline", stream: "42e01f", offered: "42001f", compatible: true},
+		{name: "constrained baseline on high", stream: "42e01f", offered: "640c1f", compatible: true},
+		{name: "pi constrained baseline on constrained baseline", stream: "42c028", offered: "42e01f", compatible: true},
+		{name: "main on high", stream: "4d0028", offered: "64001f", compatible: true},
+		{name: "high on firefox baseline", stream: "640028", offered: "42e01f"},
+		{name: "high on main", stream: "640028", offered: "4d001f"},
+		{name: "baseline on constrained baseline", stream: "420028", offered: "42e01f"},
+		{name: "main on baseline", stream: "4d0028", offered: "42001f"},
+		{name: "bad offer", stream: "42e01f", offered: "42e0"},
+		{name: "bad stream", stream: "zz", offered: "42e01f"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if ProfileCompatible(test.stream, test.offered) != test.compatible {
+				t.Fatalf("expected %s on %s compatible %t", test.stream, test.offered, test.compatible)
+			}
+		})
+	}
+}
+
+func TestProfileLevelID(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "high.h264")
+	err := os.WriteFile(path, []byte{0, 0, 0, 1, 0x09, 0xf0, 0, 0, 0, 1, 0x27, 0x64, 0x00, 0x28, 0xac, 0x2b, 0, 0, 1, 0x28, 0xee, 0x3c, 0xb0}, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		cfg     config.CamConfig
+		want    string
+		wantErr bool
+	}{
+		{name: "default", cfg: config.CamConfig{Backend: BackendLibcamera, Profile: config.DefaultProfile}, want: "42e02a"},
+		{name: "high at a level", cfg: config.CamConfig{Backend: BackendLibcamera, Profile: "High", Level: "4"}, want: "640028"},
+		{name: "test pattern", cfg: config.CamConfig{Backend: BackendTestPattern, Profile: ProfileHigh}, want: "42e01f"},
+		{name: "gstreamer pins constrained baseline", cfg: config.CamConfig{Backend: BackendGStreamer, Profile: ProfileHigh}, want: "42e02a"},
+		{name: "file uses its sps", cfg: config.CamConfig{Backend: BackendFile, File: path, Profile: ProfileBaseline}, want: "640028"},
+		{name: "file without sps", cfg: config.CamConfig{Backend: BackendFile, File: os.DevNull}, wantErr: true},
+		{name: "unknown profile", cfg: config.CamConfig{Backend: BackendLibcamera, Profile: "extended"}, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ProfileLevelID(test.cfg)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %s", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if got != test.want {
+				t.Fatalf("expected %s, got %s", test.want, got)
+			}
+		})
+	}
+}
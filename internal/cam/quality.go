@@ -0,0 +1,217 @@
+package cam
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Speshl/gorrc_client/internal/config"
+	"github.com/pion/rtcp"
+)
+
+const (
+	qualityCheckInterval = 2 * time.Second
+	feedbackMaxAge       = 5 * time.Second
+	minTierChangeTime    = 4 * time.Second
+	tierUpgradeHoldTime  = 10 * time.Second
+
+	lossDowngrade = 0.10
+	lossUpgrade   = 0.02
+	lossSmoothing = 0.3 //weight of the newest loss sample
+
+	bitrateDowngradeMargin = 0.8
+	bitrateUpgradeMargin   = 1.2
+
+	minTierFps = 10
+)
+
+// Tier is one step of encoder settings the adaptive quality controller can pick
+type Tier struct {
+	Name    string
+	Width   int
+	Height  int
+	Fps     int
+	Bitrate int //bits per second, 0 lets the encoder pick
+}
+
+type peerFeedback struct {
+	loss    float64
+	bitrate float64 //receiver estimated max bitrate, 0 when the peer does not send remb
+	updated time.Time
+}
+
+type quality struct {
+	lock       sync.RWMutex
+	tiers      []Tier
+	tier       int
+	changed    chan struct{}
+	lastChange time.Time
+	lastBad    time.Time
+	peers      map[string]peerFeedback
+}
+
+func newQuality(cfg config.CamConfig) *quality {
+	return &quality{
+		tiers:   buildTiers(cfg),
+		changed: make(chan struct{}, 1),
+		peers:   make(map[string]peerFeedback, 2),
+	}
+}
+
+// buildTiers derives lower quality steps from the configured camera settings
+func buildTiers(cfg config.CamConfig) []Tier {
+	width, _ := strconv.Atoi(cfg.Width)
+	height, _ := strconv.Atoi(cfg.Height)
+	fps := parseFps(cfg)
+
+	high := Tier{Name: "high", Width: width, Height: height, Fps: fps, Bitrate: cfg.Bitrate}
+	if !cfg.Adaptive || width <= 0 || height <= 0 {
+		return []Tier{high}
+	}
+
+	lowFps := fps * 2 / 3
+	if lowFps < minTierFps {
+		lowFps = min(fps, minTierFps)
+	}
+
+	return []Tier{
+		high,
+		{Name: "medium", Width: evenSize(width * 3 / 4), Height: evenSize(height * 3 / 4), Fps: fps, Bitrate: cfg.Bitrate / 2},
+		{Name: "low", Width: evenSize(width / 2), Height: evenSize(height / 2), Fps: lowFps, Bitrate: cfg.Bitrate / 4},
+	}
+}
+
+func evenSize(size int) int {
+	return size &^ 1
+}
+
+// Tier returns the encoder settings currently in use
+func (c *Cam) Tier() Tier {
+	c.quality.lock.RLock()
+	defer c.quality.lock.RUnlock()
+	return c.quality.tiers[c.quality.tier]
+}
+
+// OnRTCP takes rtcp feedback from one peer watching this camera. Loss from receiver reports is what drives
+// the tiers. A remb estimate is used when a browser sends one, chrome does not once transport-cc is
+// negotiated, and transport-cc feedback never comes since our rtp carries no transport wide sequence numbers.
+func (c *Cam) OnRTCP(peer string, packets []rtcp.Packet) {
+	loss := -1.0
+	bitrate := 0.0
+	keyframe := false
+	for _, packet := range packets {
+		switch pkt := packet.(type) {
+		case *rtcp.PictureLossIndication, *rtcp.FullIntraRequest:
+			keyframe = true
+		case *rtcp.ReceiverReport:
+			for _, report := range pkt.Reports { //already demuxed to this track's sender
+				loss = max(loss, float64(report.FractionLost)/256)
+			}
+		case *rtcp.ReceiverEstimatedMaximumBitrate:
+			bitrate = float64(pkt.Bitrate)
+		}
+	}
+
+	if keyframe {
+		c.RequestKeyframe()
+	}
+
+	if loss < 0 && bitrate == 0 {
+		return
+	}
+
+	c.quality.lock.Lock()
+	defer c.quality.lock.Unlock()
+	feedback := c.quality.peers[peer]
+	if loss >= 0 {
+		if feedback.updated.IsZero() {
+			feedback.loss = loss
+		} else {
+			feedback.loss = feedback.loss*(1-lossSmoothing) + loss*lossSmoothing
+		}
+	}
+	if bitrate > 0 {
+		feedback.bitrate = bitrate
+	}
+	feedback.updated = time.Now()
+	c.quality.peers[peer] = feedback
+}
+
+// RemovePeer drops feedback from a peer that stopped watching
+func (c *Cam) RemovePeer(peer string) {
+	c.quality.lock.Lock()
+	defer c.quality.lock.Unlock()
+	delete(c.quality.peers, peer)
+}
+
+// StartQualityController steps the encoder tier down when any peer reports loss or a low bitrate estimate,
+// and back up once every peer has been healthy for a while
+func (c *Cam) StartQualityController(ctx context.Context) {
+	if len(c.quality.tiers) < 2 {
+		return
+	}
+
+	ticker := time.NewTicker(qualityCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.checkQuality(time.Now())
+		}
+	}
+}
+
+func (c *Cam) checkQuality(now time.Time) {
+	q := c.quality
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	worstLoss := 0.0
+	lowestBitrate := 0.0
+	for peer, feedback := range q.peers {
+		if now.Sub(feedback.updated) > feedbackMaxAge {
+			delete(q.peers, peer)
+			continue
+		}
+		worstLoss = max(worstLoss, feedback.loss)
+		if feedback.bitrate > 0 && (lowestBitrate == 0 || feedback.bitrate < lowestBitrate) {
+			lowestBitrate = feedback.bitrate
+		}
+	}
+
+	current := q.tiers[q.tier]
+	bad := worstLoss > lossDowngrade || (lowestBitrate > 0 && current.Bitrate > 0 && lowestBitrate < float64(current.Bitrate)*bitrateDowngradeMargin)
+	if bad {
+		q.lastBad = now
+	}
+
+	if now.Sub(q.lastChange) < minTierChangeTime {
+		return
+	}
+
+	next := q.tier
+	if bad && q.tier < len(q.tiers)-1 {
+		next = q.tier + 1
+	} else if !bad && q.tier > 0 && worstLoss < lossUpgrade && now.Sub(q.lastBad) > tierUpgradeHoldTime {
+		better := q.tiers[q.tier-1]
+		if lowestBitrate == 0 || better.Bitrate == 0 || lowestBitrate > float64(better.Bitrate)*bitrateUpgradeMargin {
+			next = q.tier - 1
+		}
+	}
+
+	if next == q.tier {
+		return
+	}
+
+	log.Printf("%s switching video tier %s -> %s (loss %.2f, remb %.0f)\n", c.VideoTrack.ID(), current.Name, q.tiers[next].Name, worstLoss, lowestBitrate)
+	q.tier = next
+	q.lastChange = now
+	select {
+	case q.changed <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,99 @@
+package cam
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"strconv"
+	"sync"
+
+	"github.com/Speshl/gorrc_client/internal/config"
+)
+
+const (
+	BackendLibcamera   = "libcamera"
+	BackendFile        = "file"
+	BackendTestPattern = "testpattern"
+	BackendV4L2        = "v4l2"
+	BackendGStreamer   = "gstreamer"
+
+	FormatH264  = "h264"
+	FormatMJPEG = "mjpeg"
+)
+
+// VideoSource produces the Annex-B H.264 byte stream that a Cam splits into samples, encoded with the
+// settings of the given tier where the source supports it. Closing the returned stream must release
+// everything Open started.
+type VideoSource interface {
+	Open(ctx context.Context, tier Tier) (io.ReadCloser, error)
+}
+
+func newVideoSource(cfg config.CamConfig) (VideoSource, error) {
+	switch cfg.Backend {
+	case BackendLibcamera, "":
+		return &libcamSource{cfg: cfg}, nil
+	case BackendFile:
+		if cfg.File == "" {
+			return nil, fmt.Errorf("file backend requires a file")
+		}
+		return &fileSource{path: cfg.File, fps: parseFps(cfg)}, nil
+	case BackendTestPattern:
+		return newTestPatternSource(cfg)
+	case BackendV4L2:
+		return newV4L2Source(cfg)
+	default:
+		return nil, fmt.Errorf("unsupported camera backend: %s", cfg.Backend)
+	}
+}
+
+func parseFps(cfg config.CamConfig) int {
+	fps, err := strconv.ParseInt(cfg.Fps, 10, 32)
+	if err != nil || fps <= 0 {
+		return DefaultFPS
+	}
+	return int(fps)
+}
+
+// commandStream is the stdout of an encoder process, closing it kills the process
+type commandStream struct {
+	io.ReadCloser
+	cmd       *exec.Cmd
+	closeOnce sync.Once
+}
+
+func startCommandStream(ctx context.Context, name string, args []string) (io.ReadCloser, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed getting std out pipe: %w", err)
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return nil, fmt.Errorf("failed starting camera: %w", err)
+	}
+
+	log.Printf("started %s %v\n", name, cmd.Args)
+	return &commandStream{ReadCloser: stdout, cmd: cmd}, nil
+}
+
+func (s *commandStream) Close() error {
+	s.closeOnce.Do(s.kill)
+	return nil
+}
+
+func (s *commandStream) kill() {
+	log.Println("killing cam streaming cmd...")
+	if s.cmd.Process != nil {
+		err := s.cmd.Process.Kill()
+		if err != nil {
+			log.Printf("Error killing cam process: %s", err.Error())
+		}
+	} else {
+		log.Println("process was null")
+	}
+	s.cmd.Wait()
+	log.Println("killed cam streaming cmd")
+}
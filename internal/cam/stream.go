@@ -0,0 +1,72 @@
+package cam
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/Speshl/gorrc_client/internal/h264"
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+// StartStreaming runs the video source, reopening it with new encoder settings whenever the quality tier changes
+func (c *Cam) StartStreaming(ctx context.Context) error {
+	for {
+		tier := c.Tier()
+		log.Printf("start streaming %s at %s tier %dx%d@%d...\n", c.VideoTrack.ID(), tier.Name, tier.Width, tier.Height, tier.Fps)
+		stream, err := c.source.Open(ctx, tier)
+		if err != nil {
+			return err
+		}
+
+		restart := false
+		done := make(chan struct{})
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			select {
+			case <-ctx.Done():
+			case <-done:
+			case <-c.quality.changed:
+				restart = true
+			}
+			stream.Close() //unblocks the read in readStream
+		}()
+
+		err = c.readStream(ctx, stream)
+		close(done)
+		<-closed
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !restart {
+			return err
+		}
+	}
+}
+
+func (c *Cam) readStream(ctx context.Context, stdout io.Reader) error {
+	tier := c.Tier()
+	units := h264.NewAccessUnitReader(h264.NewParser(stdout), tier.Fps)
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("Stopping cam due to context")
+			return ctx.Err()
+		default:
+			au, err := units.ReadAccessUnit()
+			if err != nil {
+				return fmt.Errorf("failed reading camera stream: %w", err)
+			}
+
+			c.cacheParameterSets(au)
+			if c.sink != nil {
+				c.sink.WriteFrame(c.VideoTrack.ID(), tier.Width, tier.Height, au)
+			}
+			c.videoChannel <- media.Sample{Data: au.Bytes(), Duration: au.Duration}
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package cam
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Speshl/gorrc_client/internal/config"
+	"github.com/Speshl/gorrc_client/internal/h264"
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+type frameRecorder struct {
+	lock  sync.Mutex
+	units []h264.AccessUnit
+}
+
+func (r *frameRecorder) WriteFrame(trackID string, width, height int, au h264.AccessUnit) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.units = append(r.units, au)
+}
+
+func (r *frameRecorder) frames() []h264.AccessUnit {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return slices.Clone(r.units)
+}
+
+// streamCam runs a camera until count samples have been written to its track
+func streamCam(t *testing.T, cfg config.CamConfig, count int) (*Cam, []media.Sample, []h264.AccessUnit) {
+	t.Helper()
+	cam, err := NewCam(0, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := &frameRecorder{}
+	cam.SetFrameSink(sink)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- cam.StartStreaming(ctx)
+	}()
+
+	samples := make([]media.Sample, 0, count)
+	timeout := time.After(5 * time.Second)
+	for len(samples) < count {
+		select {
+		case sample := <-cam.videoChannel:
+			err = cam.VideoTrack.WriteSample(sample)
+			if err != nil {
+				t.Fatalf("failed writing sample %d: %s", len(samples), err)
+			}
+			samples = append(samples, sample)
+		case err = <-done:
+			t.Fatalf("camera stopped after %d samples: %v", len(samples), err)
+		case <-timeout:
+			t.Fatalf("timed out after %d samples", len(samples))
+		}
+	}
+
+	cancel()
+	for {
+		select {
+		case <-cam.videoChannel: //keep the stream from blocking on a full channel
+		case err = <-done:
+			if err != context.Canceled {
+				t.Fatalf("expected the camera to stop with the context, got %v", err)
+			}
+			return cam, samples, sink.frames()
+		case <-time.After(5 * time.Second):
+			t.Fatal("camera did not stop")
+		}
+	}
+}
+
+func checkFirstKeyframe(t *testing.T, cam *Cam, au h264.AccessUnit) {
+	t.Helper()
+	if !au.Keyframe() || !slices.Equal(au.Types(), []h264.NALType{h264.NALTypeSPS, h264.NALTypePPS, h264.NALTypeIDR}) {
+		t.Fatalf("expected the first access unit to be an IDR with its SPS and PPS, got %v", au.Types())
+	}
+	profile, _ := au.NALs[0].ProfileLevelID()
+	if !ProfileCompatible(profile, cam.advertised) {
+		t.Fatalf("stream profile %s does not play where %s is advertised", profile, cam.advertised)
+	}
+}
+
+func checkDurations(t *testing.T, samples []media.Sample, fps int) {
+	t.Helper()
+	for i, sample := range samples {
+		if sample.Duration != time.Second/time.Duration(fps) {
+			t.Fatalf("expected sample %d to last %s at %d fps, got %s", i, time.Second/time.Duration(fps), fps, sample.Duration)
+		}
+	}
+}
+
+func TestStreamTestPattern(t *testing.T) {
+	cfg := config.CamConfig{Backend: BackendTestPattern, Width: "48", Height: "32", Fps: "50", Profile: config.DefaultProfile}
+	cam, samples, frames := streamCam(t, cfg, 5)
+
+	if len(frames) < len(samples) {
+		t.Fatalf("expected every sample to reach the sink, got %d frames for %d samples", len(frames), len(samples))
+	}
+	checkFirstKeyframe(t, cam, frames[0])
+	checkDurations(t, samples, 50)
+	for i := range samples {
+		if !frames[i].Keyframe() {
+			t.Fatalf("expected every test pattern frame to be an IDR, frame %d is %v", i, frames[i].Types())
+		}
+		if !bytes.Equal(samples[i].Data, frames[i].Bytes()) {
+			t.Fatalf("expected sample %d to carry the access unit the sink got", i)
+		}
+		if i > 0 && bytes.Equal(samples[i].Data, samples[i-1].Data) {
+			t.Fatalf("expected the bars to scroll between frames %d and %d", i-1, i)
+		}
+	}
+}
+
+func TestStreamFile(t *testing.T) {
+	//one IDR from the test pattern then two P slice headers, the slices are never decoded so their payload
+	//only needs first_mb_in_slice
+	pattern := testPatternSource{width: 32, height: 32, fps: 100}
+	stream := bytes.Join([][]byte{
+		pattern.sps(),
+		pattern.pps(),
+		pattern.frame(0),
+		nalUnit(byte(h264.NALTypeSlice), []byte{0x9a, 0x21, 0x80}),
+		nalUnit(byte(h264.NALTypeSlice), []byte{0x9a, 0x22, 0x80}),
+	}, nil)
+	path := filepath.Join(t.TempDir(), "pattern.h264")
+	err := os.WriteFile(path, stream, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.CamConfig{Backend: BackendFile, File: path, Fps: "100"}
+	start := time.Now()
+	cam, samples, frames := streamCam(t, cfg, 8)
+	elapsed := time.Since(start)
+
+	checkFirstKeyframe(t, cam, frames[0])
+	checkDurations(t, samples, 100)
+	if elapsed < 6*10*time.Millisecond {
+		t.Fatalf("expected 8 frames at 100 fps to take at least 60ms, took %s", elapsed)
+	}
+
+	for i := range samples {
+		wantTypes := []h264.NALType{h264.NALTypeSlice}
+		if i%3 == 0 {
+			wantTypes = []h264.NALType{h264.NALTypeSPS, h264.NALTypePPS, h264.NALTypeIDR}
+		}
+		if !slices.Equal(frames[i].Types(), wantTypes) {
+			t.Fatalf("expected access unit %d to be %v, got %v", i, wantTypes, frames[i].Types())
+		}
+		if i >= 3 && !bytes.Equal(samples[i].Data, samples[i-3].Data) {
+			t.Fatalf("expected the file to loop, sample %d differs from sample %d", i, i-3)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package cam
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/Speshl/gorrc_client/internal/config"
+	"github.com/Speshl/gorrc_client/internal/h264"
+)
+
+const (
+	mbSize         = 16
+	testPatternRef = 3    //nal_ref_idc
+	testPatternIDR = 5    //nal_unit_type coded slice of an IDR picture
+	testPatternSPS = 7    //nal_unit_type sequence parameter set
+	testPatternPPS = 8    //nal_unit_type picture parameter set
+	iPCMType       = 25   //mb_type I_PCM in an I slice
+	baselineLevel  = 0x1f //3.1, matches the profile-level-id browsers offer by default
+)
+
+// SMPTE style bars as Y, Cb, Cr
+var testPatternBars = [][3]byte{
+	{235, 128, 128}, //white
+	{210, 16, 146},  //yellow
+	{170, 166, 16},  //cyan
+	{145, 54, 34},   //green
+	{106, 202, 222}, //magenta
+	{81, 90, 240},   //red
+	{41, 240, 110},  //blue
+	{16, 128, 128},  //black
+}
+
+// testPatternSource generates scrolling color bars as a constrained baseline H.264 stream. Every frame is
+// an IDR made of uncompressed I_PCM macroblocks, so no encoder is needed. The bitrate is high, keep the
+// resolution small.
+type testPatternSource struct {
+	width  int
+	height int
+	fps    int
+}
+
+func newTestPatternSource(cfg config.CamConfig) (*testPatternSource, error) {
+	width, err := strconv.Atoi(cfg.Width)
+	if err != nil {
+		return nil, fmt.Errorf("invalid test pattern width: %w", err)
+	}
+	height, err := strconv.Atoi(cfg.Height)
+	if err != nil {
+		return nil, fmt.Errorf("invalid test pattern height: %w", err)
+	}
+	if width <= 0 || height <= 0 || width%2 != 0 || height%2 != 0 {
+		return nil, fmt.Errorf("test pattern size must be positive and even: %dx%d", width, height)
+	}
+
+	return &testPatternSource{
+		width:  width,
+		height: height,
+		fps:    parseFps(cfg),
+	}, nil
+}
+
+func (s *testPatternSource) Open(ctx context.Context, tier Tier) (io.ReadCloser, error) {
+	pattern := *s
+	if tier.Width > 0 && tier.Height > 0 && tier.Fps > 0 {
+		pattern.width = tier.Width
+		pattern.height = tier.Height
+		pattern.fps = tier.Fps
+	}
+
+	log.Printf("streaming %dx%d test pattern at %d fps\n", pattern.width, pattern.height, pattern.fps)
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(pattern.stream(ctx, writer))
+	}()
+	return reader, nil
+}
+
+func (s *testPatternSource) stream(ctx context.Context, w io.Writer) error {
+	ticker := time.NewTicker(time.Second / time.Duration(s.fps))
+	defer ticker.Stop()
+
+	headers := append(s.sps(), s.pps()...)
+	for frame := 0; ; frame++ {
+		_, err := w.Write(headers)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(s.frame(frame))
+		if err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *testPatternSource) mbWidth() int {
+	return (s.width + mbSize - 1) / mbSize
+}
+
+func (s *testPatternSource) mbHeight() int {
+	return (s.height + mbSize - 1) / mbSize
+}
+
+func (s *testPatternSource) sps() []byte {
+	b := bitWriter{}
+	b.writeBits(66, 8)            //profile_idc baseline
+	b.writeBits(0xc0, 8)          //constraint_set0 and constraint_set1, constrained baseline
+	b.writeBits(baselineLevel, 8) //level_idc
+	b.writeUE(0)                  //seq_parameter_set_id
+	b.writeUE(0)                  //log2_max_frame_num_minus4
+	b.writeUE(2)                  //pic_order_cnt_type
+	b.writeUE(1)                  //max_num_ref_frames
+	b.writeBits(0, 1)             //gaps_in_frame_num_value_allowed_flag
+	b.writeUE(uint32(s.mbWidth() - 1))
+	b.writeUE(uint32(s.mbHeight() - 1))
+	b.writeBits(1, 1) //frame_mbs_only_flag
+	b.writeBits(1, 1) //direct_8x8_inference_flag
+
+	cropRight := (s.mbWidth()*mbSize - s.width) / 2 //crop units are 2 pixels for 4:2:0
+	cropBottom := (s.mbHeight()*mbSize - s.height) / 2
+	if cropRight > 0 || cropBottom > 0 {
+		b.writeBits(1, 1) //frame_cropping_flag
+		b.writeUE(0)
+		b.writeUE(uint32(cropRight))
+		b.writeUE(0)
+		b.writeUE(uint32(cropBottom))
+	} else {
+		b.writeBits(0, 1)
+	}
+
+	b.writeBits(0, 1) //vui_parameters_present_flag
+	b.writeTrailingBits()
+	return nalUnit(testPatternSPS, b.bytes())
+}
+
+func (s *testPatternSource) pps() []byte {
+	b := bitWriter{}
+	b.writeUE(0)      //pic_parameter_set_id
+	b.writeUE(0)      //seq_parameter_set_id
+	b.writeBits(0, 1) //entropy_coding_mode_flag, CAVLC
+	b.writeBits(0, 1) //bottom_field_pic_order_in_frame_present_flag
+	b.writeUE(0)      //num_slice_groups_minus1
+	b.writeUE(0)      //num_ref_idx_l0_default_active_minus1
+	b.writeUE(0)      //num_ref_idx_l1_default_active_minus1
+	b.writeBits(0, 1) //weighted_pred_flag
+	b.writeBits(0, 2) //weighted_bipred_idc
+	b.writeSE(0)      //pic_init_qp_minus26
+	b.writeSE(0)      //pic_init_qs_minus26
+	b.writeSE(0)      //chroma_qp_index_offset
+	b.writeBits(1, 1) //deblocking_filter_control_present_flag
+	b.writeBits(0, 1) //constrained_intra_pred_flag
+	b.writeBits(0, 1) //redundant_pic_cnt_present_flag
+	b.writeTrailingBits()
+	return nalUnit(testPatternPPS, b.bytes())
+}
+
+func (s *testPatternSource) frame(frame int) []byte {
+	b := bitWriter{}
+	b.writeUE(0)                    //first_mb_in_slice
+	b.writeUE(7)                    //slice_type, I and all slices in the picture are I
+	b.writeUE(0)                    //pic_parameter_set_id
+	b.writeBits(0, 4)               //frame_num, always 0 for IDR
+	b.writeUE(uint32(frame % 2))    //idr_pic_id, consecutive IDRs must differ
+	b.writeBits(0, 1)               //no_output_of_prior_pics_flag
+	b.writeBits(0, 1)               //long_term_reference_flag
+	b.writeSE(0)                    //slice_qp_delta
+	b.writeUE(1)                    //disable_deblocking_filter_idc
+	scroll := (frame * 4) % s.width //bars move 4 pixels per frame
+
+	for mbY := 0; mbY < s.mbHeight(); mbY++ {
+		for mbX := 0; mbX < s.mbWidth(); mbX++ {
+			b.writeUE(iPCMType)
+			b.alignZero() //pcm_alignment_zero_bit
+
+			for y := 0; y < mbSize; y++ {
+				for x := 0; x < mbSize; x++ {
+					b.writeBits(uint64(s.barColor(mbX*mbSize+x, scroll)[0]), 8)
+				}
+			}
+			for plane := 1; plane <= 2; plane++ {
+				for y := 0; y < mbSize/2; y++ {
+					for x := 0; x < mbSize/2; x++ {
+						b.writeBits(uint64(s.barColor(mbX*mbSize+x*2, scroll)[plane]), 8)
+					}
+				}
+			}
+		}
+	}
+
+	b.writeTrailingBits()
+	return nalUnit(testPatternIDR, b.bytes())
+}
+
+func (s *testPatternSource) barColor(x, scroll int) [3]byte {
+	bar := ((x + scroll) % s.width) * len(testPatternBars) / s.width
+	return testPatternBars[bar]
+}
+
+// nalUnit prefixes the rbsp with a start code and NAL header, adding emulation prevention bytes
+func nalUnit(nalType byte, rbsp []byte) []byte {
+	nal := make([]byte, 0, len(rbsp)+len(rbsp)/64+len(h264.StartCode)+1)
+	nal = append(nal, h264.StartCode...)
+	nal = append(nal, testPatternRef<<5|nalType)
+
+	zeros := 0
+	for _, value := range rbsp {
+		if zeros == 2 && value <= 3 {
+			nal = append(nal, 3)
+			zeros = 0
+		}
+		nal = append(nal, value)
+		if value == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+	return nal
+}
+
+type bitWriter struct {
+	buf     []byte
+	current byte
+	count   uint8
+}
+
+func (b *bitWriter) writeBits(value uint64, bits int) {
+	for i := bits - 1; i >= 0; i-- {
+		b.current = b.current<<1 | byte((value>>uint(i))&1)
+		b.count++
+		if b.count == 8 {
+			b.buf = append(b.buf, b.current)
+			b.current = 0
+			b.count = 0
+		}
+	}
+}
+
+// writeUE writes an unsigned exp-golomb value
+func (b *bitWriter) writeUE(value uint32) {
+	coded := uint64(value) + 1
+	bits := 0
+	for v := coded; v > 0; v >>= 1 {
+		bits++
+	}
+	b.writeBits(0, bits-1)
+	b.writeBits(coded, bits)
+}
+
+// writeSE writes a signed exp-golomb value
+func (b *bitWriter) writeSE(value int32) {
+	if value > 0 {
+		b.writeUE(uint32(value)*2 - 1)
+	} else {
+		b.writeUE(uint32(-value) * 2)
+	}
+}
+
+func (b *bitWriter) alignZero() {
+	for b.count != 0 {
+		b.writeBits(0, 1)
+	}
+}
+
+func (b *bitWriter) writeTrailingBits() {
+	b.writeBits(1, 1)
+	b.alignZero()
+}
+
+func (b *bitWriter) bytes() []byte {
+	return b.buf
+}
@@ -0,0 +1,80 @@
+package cam
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+
+	"github.com/Speshl/gorrc_client/internal/config"
+)
+
+// v4l2Source streams a USB/UVC camera through ffmpeg. H.264 cameras are passed through untouched,
+// MJPEG cameras are encoded with libx264.
+type v4l2Source struct {
+	cfg config.CamConfig
+}
+
+// newV4L2Source fails up front when ffmpeg is missing, rather than on every restart once the car is connected
+func newV4L2Source(cfg config.CamConfig) (VideoSource, error) {
+	_, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return nil, fmt.Errorf("v4l2 backend requires ffmpeg, install it or pick another backend: %w", err)
+	}
+	return &v4l2Source{cfg: cfg}, nil
+}
+
+// Open only applies the tier to MJPEG cameras, H.264 cameras are copied as they come
+func (s *v4l2Source) Open(ctx context.Context, tier Tier) (io.ReadCloser, error) {
+	args := []string{
+		"-hide_banner",
+		"-loglevel", "error",
+		"-fflags", "nobuffer",
+		"-flags", "low_delay",
+		"-f", "v4l2",
+		"-video_size", fmt.Sprintf("%sx%s", s.cfg.Width, s.cfg.Height),
+		"-framerate", s.cfg.Fps,
+	}
+
+	switch s.cfg.Format {
+	case FormatH264, "":
+		args = append(args,
+			"-input_format", "h264",
+			"-i", v4l2Device(s.cfg.Device),
+			"-c:v", "copy",
+		)
+	case FormatMJPEG:
+		args = append(args,
+			"-input_format", "mjpeg",
+			"-i", v4l2Device(s.cfg.Device),
+			"-vf", fmt.Sprintf("scale=%d:%d,fps=%d", tier.Width, tier.Height, tier.Fps),
+			"-c:v", "libx264",
+			"-preset", "ultrafast",
+			"-tune", "zerolatency",
+			"-profile:v", s.cfg.Profile,
+			"-pix_fmt", "yuv420p",
+			"-g", strconv.Itoa(intraPeriod(s.cfg, tier)),
+		)
+		if tier.Bitrate > 0 {
+			args = append(args, "-b:v", strconv.Itoa(tier.Bitrate))
+		}
+	default:
+		return nil, fmt.Errorf("unsupported v4l2 format: %s", s.cfg.Format)
+	}
+
+	args = append(args,
+		"-flush_packets", "1",
+		"-f", "h264",
+		"-", // Output to stdout
+	)
+	return startCommandStream(ctx, "ffmpeg", args)
+}
+
+// v4l2Device accepts either a device path or a bare video device index
+func v4l2Device(device string) string {
+	if _, err := strconv.Atoi(device); err == nil {
+		return "/dev/video" + device
+	}
+	return device
+}
@@ -0,0 +1,30 @@
+package cam
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Speshl/gorrc_client/internal/config"
+)
+
+func TestNewV4L2SourceNeedsFfmpeg(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("PATH", dir)
+	cfg := config.CamConfig{Backend: BackendV4L2, Device: "0"}
+
+	_, err := newVideoSource(cfg)
+	if err == nil || !strings.Contains(err.Error(), "ffmpeg") {
+		t.Fatalf("expected an error naming ffmpeg, got %v", err)
+	}
+
+	err = os.WriteFile(filepath.Join(dir, "ffmpeg"), []byte("#!/bin/sh\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = newVideoSource(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
@@ -0,0 +1,85 @@
+package clocksync
+
+import (
+	"sync"
+	"time"
+)
+
+const DefaultWindow = 16 //ping exchanges kept, the one with the lowest delay is used
+
+// Estimator tracks the offset between a remote clock and ours from NTP style exchanges. Of the recent
+// exchanges the one with the lowest round trip is trusted most, its delay was least likely to be lopsided.
+type Estimator struct {
+	lock    sync.Mutex
+	samples []sample
+	next    int
+	best    sample
+	synced  bool
+}
+
+type sample struct {
+	offset time.Duration //remote clock minus ours
+	delay  time.Duration //round trip without the time the remote held the message
+}
+
+func NewEstimator(window int) *Estimator {
+	if window <= 0 {
+		window = DefaultWindow
+	}
+	return &Estimator{
+		samples: make([]sample, 0, window),
+	}
+}
+
+// Add takes one exchange in unix ms: sent is when we sent, remoteReceived and remoteSent are the remote
+// clock when it got our message and replied, received is when the reply got back to us
+func (e *Estimator) Add(sent, remoteReceived, remoteSent, received int64) {
+	delay := (received - sent) - (remoteSent - remoteReceived)
+	if delay < 0 { //remote held it longer than the round trip, bad time stamps
+		return
+	}
+	s := sample{
+		offset: time.Duration(((remoteReceived-sent)+(remoteSent-received))/2) * time.Millisecond,
+		delay:  time.Duration(delay) * time.Millisecond,
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if len(e.samples) < cap(e.samples) {
+		e.samples = append(e.samples, s)
+	} else {
+		e.samples[e.next] = s
+		e.next = (e.next + 1) % len(e.samples)
+	}
+
+	e.best = e.samples[0]
+	for _, s := range e.samples[1:] {
+		if s.delay < e.best.delay {
+			e.best = s
+		}
+	}
+	e.synced = true
+}
+
+// Offset is the remote clock minus ours, false until an exchange completes
+func (e *Estimator) Offset() (time.Duration, bool) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.best.offset, e.synced
+}
+
+// OneWayDelay is half the best round trip
+func (e *Estimator) OneWayDelay() (time.Duration, bool) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.best.delay / 2, e.synced
+}
+
+// Local converts a remote unix ms time stamp to our clock, false until synced
+func (e *Estimator) Local(remote int64) (time.Time, bool) {
+	offset, ok := e.Offset()
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(remote).Add(-offset), true
+}
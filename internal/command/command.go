@@ -0,0 +1,20 @@
+package command
+
+const (
+	MaxValue = 1.0
+	MinValue = -1.0
+
+	MaxSupportedServos = 16
+)
+
+func MapToRange(value, min, max, minReturn, maxReturn float64) float64 {
+	mappedValue := (maxReturn-minReturn)*(value-min)/(max-min) + minReturn
+
+	if mappedValue > maxReturn {
+		return maxReturn
+	} else if mappedValue < minReturn {
+		return minReturn
+	} else {
+		return mappedValue
+	}
+}
@@ -3,6 +3,9 @@ package pca9685
 import (
 	"fmt"
 	"log"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	command "github.com/Speshl/gorrc_client/internal/command"
 	"github.com/Speshl/gorrc_client/internal/config"
@@ -19,18 +22,38 @@ const (
 	AcRange  = pca9685.ServoRangeDef
 
 	MaxSupportedServos = 16
+
+	WatchdogOff       = "off"
+	WatchdogGoroutine = "goroutine"
+	WatchdogProcess   = "process"
+
+	ActionFailsafe = "failsafe" //hold every servo at its failsafe value
+	ActionSleep    = "sleep"    //failsafe values, then stop all pulses
+
+	mode1Sleep   = 0x10
+	mode1Restart = 0x80
 )
 
 type CommandDriver struct {
 	cfg    config.CommandConfig
+	lock   sync.Mutex //the watchdog writes outputs from its own goroutine
+	i2c    *i2c.Options
 	servos map[string]Servo
 	driver *pca9685.PCA9685
+
+	lastBeat atomic.Int64 //unix nano of the last command from the vehicle loop
+	tripped  bool
+	asleep   bool
+	stopped  bool
+	done     chan struct{}
+	helper   *watchdogHelper
 }
 
 type Servo struct {
 	name     string
 	inverted bool
 	offset   float64
+	failsafe float64
 	servo    *pca9685.Servo
 }
 
@@ -40,42 +63,84 @@ func NewCommand(cfg config.CommandConfig) *CommandDriver {
 	}
 }
 
+// Stop puts every servo in its safe state and stops the watchdog
 func (c *CommandDriver) Stop() error {
-	return nil
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.driver == nil || c.stopped {
+		return nil
+	}
+	c.stopped = true
+
+	if c.done != nil {
+		close(c.done)
+	}
+
+	log.Println("putting servos in safe state")
+	err := c.makeSafe(c.cfg.WatchdogAction)
+	if err != nil {
+		if c.helper != nil {
+			c.helper.close() //the helper sees us exit without a clean stop and tries again
+		}
+		c.i2c.Close()
+		return fmt.Errorf("failed putting servos in safe state: %w", err)
+	}
+
+	if c.helper != nil {
+		c.helper.stop()
+	}
+	return c.i2c.Close()
 }
 
 func (c *CommandDriver) Init() error {
-	i2c, err := i2c.New(c.cfg.Address, c.cfg.I2CDevice)
+	i2c, driver, err := openChip(c.cfg)
 	if err != nil {
-		return fmt.Errorf("error starting i2c with address - %w", err)
+		return err
 	}
+	c.i2c = i2c
+	c.driver = driver
+	c.servos = newServos(c.cfg, driver)
+	c.CenterAll()
+	return c.startWatchdog()
+}
 
-	c.driver, err = pca9685.New(i2c, nil)
+func openChip(cfg config.CommandConfig) (*i2c.Options, *pca9685.PCA9685, error) {
+	i2c, err := i2c.New(cfg.Address, cfg.I2CDevice)
 	if err != nil {
-		return fmt.Errorf("error getting servo driver - %w", err)
+		return nil, nil, fmt.Errorf("error starting i2c with address - %w", err)
 	}
 
+	driver, err := pca9685.New(i2c, nil)
+	if err != nil {
+		i2c.Close()
+		return nil, nil, fmt.Errorf("error getting servo driver - %w", err)
+	}
+	return i2c, driver, nil
+}
+
+func newServos(cfg config.CommandConfig, driver *pca9685.PCA9685) map[string]Servo {
 	servos := make(map[string]Servo, MaxSupportedServos)
-	for i := range c.cfg.ServoCfgs {
-		name := c.cfg.ServoCfgs[i].Name
+	for i := range cfg.ServoCfgs {
+		name := cfg.ServoCfgs[i].Name
 		servos[name] = Servo{
 			name:     name,
-			inverted: c.cfg.ServoCfgs[i].Inverted,
-			offset:   float64(c.cfg.ServoCfgs[i].Offset) / 100,
-			servo: c.driver.ServoNew(c.cfg.ServoCfgs[i].Channel, &pca9685.ServOptions{
+			inverted: cfg.ServoCfgs[i].Inverted,
+			offset:   float64(cfg.ServoCfgs[i].Offset) / 100,
+			failsafe: cfg.ServoCfgs[i].Failsafe,
+			servo: driver.ServoNew(cfg.ServoCfgs[i].Channel, &pca9685.ServOptions{
 				AcRange:  AcRange,
-				MinPulse: float32(c.cfg.ServoCfgs[i].MinPulse),
-				MaxPulse: float32(c.cfg.ServoCfgs[i].MaxPulse),
+				MinPulse: float32(cfg.ServoCfgs[i].MinPulse),
+				MaxPulse: float32(cfg.ServoCfgs[i].MaxPulse),
 			}),
 		}
 		log.Printf("servo added: %s\n", name)
 	}
-	c.servos = servos
-	c.CenterAll()
-	return nil
+	return servos
 }
 
 func (c *CommandDriver) CenterAll() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
 	log.Println("centering all servos")
 	for i := range c.servos {
 		c.servos[i].servo.Fraction(0.5)
@@ -93,16 +158,95 @@ func (c *CommandDriver) SetMany(cmds []vehicle.DriverCommand) error {
 }
 
 func (c *CommandDriver) Set(cmd vehicle.DriverCommand) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.stopped {
+		return nil
+	}
+
+	c.beat()
+	if c.tripped {
+		err := c.resume()
+		if err != nil {
+			return err
+		}
+	}
+
 	val, ok := c.servos[cmd.Name]
 	if ok {
-		mappedValue := command.MapToRange(cmd.Value+val.offset, cmd.Min, cmd.Max, MinValue, MaxValue)
-		if c.servos[cmd.Name].inverted {
-			mappedValue = MaxValue - mappedValue
+		mappedValue := val.fraction(cmd.Value, cmd.Min, cmd.Max)
+		err := val.servo.Fraction(mappedValue)
+		if err != nil {
+			return fmt.Errorf("failed setting servo value - name: %s value:  %.2f - error: %w\n", cmd.Name, mappedValue, err)
 		}
+	}
+	return nil
+}
+
+// fraction maps a command value to the 0-1 range the chip takes, with offset and inversion applied
+func (s Servo) fraction(value, min, max float64) float32 {
+	mappedValue := command.MapToRange(value+s.offset, min, max, MinValue, MaxValue)
+	if s.inverted {
+		mappedValue = MaxValue - mappedValue
+	}
+	return float32(mappedValue)
+}
 
-		err := c.servos[cmd.Name].servo.Fraction(float32(mappedValue))
+// makeSafe writes every failsafe value and sleeps the chip when asked to
+func (c *CommandDriver) makeSafe(action string) error {
+	err := applyFailsafe(c.servos)
+	if err != nil {
+		return err
+	}
+
+	if action == ActionSleep {
+		err = sleepChip(c.i2c)
 		if err != nil {
-			return fmt.Errorf("failed setting servo value - name: %s value:  %.2f - error: %w\n", cmd.Name, mappedValue, err)
+			return err
+		}
+		c.asleep = true
+	}
+	return nil
+}
+
+func applyFailsafe(servos map[string]Servo) error {
+	for name, servo := range servos {
+		err := servo.servo.Fraction(servo.fraction(servo.failsafe, command.MinValue, command.MaxValue))
+		if err != nil {
+			return fmt.Errorf("failed setting failsafe for %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// sleepChip stops the oscillator, every output goes low and most escs treat that as signal loss
+func sleepChip(i2c *i2c.Options) error {
+	mode, err := i2c.ReadRegU8(pca9685.Mode1)
+	if err != nil {
+		return fmt.Errorf("failed reading mode: %w", err)
+	}
+	err = i2c.WriteRegU8(pca9685.Mode1, mode|mode1Sleep)
+	if err != nil {
+		return fmt.Errorf("failed sleeping chip: %w", err)
+	}
+	return nil
+}
+
+// wakeChip restarts the oscillator and the pwm channels where they left off
+func wakeChip(i2c *i2c.Options) error {
+	mode, err := i2c.ReadRegU8(pca9685.Mode1)
+	if err != nil {
+		return fmt.Errorf("failed reading mode: %w", err)
+	}
+	err = i2c.WriteRegU8(pca9685.Mode1, mode&^mode1Sleep)
+	if err != nil {
+		return fmt.Errorf("failed waking chip: %w", err)
+	}
+	time.Sleep(500 * time.Microsecond) //oscillator startup
+	if mode&mode1Restart != 0 {
+		err = i2c.WriteRegU8(pca9685.Mode1, (mode&^mode1Sleep)|mode1Restart)
+		if err != nil {
+			return fmt.Errorf("failed restarting chip: %w", err)
 		}
 	}
 	return nil
@@ -0,0 +1,310 @@
+package pca9685
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Speshl/gorrc_client/internal/config"
+)
+
+const (
+	WatchdogCommand = "watchdog" //argument that runs the binary as the watchdog helper
+
+	TripFd = 3 //the helper reports trips on this file, the first of its extra files
+
+	heartbeatByte = '.'
+	quitByte      = 'q' //sent on a clean stop, the driver already made the outputs safe
+	tripByte      = 't' //sent by the helper after it takes over the outputs
+)
+
+// startWatchdog watches for commands from the vehicle loop, which sends every servo on each tick. The
+// goroutine mode catches a hung vehicle loop, the process mode also catches the whole process dying.
+func (c *CommandDriver) startWatchdog() error {
+	timeout := watchdogTimeout(c.cfg)
+	c.beat()
+
+	switch c.cfg.Watchdog {
+	case WatchdogOff:
+		log.Println("warning: pca9685 watchdog disabled, outputs hold their last value if the client stops")
+		return nil
+	case WatchdogProcess:
+		helper, err := startWatchdogHelper(timeout, c.helperTripped)
+		if err != nil {
+			return fmt.Errorf("failed starting watchdog helper: %w", err)
+		}
+		c.helper = helper
+		return nil
+	case WatchdogGoroutine:
+	default:
+		log.Printf("warning: unknown watchdog mode %s, using %s\n", c.cfg.Watchdog, WatchdogGoroutine)
+	}
+
+	c.done = make(chan struct{})
+	go c.runWatchdog(timeout, c.done)
+	return nil
+}
+
+func watchdogTimeout(cfg config.CommandConfig) time.Duration {
+	if cfg.WatchdogTimeout <= 0 {
+		return config.DefaultWatchdogTimeout * time.Millisecond
+	}
+	return time.Duration(cfg.WatchdogTimeout) * time.Millisecond
+}
+
+func (c *CommandDriver) beat() {
+	now := time.Now()
+	c.lastBeat.Store(now.UnixNano())
+	if c.helper != nil {
+		c.helper.beat(now)
+	}
+}
+
+func (c *CommandDriver) runWatchdog(timeout time.Duration, done chan struct{}) {
+	ticker := time.NewTicker(timeout / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			if now.Sub(time.Unix(0, c.lastBeat.Load())) > timeout {
+				c.trip(timeout)
+			}
+		}
+	}
+}
+
+func (c *CommandDriver) trip(timeout time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.tripped || c.stopped {
+		return
+	}
+	c.tripped = true
+
+	log.Printf("error: no servo commands for %dms, watchdog applying %s\n", timeout.Milliseconds(), c.cfg.WatchdogAction)
+	err := c.makeSafe(c.cfg.WatchdogAction)
+	if err != nil {
+		log.Printf("error: watchdog failed making servos safe: %s\n", err.Error())
+	}
+}
+
+// helperTripped records that the helper process took over the outputs, the next command wakes the chip
+// and hands them back. The helper only sees a heartbeat every timeout/4, so it can trip when the commands
+// here never stopped for a full timeout.
+func (c *CommandDriver) helperTripped() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.tripped || c.stopped {
+		return
+	}
+	log.Printf("error: watchdog helper took over outputs with %s\n", c.cfg.WatchdogAction)
+	c.tripped = true
+	c.asleep = c.cfg.WatchdogAction == ActionSleep
+}
+
+// resume hands the outputs back once commands start again, lock must be held
+func (c *CommandDriver) resume() error {
+	log.Println("servo commands resumed, watchdog releasing outputs")
+	if c.asleep {
+		err := wakeChip(c.i2c)
+		if err != nil {
+			return err
+		}
+		c.asleep = false
+	}
+	c.tripped = false
+	return nil
+}
+
+// watchdogHelper is the client side of a child process running RunWatchdog, fed heartbeats over its stdin
+// and reporting trips back over TripFd
+type watchdogHelper struct {
+	lock     sync.Mutex
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	interval time.Duration
+	lastSent time.Time
+}
+
+func startWatchdogHelper(timeout time.Duration, onTrip func()) (*watchdogHelper, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed finding executable: %w", err)
+	}
+
+	cmd := exec.Command(executable, WatchdogCommand) //inherits the environment, so the same config
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed opening helper stdin: %w", err)
+	}
+
+	trips, tripsWriter, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed opening helper trip pipe: %w", err)
+	}
+	cmd.ExtraFiles = []*os.File{tripsWriter}
+
+	err = cmd.Start()
+	tripsWriter.Close() //the helper has its own copy
+	if err != nil {
+		trips.Close()
+		return nil, fmt.Errorf("failed starting helper: %w", err)
+	}
+	log.Printf("started watchdog helper process %d\n", cmd.Process.Pid)
+
+	go readTrips(trips, onTrip)
+
+	go func() {
+		err := cmd.Wait()
+		if err != nil {
+			log.Printf("error: watchdog helper exited: %s\n", err.Error())
+		}
+	}()
+
+	return &watchdogHelper{
+		cmd:      cmd,
+		stdin:    stdin,
+		interval: timeout / 4, //a few heartbeats per timeout, not one per servo command
+	}, nil
+}
+
+// readTrips calls onTrip for every trip the helper reports until it exits
+func readTrips(trips io.ReadCloser, onTrip func()) {
+	defer trips.Close()
+	buf := make([]byte, 16)
+	for {
+		n, err := trips.Read(buf)
+		for i := 0; i < n; i++ {
+			if buf[i] == tripByte {
+				onTrip()
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (h *watchdogHelper) beat(now time.Time) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if now.Sub(h.lastSent) < h.interval {
+		return
+	}
+	h.lastSent = now
+	_, err := h.stdin.Write([]byte{heartbeatByte})
+	if err != nil {
+		log.Printf("error: failed sending watchdog heartbeat: %s\n", err.Error())
+	}
+}
+
+// stop tells the helper the outputs are already safe and lets it exit
+func (h *watchdogHelper) stop() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.stdin.Write([]byte{quitByte})
+	h.stdin.Close()
+}
+
+func (h *watchdogHelper) close() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.stdin.Close()
+}
+
+// RunWatchdog is the helper process. It only touches the chip when heartbeats on the reader stop or the
+// client exits without a clean stop, then drives every servo to its failsafe value or sleeps the chip.
+// Each trip is reported on the writer so the client wakes the chip when its commands get through again.
+func RunWatchdog(cfg config.CommandConfig, heartbeats io.Reader, trips io.Writer) error {
+	signal.Ignore(os.Interrupt, syscall.SIGTERM) //a ctrl-c reaches the whole group, wait for the client to go first
+
+	timeout := watchdogTimeout(cfg)
+
+	beats := make(chan byte, 16)
+	go func() {
+		defer close(beats)
+		buf := make([]byte, 64)
+		for {
+			n, err := heartbeats.Read(buf)
+			for i := 0; i < n; i++ {
+				beats <- buf[i]
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	log.Printf("watchdog helper waiting for heartbeats, timeout %dms\n", timeout.Milliseconds())
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	tripped := false
+	for {
+		select {
+		case beat, ok := <-beats:
+			if !ok {
+				log.Println("error: client exited without a clean stop, watchdog helper taking over outputs")
+				return tripOutputs(cfg)
+			}
+			if beat == quitByte {
+				log.Println("watchdog helper stopping")
+				return nil
+			}
+			if tripped {
+				log.Println("heartbeat resumed, watchdog helper releasing outputs")
+				tripped = false
+			}
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(timeout)
+		case <-timer.C:
+			log.Printf("error: no heartbeat for %dms, watchdog helper taking over outputs\n", timeout.Milliseconds())
+			tripped = true
+			err := tripOutputs(cfg)
+			if err != nil {
+				log.Printf("error: %s\n", err.Error())
+			}
+			_, err = trips.Write([]byte{tripByte}) //reported even when it failed, waking an awake chip is harmless
+			if err != nil {
+				log.Printf("error: failed reporting watchdog trip: %s\n", err.Error())
+			}
+		}
+	}
+}
+
+// tripOutputs opens the chip from the helper process and makes every output safe. A client that starts
+// beating again after a sleep wakes the chip on its next command.
+func tripOutputs(cfg config.CommandConfig) error {
+	i2c, driver, err := openChip(cfg)
+	if err != nil {
+		return fmt.Errorf("watchdog helper failed opening chip: %w", err)
+	}
+	defer i2c.Close()
+
+	err = applyFailsafe(newServos(cfg, driver))
+	if err != nil {
+		return fmt.Errorf("watchdog helper failed applying failsafe: %w", err)
+	}
+	if cfg.WatchdogAction == ActionSleep {
+		err = sleepChip(i2c)
+		if err != nil {
+			return fmt.Errorf("watchdog helper failed sleeping chip: %w", err)
+		}
+	}
+	log.Printf("watchdog helper applied %s\n", cfg.WatchdogAction)
+	return nil
+}
@@ -0,0 +1,88 @@
+package pca9685
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Speshl/gorrc_client/internal/config"
+)
+
+// syncBuffer is written by the helper loop and read by the test
+type syncBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.String()
+}
+
+// TestRunWatchdogReportsTrips has no chip to open, the trip is reported even when taking over fails
+func TestRunWatchdogReportsTrips(t *testing.T) {
+	cfg := config.CommandConfig{
+		I2CDevice:       "/dev/null/no-i2c",
+		WatchdogTimeout: 40,
+		WatchdogAction:  ActionSleep,
+	}
+	heartbeats, beat := io.Pipe()
+	trips := &syncBuffer{}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- RunWatchdog(cfg, heartbeats, trips)
+	}()
+
+	for i := 0; i < 5; i++ { //beating keeps it quiet
+		beat.Write([]byte{heartbeatByte})
+		time.Sleep(10 * time.Millisecond)
+	}
+	if trips.String() != "" {
+		t.Fatalf("expected no trip while beating, got %q", trips.String())
+	}
+
+	time.Sleep(100 * time.Millisecond) //more than a timeout without a beat
+	if trips.String() != string(tripByte) {
+		t.Fatalf("expected one trip, got %q", trips.String())
+	}
+
+	beat.Write([]byte{quitByte})
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected a clean stop, got %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the helper to stop")
+	}
+}
+
+func TestHelperTripped(t *testing.T) {
+	c := NewCommand(config.CommandConfig{WatchdogAction: ActionSleep})
+	trips, report := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		readTrips(trips, c.helperTripped)
+	}()
+
+	report.Write([]byte{tripByte})
+	report.Close()
+	<-done
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !c.tripped || !c.asleep {
+		t.Fatalf("expected a reported trip to leave the chip tripped and asleep, got tripped %t asleep %t", c.tripped, c.asleep)
+	}
+}
@@ -0,0 +1,290 @@
+package sim
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Speshl/gorrc_client/internal/command"
+	"github.com/Speshl/gorrc_client/internal/config"
+	"github.com/Speshl/gorrc_client/internal/vehicle"
+)
+
+const (
+	UnmappedChannel = -1
+)
+
+// CommandDriver is a CommandDriverIFace that drives no hardware. Every command is mapped to the pulse
+// width the real drivers would output and kept in a history that can be shown, exported or replayed.
+type CommandDriver struct {
+	cfg     config.CommandConfig
+	lock    sync.RWMutex
+	servos  map[string]Servo
+	history []Record
+	done    chan struct{}
+}
+
+type Servo struct {
+	name     string
+	channel  int
+	inverted bool
+	offset   float64
+	maxPulse float64
+	minPulse float64
+
+	value   float64
+	pulse   float64
+	updates int
+	updated time.Time
+}
+
+// Record is a single applied DriverCommand
+type Record struct {
+	Time    time.Time `json:"time"`
+	Name    string    `json:"name"`
+	Channel int       `json:"channel"`
+	Value   float64   `json:"value"`
+	Min     float64   `json:"min"`
+	Max     float64   `json:"max"`
+	Pulse   float64   `json:"pulse"`
+}
+
+func NewCommand(cfg config.CommandConfig) *CommandDriver {
+	return &CommandDriver{
+		cfg: cfg,
+	}
+}
+
+func (c *CommandDriver) Init() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	servos := make(map[string]Servo, config.MaxSupportedServos)
+	for i := range c.cfg.ServoCfgs {
+		name := c.cfg.ServoCfgs[i].Name
+		servos[name] = Servo{
+			name:     name,
+			channel:  c.cfg.ServoCfgs[i].Channel,
+			inverted: c.cfg.ServoCfgs[i].Inverted,
+			offset:   float64(c.cfg.ServoCfgs[i].Offset) / 100,
+			maxPulse: c.cfg.ServoCfgs[i].MaxPulse,
+			minPulse: c.cfg.ServoCfgs[i].MinPulse,
+		}
+		log.Printf("sim servo added: %s\n", name)
+	}
+	c.servos = servos
+	c.history = make([]Record, 0, 1024)
+	c.centerAll()
+
+	if c.cfg.SimTableInterval > 0 {
+		c.done = make(chan struct{})
+		go c.startTablePrinter(time.Duration(c.cfg.SimTableInterval)*time.Millisecond, c.done)
+	}
+	return nil
+}
+
+func (c *CommandDriver) Stop() error {
+	c.lock.Lock()
+	if c.done != nil {
+		close(c.done)
+		c.done = nil
+	}
+	c.lock.Unlock()
+
+	if c.cfg.SimExportPath == "" {
+		return nil
+	}
+
+	file, err := os.Create(c.cfg.SimExportPath)
+	if err != nil {
+		return fmt.Errorf("failed creating sim export file: %w", err)
+	}
+	defer file.Close()
+
+	err = c.Export(file)
+	if err != nil {
+		return fmt.Errorf("failed exporting sim history: %w", err)
+	}
+	log.Printf("sim command history exported to %s\n", c.cfg.SimExportPath)
+	return nil
+}
+
+func (c *CommandDriver) CenterAll() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.centerAll()
+}
+
+func (c *CommandDriver) centerAll() {
+	log.Println("centering all sim servos")
+	for name, servo := range c.servos {
+		servo.value = 0.0
+		servo.pulse = (servo.maxPulse + servo.minPulse) / 2
+		c.servos[name] = servo
+	}
+}
+
+func (c *CommandDriver) SetMany(cmds []vehicle.DriverCommand) error {
+	for i := range cmds {
+		err := c.Set(cmds[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *CommandDriver) Set(cmd vehicle.DriverCommand) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	record := Record{
+		Time:    time.Now(),
+		Name:    cmd.Name,
+		Channel: UnmappedChannel,
+		Value:   cmd.Value,
+		Min:     cmd.Min,
+		Max:     cmd.Max,
+	}
+
+	val, ok := c.servos[cmd.Name]
+	if ok {
+		mappedValue := command.MapToRange(cmd.Value+val.offset, cmd.Min, cmd.Max, val.minPulse, val.maxPulse)
+		if val.inverted {
+			mappedValue = val.maxPulse + val.minPulse - mappedValue
+		}
+
+		val.value = cmd.Value
+		val.pulse = mappedValue
+		val.updates++
+		val.updated = record.Time
+		c.servos[cmd.Name] = val
+
+		record.Channel = val.channel
+		record.Pulse = mappedValue
+	}
+
+	c.appendRecord(record)
+	return nil
+}
+
+func (c *CommandDriver) appendRecord(record Record) {
+	limit := c.cfg.SimHistoryLimit
+	if limit > 0 && len(c.history) >= limit {
+		drop := limit / 10 //drop in batches so we are not copying the history on every command
+		if drop < 1 {
+			drop = 1
+		}
+		c.history = append(c.history[:0], c.history[drop:]...)
+	}
+	c.history = append(c.history, record)
+}
+
+// History returns a copy of every recorded command, oldest first
+func (c *CommandDriver) History() []Record {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	history := make([]Record, len(c.history))
+	copy(history, c.history)
+	return history
+}
+
+// Pulse returns the last pulse width in microseconds sent to the named servo
+func (c *CommandDriver) Pulse(name string) (float64, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	servo, ok := c.servos[name]
+	return servo.pulse, ok
+}
+
+// Table renders the current state of each configured channel
+func (c *CommandDriver) Table() string {
+	c.lock.RLock()
+	servos := make([]Servo, 0, len(c.servos))
+	for _, servo := range c.servos {
+		servos = append(servos, servo)
+	}
+	c.lock.RUnlock()
+
+	sort.Slice(servos, func(i, j int) bool {
+		return servos[i].channel < servos[j].channel
+	})
+
+	builder := strings.Builder{}
+	writer := tabwriter.NewWriter(&builder, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "CH\tNAME\tVALUE\tPULSE(us)\tUPDATES\tLAST")
+	for _, servo := range servos {
+		last := "-"
+		if !servo.updated.IsZero() {
+			last = fmt.Sprintf("%dms ago", time.Since(servo.updated).Milliseconds())
+		}
+		fmt.Fprintf(writer, "%d\t%s\t%.2f\t%.0f\t%d\t%s\n", servo.channel, servo.name, servo.value, servo.pulse, servo.updates, last)
+	}
+	writer.Flush()
+	return builder.String()
+}
+
+func (c *CommandDriver) startTablePrinter(interval time.Duration, done chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			log.Printf("sim servo state:\n%s", c.Table())
+		}
+	}
+}
+
+// Export writes the history as json lines
+func (c *CommandDriver) Export(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	for _, record := range c.History() {
+		err := encoder.Encode(record)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Replay sends the recorded history to another driver, keeping the original timing scaled by speed
+func (c *CommandDriver) Replay(ctx context.Context, target vehicle.CommandDriverIFace, speed float64) error {
+	if speed <= 0 {
+		speed = 1.0
+	}
+
+	history := c.History()
+	for i, record := range history {
+		if i > 0 {
+			wait := time.Duration(float64(record.Time.Sub(history[i-1].Time)) / speed)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+		}
+
+		err := target.Set(vehicle.DriverCommand{
+			Name:  record.Name,
+			Value: record.Value,
+			Min:   record.Min,
+			Max:   record.Max,
+		})
+		if err != nil {
+			return fmt.Errorf("failed replaying %s: %w", record.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package sim
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/Speshl/gorrc_client/internal/config"
+	"github.com/Speshl/gorrc_client/internal/models"
+	"github.com/Speshl/gorrc_client/internal/vehicle"
+	smallracer "github.com/Speshl/gorrc_client/internal/vehicle/smallRacer"
+)
+
+const (
+	axisSteer    = 0
+	axisThrottle = 1
+	axisBrake    = 2
+)
+
+func testControl(steer, throttle, brake float64, buttons ...int) models.ControlState {
+	axes := make([]float64, 10)
+	axes[axisSteer] = steer
+	axes[axisThrottle] = throttle
+	axes[axisBrake] = brake
+
+	var bitButton uint32
+	for _, button := range buttons {
+		bitButton |= 1 << button
+	}
+	return models.ControlState{Axes: axes, BitButton: bitButton}
+}
+
+func TestSmallRacerServos(t *testing.T) {
+	driver := NewCommand(config.CommandConfig{
+		ServoCfgs: []config.ServoConfig{
+			{Name: "esc", Channel: 0, MinPulse: 1000, MaxPulse: 2000},
+			{Name: "steer", Channel: 1, MinPulse: 1000, MaxPulse: 2000, Inverted: true},
+		},
+	})
+	racer := smallracer.NewSmallRacer(config.SmallRacerConfig{
+		VehicleConfig: config.VehicleConfig{GearRMin: -0.4, Gear1Min: -0.5, Gear1Max: 0.5},
+	}, driver, []models.Seat{{}})
+
+	var _ vehicle.ReplayableVehicle = racer
+	err := racer.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	released := -1.0 //triggers rest at -1
+	steps := []struct {
+		name    string
+		control models.ControlState
+		hold    bool
+		expired bool
+		esc     float64
+		steer   float64
+	}{
+		{name: "first command is skipped", control: testControl(1, 1, released), esc: 1500, steer: 1500},
+		{name: "neutral ignores throttle", control: testControl(1, 1, released), esc: 1500, steer: 1000},
+		{name: "shift into first", control: testControl(0, 1, released, smallracer.UpShift), esc: 1750, steer: 1500},
+		{name: "holding the shift stays in first", control: testControl(-1, 1, released, smallracer.UpShift), esc: 1750, steer: 2000},
+		{name: "brake in first", control: testControl(0, released, 1), esc: 1250, steer: 1500},
+		{name: "shift into neutral", control: testControl(0, 1, released, smallracer.DownShift), esc: 1500, steer: 1500},
+		{name: "release the shift", control: testControl(0, released, released), esc: 1500, steer: 1500},
+		{name: "shift into reverse and brake", control: testControl(0, released, 1, smallracer.DownShift), esc: 1300, steer: 1500},
+		{name: "held", control: testControl(1, released, 1), hold: true, esc: 1500, steer: 1500},
+		{name: "released back in neutral", control: testControl(1, released, released), esc: 1500, steer: 1500},
+		{name: "reverse again", control: testControl(1, released, 1, smallracer.DownShift), esc: 1300, steer: 1000},
+		{name: "centered once commands stop", control: testControl(1, released, 1, smallracer.DownShift), expired: true, esc: 1500, steer: 1500},
+	}
+
+	now := time.Now()
+	for i, step := range steps {
+		now = now.Add(33 * time.Millisecond)
+		step.control.Seq = uint32(i + 1)
+		step.control.TimeStamp = now.UnixMilli()
+		racer.Receive(0, step.control, now)
+		if step.hold {
+			racer.Hold("test")
+		}
+		if step.expired {
+			racer.CheckSafety(now.Add(time.Second))
+		}
+
+		err = racer.Step()
+		if err != nil {
+			t.Fatalf("%s: %s", step.name, err)
+		}
+		racer.Release("test")
+
+		for _, servo := range []struct {
+			name string
+			want float64
+		}{{"esc", step.esc}, {"steer", step.steer}} {
+			pulse, ok := driver.Pulse(servo.name)
+			if !ok || math.Abs(pulse-servo.want) > 0.001 {
+				t.Fatalf("%s: expected %s pulse %.1f, got %.1f", step.name, servo.name, servo.want, pulse)
+			}
+		}
+	}
+
+	history := driver.History()
+	if len(history) != 2*(len(steps)+1) {
+		t.Fatalf("expected %d records, got %d", 2*(len(steps)+1), len(history))
+	}
+	for _, record := range history {
+		if (record.Name == "esc" && record.Channel != 0) || (record.Name == "steer" && record.Channel != 1) {
+			t.Fatalf("expected %s on its configured channel, got %d", record.Name, record.Channel)
+		}
+	}
+}
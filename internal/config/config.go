@@ -15,11 +15,17 @@ func GetConfig() Config {
 		CamCfgs:    GetCamConfig(),
 		SpeakerCfg: GetSpeakerConfig(),
 		MicCfg:     GetMicConfig(),
+		RecordCfg:  GetRecorderConfig(),
+		ICECfg:     GetICEConfig(),
+		SensorCfg:  GetSensorConfig(),
 
 		//Vehicle specific configs
 		CrawlerCfg:    GetCrawlerConfig(),
 		SmallRacerCfg: GetSmallRacerConfig(),
 	}
+	if cfg.CommandCfg.CommandDriver == "pca9685" { //a sensor on the same bus must not answer at the servo chip's address
+		cfg.SensorCfg.ServoAddress = cfg.CommandCfg.Address
+	}
 
 	log.Printf("app Config: \n%+v\n", cfg)
 	return cfg
@@ -27,13 +33,20 @@ func GetConfig() Config {
 
 func GetServerConfig() ServerConfig {
 	return ServerConfig{
-		Server:         GetStringEnv("SERVER", DefaultServer),
-		Key:            GetStringEnv("CARKEY", DefaultCarKey),
-		Password:       GetStringEnv("CARPASSWORD", DefaultPassword),
-		SeatCount:      GetIntEnv("SEATCOUNT", DefaultSeatCount),
-		SilentStart:    GetBoolEnv("SILENTSTART", DefaultSilentStart),
-		SilentShutdown: GetBoolEnv("SILENTSHUTDOWN", DefaultSilentShutdown),
-		SilentConnect:  GetBoolEnv("SILENTCONNECT", DefaultSilentConnect),
+		Server:           GetStringEnv("SERVER", DefaultServer),
+		Key:              GetStringEnv("CARKEY", DefaultCarKey),
+		Password:         GetStringEnv("CARPASSWORD", DefaultPassword),
+		SeatCount:        GetIntEnv("SEATCOUNT", DefaultSeatCount),
+		SilentStart:      GetBoolEnv("SILENTSTART", DefaultSilentStart),
+		SilentShutdown:   GetBoolEnv("SILENTSHUTDOWN", DefaultSilentShutdown),
+		SilentConnect:    GetBoolEnv("SILENTCONNECT", DefaultSilentConnect),
+		ReconnectMin:     GetIntEnv("RECONNECT_MIN", DefaultReconnectMin),
+		ReconnectMax:     GetIntEnv("RECONNECT_MAX", DefaultReconnectMax),
+		HealthInterval:   GetIntEnv("HEALTH_INTERVAL", DefaultHealthInterval),
+		HealthMissed:     GetIntEnv("HEALTH_MISSED", DefaultHealthMissed),
+		ShutdownTimeout:  GetIntEnv("SHUTDOWN_TIMEOUT", DefaultShutdownTimeout),
+		SeatPolicy:       GetStringEnv("SEAT_POLICY", DefaultSeatPolicy),
+		SeatQueueTimeout: GetIntEnv("SEAT_QUEUE_TIMEOUT", DefaultSeatQueueTimeout),
 	}
 }
 
@@ -43,6 +56,16 @@ func GetCommandConfig() CommandConfig {
 		Address:       DefaultAddress, //  GetStringEnv("ADDRESS", DefaultAddress),
 		I2CDevice:     GetStringEnv("I2CDEVICE", DefaultI2CDevice),
 		ServoCfgs:     make([]ServoConfig, 0, MaxSupportedServos),
+		FailsafeStale: GetIntEnv("FAILSAFE_STALE", DefaultFailsafeStale),
+		CommandMaxAge: GetIntEnv("COMMAND_MAXAGE", DefaultCommandMaxAge),
+
+		Watchdog:        GetStringEnv("WATCHDOG", DefaultWatchdog),
+		WatchdogTimeout: GetIntEnv("WATCHDOG_TIMEOUT", DefaultWatchdogTimeout),
+		WatchdogAction:  GetStringEnv("WATCHDOG_ACTION", DefaultWatchdogAction),
+
+		SimTableInterval: GetIntEnv("SIM_TABLEINTERVAL", DefaultSimTableInterval),
+		SimHistoryLimit:  GetIntEnv("SIM_HISTORYLIMIT", DefaultSimHistoryLimit),
+		SimExportPath:    GetRawStringEnv("SIM_EXPORT", DefaultSimExportPath),
 	}
 
 	for i := 0; i < MaxSupportedServos; i++ {
@@ -54,6 +77,7 @@ func GetCommandConfig() CommandConfig {
 			MinPulse: float64(GetIntEnv(envPrefix+"MINPULSE", DefaultMinPulse)),
 			Inverted: GetBoolEnv(envPrefix+"INVERTED", DefaultInverted),
 			Offset:   GetIntEnv(envPrefix+"MIDOFFSET", DefaultOffset),
+			Failsafe: GetFloatEnv(envPrefix+"FAILSAFE", DefaultFailsafe),
 		}
 
 		if servoCfg.Name != "" {
@@ -70,10 +94,17 @@ func GetCamConfig() []CamConfig {
 		camPrefix := fmt.Sprintf("CAM%d_", i)
 		camCfgs = append(camCfgs, CamConfig{
 			Enabled:        GetBoolEnv(camPrefix+"ENABLED", DefaultCamEnable),
-			Device:         GetStringEnv(camPrefix+"DEVICE", DefaultSpeakerDevice),
+			Backend:        GetStringEnv(camPrefix+"BACKEND", DefaultCamBackend),
+			File:           GetRawStringEnv(camPrefix+"FILE", DefaultCamFile),
+			Device:         GetRawStringEnv(camPrefix+"DEVICE", DefaultCamDevice),
+			Format:         GetStringEnv(camPrefix+"FORMAT", DefaultCamFormat),
+			Pipeline:       GetRawStringEnv(camPrefix+"PIPELINE", DefaultCamPipeline),
 			Width:          GetStringEnv(camPrefix+"WIDTH", DefaultWidth),
 			Height:         GetStringEnv(camPrefix+"HEIGHT", DefaultHeight),
 			Fps:            GetStringEnv(camPrefix+"FPS", DefaultFPS),
+			Bitrate:        GetIntEnv(camPrefix+"BITRATE", DefaultCamBitrate),
+			Adaptive:       GetBoolEnv(camPrefix+"ADAPTIVE", DefaultCamAdaptive),
+			Intra:          GetIntEnv(camPrefix+"INTRA", DefaultCamIntra),
 			VerticalFlip:   GetBoolEnv(camPrefix+"VFLIP", DefaultVerticalFlip),
 			HorizontalFlip: GetBoolEnv(camPrefix+"HFLIP", DefaultHorizontalFlip),
 			Profile:        GetStringEnv(camPrefix+"PROFILE", DefaultProfile),
@@ -86,6 +117,65 @@ func GetCamConfig() []CamConfig {
 	return camCfgs
 }
 
+func GetICEConfig() ICEConfig {
+	iceCfg := ICEConfig{
+		Servers:         make([]ICEServerConfig, 0, MaxSupportedICE),
+		TransportPolicy: GetStringEnv("ICE_POLICY", DefaultICEPolicy),
+
+		PortMin:     GetIntEnv("ICE_PORTMIN", DefaultICEPortMin),
+		PortMax:     GetIntEnv("ICE_PORTMAX", DefaultICEPortMax),
+		Interfaces:  GetListEnv("ICE_INTERFACES", DefaultICEInterfaces),
+		IPs:         GetListEnv("ICE_IPS", DefaultICEIPs),
+		NAT1To1IPs:  GetListEnv("ICE_NAT1TO1", DefaultICENAT1To1),
+		NAT1To1Type: GetStringEnv("ICE_NAT1TO1_TYPE", DefaultICENAT1To1Type),
+		MDNS:        GetStringEnv("ICE_MDNS", DefaultICEMDNS),
+		UDPMuxPort:  GetIntEnv("ICE_UDPMUX_PORT", DefaultICEUDPMuxPort),
+	}
+	for i := 0; i < MaxSupportedICE; i++ {
+		icePrefix := fmt.Sprintf("ICE%d_", i)
+		defaultURLs := ""
+		if i == 0 {
+			defaultURLs = DefaultICEURLs
+		}
+
+		urls := GetListEnv(icePrefix+"URLS", defaultURLs)
+		if len(urls) == 0 {
+			continue
+		}
+
+		iceCfg.Servers = append(iceCfg.Servers, ICEServerConfig{
+			URLs:       urls,
+			Username:   GetRawStringEnv(icePrefix+"USERNAME", DefaultICEUsername),
+			Credential: GetRawStringEnv(icePrefix+"CREDENTIAL", DefaultICECredential),
+		})
+	}
+	return iceCfg
+}
+
+func GetSensorConfig() SensorConfig {
+	return SensorConfig{
+		Driver:    GetStringEnv("SENSOR_DRIVER", DefaultSensorDriver),
+		I2CDevice: GetStringEnv("I2CDEVICE", DefaultI2CDevice),
+		Address:   GetStringEnv("SENSOR_ADDRESS", DefaultSensorAddress),
+		Interval:  GetIntEnv("SENSOR_INTERVAL", DefaultSensorInterval),
+
+		BatteryCells:         GetIntEnv("BATTERY_CELLS", DefaultBatteryCells),
+		BatteryCapacity:      GetIntEnv("BATTERY_CAPACITY", DefaultBatteryCapacity),
+		BatteryCellWarn:      GetFloatEnv("BATTERY_CELL_WARN", DefaultBatteryCellWarn),
+		BatteryCellLimit:     GetFloatEnv("BATTERY_CELL_LIMIT", DefaultBatteryCellLimit),
+		BatteryThrottleLimit: GetFloatEnv("BATTERY_THROTTLE_LIMIT", DefaultBatteryThrottleLimit),
+		BatteryLimitServo:    GetStringEnv("BATTERY_LIMIT_SERVO", DefaultBatteryLimitServo),
+
+		INA219Shunt: GetFloatEnv("INA219_SHUNT", DefaultINA219Shunt),
+
+		ADS1115VoltageChannel: GetIntEnv("ADS1115_VOLTAGE_CHANNEL", DefaultADS1115VoltageChannel),
+		ADS1115VoltageScale:   GetFloatEnv("ADS1115_VOLTAGE_SCALE", DefaultADS1115VoltageScale),
+		ADS1115CurrentChannel: GetIntEnv("ADS1115_CURRENT_CHANNEL", DefaultADS1115CurrentChannel),
+		ADS1115CurrentScale:   GetFloatEnv("ADS1115_CURRENT_SCALE", DefaultADS1115CurrentScale),
+		ADS1115CurrentOffset:  GetFloatEnv("ADS1115_CURRENT_OFFSET", DefaultADS1115CurrentOffset),
+	}
+}
+
 func GetSpeakerConfig() SpeakerConfig {
 	return SpeakerConfig{
 		Enabled: GetBoolEnv("SPEAKERENABLED", DefaultSpeakerEnabled),
@@ -102,6 +192,16 @@ func GetMicConfig() MicConfig {
 	}
 }
 
+func GetRecorderConfig() RecorderConfig {
+	return RecorderConfig{
+		Enabled:        GetBoolEnv("RECORD_ENABLED", DefaultRecordEnabled),
+		AutoStart:      GetBoolEnv("RECORD_AUTOSTART", DefaultRecordAutoStart),
+		Dir:            GetRawStringEnv("RECORD_DIR", DefaultRecordDir),
+		MaxMB:          GetIntEnv("RECORD_MAXMB", DefaultRecordMaxMB),
+		SegmentSeconds: GetIntEnv("RECORD_SEGMENT", DefaultRecordSegment),
+	}
+}
+
 func GetCrawlerConfig() CrawlerConfig {
 	envPrefix := "CRAWLER_"
 	return CrawlerConfig{
@@ -136,6 +236,9 @@ func GetVehicleConfig() VehicleConfig {
 		Gear5Max:    GetFloatEnv("GEAR5_MAX", DefaultCrawlerGear5Max),
 		Gear6Min:    GetFloatEnv("GEAR6_MIN", DefaultCrawlerGear6Min),
 		Gear6Max:    GetFloatEnv("GEAR6_MAX", DefaultCrawlerGear6Max),
+
+		CamSwitchButton: GetIntEnv("CAMSWITCH_BUTTON", DefaultCamSwitchButton),
+		RecordButton:    GetIntEnv("RECORD_BUTTON", DefaultRecordButton),
 	}
 }
 
@@ -178,6 +281,28 @@ func GetStringEnv(env string, defaultValue string) string {
 	}
 }
 
+// GetRawStringEnv is GetStringEnv without lowercasing, for paths and credentials
+func GetRawStringEnv(env string, defaultValue string) string {
+	envValue, found := os.LookupEnv(AppEnvBase + env)
+	if !found {
+		return defaultValue
+	} else {
+		return strings.Trim(envValue, "\r")
+	}
+}
+
+// GetListEnv splits a comma separated GetRawStringEnv, dropping empty entries
+func GetListEnv(env string, defaultValue string) []string {
+	values := make([]string, 0, 2)
+	for _, value := range strings.Split(GetRawStringEnv(env, defaultValue), ",") {
+		value = strings.TrimSpace(value)
+		if value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
 func GetFloatEnv(env string, defaultValue float64) float64 {
 	envValue, found := os.LookupEnv(AppEnvBase + env)
 	if !found {
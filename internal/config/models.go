@@ -3,21 +3,33 @@ package config
 const (
 	MaxSupportedServos = 16
 	MaxSupportedCams   = 2
+	MaxSupportedICE    = 4
 	AppEnvBase         = "GORRC_"
 
-	DefaultServer         = "127.0.0.1:8181"
-	DefaultCarKey         = "c0b839e9-0962-4494-9840-4b8751e15d90" //TODO Remove after testing
-	DefaultVehicleType    = "smallracer"
-	DefaultPassword       = ""
-	DefaultSeatCount      = 1
-	DefaultSilentStart    = false
-	DefaultSilentConnect  = false
-	DefaultSilentShutdown = false
+	DefaultServer           = "127.0.0.1:8181"
+	DefaultCarKey           = "c0b839e9-0962-4494-9840-4b8751e15d90" //TODO Remove after testing
+	DefaultVehicleType      = "smallracer"
+	DefaultPassword         = ""
+	DefaultSeatCount        = 1
+	DefaultSilentStart      = false
+	DefaultSilentConnect    = false
+	DefaultSilentShutdown   = false
+	DefaultReconnectMin     = 1000   //ms before the first reconnect attempt
+	DefaultReconnectMax     = 30000  //ms cap on the reconnect backoff
+	DefaultHealthInterval   = 10000  //ms between server health checks
+	DefaultHealthMissed     = 3      //missed health replies before the car holds
+	DefaultShutdownTimeout  = 5000   //ms the shutdown sequence gets before the process exits anyway
+	DefaultSeatPolicy       = "keep" //keep or queue, a second user for a taken seat is turned away or waits
+	DefaultSeatQueueTimeout = 30000  //ms a queued offer stays usable
 
 	DefaultMaxPulse = 2250 //2000
 	DefaultMinPulse = 750  //1000
 	DefaultInverted = false
 	DefaultOffset   = 0
+	DefaultFailsafe = 0.0 //servo value held while failsafe is engaged, neutral unless set
+
+	DefaultFailsafeStale = 500 //ms of command delay or silence on the driver seat before failsafe engages
+	DefaultCommandMaxAge = 250 //ms since the browser sent a command before it is dropped, 0 keeps every command
 
 	// Default Camera Options
 	DefaultCamEnable      = false
@@ -26,8 +38,37 @@ const (
 	DefaultFPS            = "30"
 	DefaultVerticalFlip   = false
 	DefaultHorizontalFlip = false
-	DefaultProfile        = "high"
+	DefaultProfile        = "baseline" //sent as constrained baseline, the one profile every browser decodes
 	DefaultMode           = ""
+	DefaultCamBackend     = "libcamera"
+	DefaultCamFile        = ""
+	DefaultCamDevice      = "0"
+	DefaultCamFormat      = "h264"
+	DefaultCamPipeline    = ""
+	DefaultCamBitrate     = 0     //bits per second, 0 leaves it to the encoder
+	DefaultCamAdaptive    = false //step down resolution and bitrate on receiver report loss
+	DefaultCamIntra       = 0     //frames between keyframes, 0 uses half a second
+
+	// Default Recorder Options
+	DefaultRecordEnabled   = false
+	DefaultRecordAutoStart = false
+	DefaultRecordDir       = "recordings"
+	DefaultRecordMaxMB     = 2048 //oldest recordings are removed past this
+	DefaultRecordSegment   = 60   //seconds per file
+
+	// Default ICE Options
+	DefaultICEURLs        = "stun:stun.l.google.com:19302" //comma separated, empty for none on an offline network
+	DefaultICEUsername    = ""
+	DefaultICECredential  = ""
+	DefaultICEPolicy      = "all" //all or relay
+	DefaultICEPortMin     = 0     //ephemeral udp port range, 0 lets the os pick
+	DefaultICEPortMax     = 0
+	DefaultICEInterfaces  = ""      //comma separated interfaces to gather on, empty for all
+	DefaultICEIPs         = ""      //comma separated ips or cidrs to gather on, empty for all
+	DefaultICENAT1To1     = ""      //comma separated public ips to advertise
+	DefaultICENAT1To1Type = "host"  //host or srflx
+	DefaultICEMDNS        = "query" //disabled, query or gather
+	DefaultICEUDPMuxPort  = 0       //single udp port shared by every peer connection, 0 disables
 
 	// Default Speaker Options
 	DefaultSpeakerEnabled = false
@@ -44,6 +85,36 @@ const (
 	DefaultAddress       = 0x40
 	DefaultI2CDevice     = "/dev/i2c-1"
 
+	// Default Sensor Options
+	DefaultSensorDriver   = "none" //none, ina219, ads1115 or sim
+	DefaultSensorAddress  = ""     //i2c address like 0x41, empty for the driver default
+	DefaultSensorInterval = 500    //ms between battery readings
+
+	DefaultBatteryCells         = 0     //0 guesses the cell count from the first reading
+	DefaultBatteryCapacity      = 0     //mAh, 0 estimates remaining charge from voltage only
+	DefaultBatteryCellWarn      = 3.5   //V per cell under which the hud warns
+	DefaultBatteryCellLimit     = 3.3   //V per cell under which throttle is limited
+	DefaultBatteryThrottleLimit = 0.3   //fraction of throttle left once limited
+	DefaultBatteryLimitServo    = "esc" //servo the throttle limit applies to
+
+	DefaultINA219Shunt = 0.1 //ohms
+
+	DefaultADS1115VoltageChannel = 0
+	DefaultADS1115VoltageScale   = 11.0 //voltage divider ratio, 10k over 1k
+	DefaultADS1115CurrentChannel = -1   //-1 when there is no current sensor
+	DefaultADS1115CurrentScale   = 10.0 //amps per volt from the current sensor
+	DefaultADS1115CurrentOffset  = 0.0  //volts the current sensor reads at 0A
+
+	// Default PCA9685 Watchdog Options
+	DefaultWatchdog        = "goroutine" //off, goroutine or process
+	DefaultWatchdogTimeout = 500         //ms without servo commands before the outputs are made safe
+	DefaultWatchdogAction  = "failsafe"  //failsafe or sleep
+
+	// Default Sim Command Options
+	DefaultSimTableInterval = 1000 //ms, 0 disables the live table
+	DefaultSimHistoryLimit  = 100000
+	DefaultSimExportPath    = ""
+
 	//Vehicle Specific Configs
 	DefaultCrawlerGearRMin = -0.40
 	DefaultCrawlerGearRMax = 0.00
@@ -60,6 +131,9 @@ const (
 	DefaultCrawlerGear6Min = -1.00
 	DefaultCrawlerGear6Max = 1.00
 
+	DefaultCamSwitchButton = 13 //buttons the client handles for every vehicle, -1 turns one off
+	DefaultRecordButton    = 14
+
 	DefaultCrawlerPanSpeed  = 1
 	DefaultCrawlerTiltSpeed = 1
 
@@ -72,19 +146,29 @@ type Config struct {
 	CamCfgs    []CamConfig
 	SpeakerCfg SpeakerConfig
 	MicCfg     MicConfig
+	RecordCfg  RecorderConfig
+	ICECfg     ICEConfig
+	SensorCfg  SensorConfig
 
 	CrawlerCfg    CrawlerConfig
 	SmallRacerCfg SmallRacerConfig
 }
 
 type ServerConfig struct {
-	Server         string
-	Key            string
-	Password       string
-	SeatCount      int
-	SilentStart    bool
-	SilentShutdown bool
-	SilentConnect  bool
+	Server           string
+	Key              string
+	Password         string
+	SeatCount        int
+	SilentStart      bool
+	SilentShutdown   bool
+	SilentConnect    bool
+	ReconnectMin     int
+	ReconnectMax     int
+	HealthInterval   int
+	HealthMissed     int
+	ShutdownTimeout  int
+	SeatPolicy       string
+	SeatQueueTimeout int
 }
 
 type CommandConfig struct {
@@ -92,6 +176,18 @@ type CommandConfig struct {
 	Address       byte
 	I2CDevice     string
 	ServoCfgs     []ServoConfig
+	FailsafeStale int
+	CommandMaxAge int
+
+	//PCA9685 driver only
+	Watchdog        string
+	WatchdogTimeout int
+	WatchdogAction  string
+
+	//Sim driver only
+	SimTableInterval int
+	SimHistoryLimit  int
+	SimExportPath    string
 }
 
 type ServoConfig struct {
@@ -103,14 +199,22 @@ type ServoConfig struct {
 	MinPulse float64
 	DeadZone int
 	Offset   int
+	Failsafe float64
 }
 
 type CamConfig struct {
 	Enabled        bool
+	Backend        string
+	File           string
 	Device         string
+	Format         string
+	Pipeline       string
 	Width          string
 	Height         string
 	Fps            string
+	Bitrate        int
+	Adaptive       bool
+	Intra          int
 	DisableVideo   bool
 	HorizontalFlip bool
 	VerticalFlip   bool
@@ -121,6 +225,60 @@ type CamConfig struct {
 	Mode           string
 }
 
+type RecorderConfig struct {
+	Enabled        bool
+	AutoStart      bool
+	Dir            string
+	MaxMB          int
+	SegmentSeconds int
+}
+
+type SensorConfig struct {
+	Driver    string
+	I2CDevice string //shared with the servo driver
+	Address   string
+	Interval  int
+
+	ServoAddress byte //the pca9685 on the same bus, 0 when the servos are not driven over i2c
+
+	BatteryCells         int
+	BatteryCapacity      int
+	BatteryCellWarn      float64
+	BatteryCellLimit     float64
+	BatteryThrottleLimit float64
+	BatteryLimitServo    string
+
+	//INA219 only
+	INA219Shunt float64
+
+	//ADS1115 only
+	ADS1115VoltageChannel int
+	ADS1115VoltageScale   float64
+	ADS1115CurrentChannel int
+	ADS1115CurrentScale   float64
+	ADS1115CurrentOffset  float64
+}
+
+type ICEConfig struct {
+	Servers         []ICEServerConfig
+	TransportPolicy string
+
+	PortMin     int
+	PortMax     int
+	Interfaces  []string
+	IPs         []string
+	NAT1To1IPs  []string
+	NAT1To1Type string
+	MDNS        string
+	UDPMuxPort  int
+}
+
+type ICEServerConfig struct {
+	URLs       []string
+	Username   string
+	Credential string
+}
+
 type SpeakerConfig struct {
 	Enabled bool
 	Device  string
@@ -160,4 +318,7 @@ type VehicleConfig struct {
 	Gear5Max    float64
 	Gear6Min    float64
 	Gear6Max    float64
+
+	CamSwitchButton int
+	RecordButton    int
 }
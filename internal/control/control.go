@@ -0,0 +1,125 @@
+package control
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/Speshl/gorrc_client/internal/models"
+)
+
+// Protocol is the command data channel protocol that selects the binary encoding, any other protocol is JSON
+const Protocol = "gorrc-control-v1"
+
+// Binary layout, big endian:
+//
+//	0  magic 'G'
+//	1  version
+//	2  axis count
+//	3  flags, reserved and sent as 0
+//	4  seq uint32
+//	8  time stamp int64, unix ms
+//	16 bit buttons uint32
+//	20 axes int16 each, -32767 to 32767 for -1.0 to 1.0
+const (
+	Magic      = 'G'
+	Version    = 1
+	HeaderSize = 20
+	MaxAxes    = 32
+
+	axisScale  = math.MaxInt16
+	slabStates = 64 //states whose axes share one allocation
+)
+
+// Decoder turns a command data channel message into a control state
+type Decoder func(data []byte) (models.ControlState, error)
+
+// NewDecoder reads the binary encoding for one data channel, messages on a channel arrive one at a time.
+// Axes are cut from a buffer shared by the next slabStates messages instead of allocated per message.
+// Decoded axes are never written again, a state can still be queued for the seat when the next arrives.
+func NewDecoder() Decoder {
+	var slab []float64
+	return func(data []byte) (models.ControlState, error) {
+		return decode(data, func(n int) []float64 {
+			if len(slab) < n {
+				slab = make([]float64, slabStates*max(n, MaxAxes))
+			}
+			axes := slab[:n:n]
+			slab = slab[n:]
+			return axes
+		})
+	}
+}
+
+// Decode reads the binary encoding into freshly allocated axes
+func Decode(data []byte) (models.ControlState, error) {
+	return decode(data, func(n int) []float64 {
+		return make([]float64, n)
+	})
+}
+
+func decode(data []byte, axes func(n int) []float64) (models.ControlState, error) {
+	if len(data) < HeaderSize {
+		return models.ControlState{}, fmt.Errorf("message too short: %d bytes", len(data))
+	}
+	if data[0] != Magic {
+		return models.ControlState{}, fmt.Errorf("bad magic: 0x%02x", data[0])
+	}
+	if data[1] != Version {
+		return models.ControlState{}, fmt.Errorf("unsupported version: %d", data[1])
+	}
+
+	axisCount := int(data[2])
+	if axisCount > MaxAxes {
+		return models.ControlState{}, fmt.Errorf("too many axes: %d", axisCount)
+	}
+	if len(data) != HeaderSize+2*axisCount {
+		return models.ControlState{}, fmt.Errorf("message is %d bytes, expected %d for %d axes", len(data), HeaderSize+2*axisCount, axisCount)
+	}
+
+	state := models.ControlState{
+		Seq:       binary.BigEndian.Uint32(data[4:8]),
+		TimeStamp: int64(binary.BigEndian.Uint64(data[8:16])),
+		BitButton: binary.BigEndian.Uint32(data[16:20]),
+		Axes:      axes(max(axisCount, models.ClientAxesCount)), //vehicles index axes directly
+	}
+	for i := 0; i < axisCount; i++ {
+		value := int16(binary.BigEndian.Uint16(data[HeaderSize+2*i:]))
+		state.Axes[i] = math.Max(float64(value)/axisScale, -1.0) //-32768 is clamped
+	}
+	return state, nil
+}
+
+// Encode writes the binary encoding, axes are clamped to -1.0 to 1.0
+func Encode(state models.ControlState) ([]byte, error) {
+	if len(state.Axes) > MaxAxes {
+		return nil, fmt.Errorf("too many axes: %d", len(state.Axes))
+	}
+
+	data := make([]byte, HeaderSize+2*len(state.Axes))
+	data[0] = Magic
+	data[1] = Version
+	data[2] = byte(len(state.Axes))
+	binary.BigEndian.PutUint32(data[4:8], state.Seq)
+	binary.BigEndian.PutUint64(data[8:16], uint64(state.TimeStamp))
+	binary.BigEndian.PutUint32(data[16:20], state.BitButton)
+	for i, axis := range state.Axes {
+		if math.IsNaN(axis) {
+			axis = 0
+		}
+		axis = math.Max(-1.0, math.Min(1.0, axis))
+		binary.BigEndian.PutUint16(data[HeaderSize+2*i:], uint16(int16(math.Round(axis*axisScale))))
+	}
+	return data, nil
+}
+
+// DecodeJSON reads the original JSON encoding, still used by clients that do not ask for the binary protocol
+func DecodeJSON(data []byte) (models.ControlState, error) {
+	state := models.ControlState{}
+	err := json.Unmarshal(data, &state)
+	if err != nil {
+		return models.ControlState{}, err
+	}
+	return state, nil
+}
@@ -0,0 +1,164 @@
+package control
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/Speshl/gorrc_client/internal/models"
+)
+
+func encoded(t testing.TB, state models.ControlState) []byte {
+	t.Helper()
+	data, err := Encode(state)
+	if err != nil {
+		t.Fatalf("failed encoding: %s", err)
+	}
+	return data
+}
+
+// sameState compares axes to within one encoding step, 0.5 goes over the wire as 16384/32767
+func sameState(a models.ControlState, b models.ControlState) bool {
+	if a.Seq != b.Seq || a.TimeStamp != b.TimeStamp || a.BitButton != b.BitButton || len(a.Axes) != len(b.Axes) {
+		return false
+	}
+	for i := range a.Axes {
+		if math.Abs(a.Axes[i]-b.Axes[i]) > 1.0/axisScale {
+			return false
+		}
+	}
+	return true
+}
+
+func TestDecode(t *testing.T) {
+	full := models.ControlState{
+		Seq:       7,
+		TimeStamp: 1700000000123,
+		BitButton: 1<<13 | 1,
+		Axes:      []float64{1, -1, 0.5, -0.5, 0, 0, 0, 0, 0, 0},
+	}
+	valid := encoded(t, full)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    models.ControlState
+		wantErr bool
+	}{
+		{name: "full state", data: valid, want: full},
+		{
+			name: "fewer axes are padded",
+			data: encoded(t, models.ControlState{Seq: 1, Axes: []float64{0.25}}),
+			want: models.ControlState{Seq: 1, Axes: []float64{0.25, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+		},
+		{
+			name: "-32768 is clamped",
+			data: append(encoded(t, models.ControlState{}), 0x80, 0x00)[:HeaderSize+2],
+			want: models.ControlState{Axes: []float64{-1, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+		},
+		{name: "empty", data: nil, wantErr: true},
+		{name: "short header", data: valid[:HeaderSize-1], wantErr: true},
+		{name: "bad magic", data: append([]byte{'X'}, valid[1:]...), wantErr: true},
+		{name: "bad version", data: append([]byte{Magic, Version + 1}, valid[2:]...), wantErr: true},
+		{name: "truncated axes", data: valid[:len(valid)-1], wantErr: true},
+		{name: "trailing bytes", data: append(append([]byte{}, valid...), 0), wantErr: true},
+		{name: "too many axes", data: append([]byte{Magic, Version, MaxAxes + 1}, valid[3:]...), wantErr: true},
+	}
+	tests[2].data[2] = 1 //one axis, set after encoding an empty state
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Decode(test.data)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !sameState(got, test.want) {
+				t.Fatalf("expected %+v, got %+v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestNewDecoderKeepsStates(t *testing.T) {
+	decode := NewDecoder()
+	states := make([]models.ControlState, 0, 3*slabStates)
+	for i := 0; i < cap(states); i++ {
+		state, err := decode(encoded(t, models.ControlState{Seq: uint32(i), Axes: []float64{float64(i%100) / 100}}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		states = append(states, state)
+	}
+
+	for i, state := range states { //earlier states are not overwritten by later messages
+		if math.Abs(state.Axes[0]-float64(i%100)/100) > 1.0/axisScale || len(state.Axes) != models.ClientAxesCount || cap(state.Axes) != models.ClientAxesCount {
+			t.Fatalf("state %d changed: %v", i, state.Axes)
+		}
+	}
+}
+
+func TestNewDecoderAllocations(t *testing.T) {
+	decode := NewDecoder()
+	data := encoded(t, models.ControlState{Axes: make([]float64, models.ClientAxesCount)})
+	allocs := testing.AllocsPerRun(10*slabStates, func() {
+		_, err := decode(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs >= 0.5 {
+		t.Fatalf("expected the axes buffer to be shared, got %.2f allocations per message", allocs)
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	f.Add(encoded(f, models.ControlState{Seq: 1, TimeStamp: 1700000000000, BitButton: 3, Axes: []float64{1, -1, 0.5}}))
+	f.Add(encoded(f, models.ControlState{Axes: make([]float64, MaxAxes)}))
+	f.Add(encoded(f, models.ControlState{}))
+	f.Add([]byte{Magic, Version, 1, 0})
+	f.Add([]byte(`{"axes":[0.1],"bit_buttons":1}`))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		state, err := Decode(data)
+		if err != nil {
+			return
+		}
+		if len(state.Axes) < models.ClientAxesCount {
+			t.Fatalf("expected at least %d axes, got %d", models.ClientAxesCount, len(state.Axes))
+		}
+		for _, axis := range state.Axes {
+			if axis < -1 || axis > 1 {
+				t.Fatalf("axis out of range: %f", axis)
+			}
+		}
+
+		again, err := Decode(encoded(t, state)) //a decoded state survives a round trip unchanged
+		if err != nil {
+			t.Fatalf("failed decoding the re-encoded state: %s", err)
+		}
+		if !reflect.DeepEqual(state, again) {
+			t.Fatalf("round trip changed the state: %+v became %+v", state, again)
+		}
+
+		reused, err := NewDecoder()(data)
+		if err != nil || !reflect.DeepEqual(state, reused) {
+			t.Fatalf("shared buffer decoder disagrees: %+v, %v", reused, err)
+		}
+	})
+}
+
+func FuzzDecodeJSON(f *testing.F) {
+	f.Add([]byte(`{"axes":[0.1,-0.2],"bit_buttons":5,"time_stamp":1700000000000,"seq":2}`))
+	f.Add([]byte(`{}`))
+	f.Add([]byte(`[`))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, _ = DecodeJSON(data) //only has to not panic, the vehicle pads short axes
+	})
+}
@@ -0,0 +1,201 @@
+package failsafe
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Speshl/gorrc_client/internal/command"
+	"github.com/Speshl/gorrc_client/internal/config"
+	"github.com/Speshl/gorrc_client/internal/models"
+	"github.com/Speshl/gorrc_client/internal/vehicle"
+)
+
+const PanicReason = "process panic"
+
+// Driver sits between a vehicle and its command driver. While any failsafe reason is engaged every
+// configured servo is held at its failsafe value, e.g. brake on the esc, no matter what the vehicle sends.
+type Driver struct {
+	driver vehicle.CommandDriverIFace
+	names  []string
+	values map[string]float64
+
+	lock    sync.Mutex //held across writes so a vehicle tick can not land after the failsafe values
+	ranges  map[string]valueRange
+	reasons map[string]time.Time
+	limits  map[string]float64 //servo outputs scaled toward center, e.g. throttle on a low battery
+}
+
+type valueRange struct {
+	min float64
+	max float64
+}
+
+var defaultRange = valueRange{min: command.MinValue, max: command.MaxValue} //used until the vehicle sends a servo
+
+func NewDriver(cfg config.CommandConfig, driver vehicle.CommandDriverIFace) *Driver {
+	d := &Driver{
+		driver:  driver,
+		names:   make([]string, 0, len(cfg.ServoCfgs)),
+		values:  make(map[string]float64, len(cfg.ServoCfgs)),
+		ranges:  make(map[string]valueRange, len(cfg.ServoCfgs)),
+		reasons: make(map[string]time.Time, 4),
+		limits:  make(map[string]float64, 1),
+	}
+	for i := range cfg.ServoCfgs {
+		d.names = append(d.names, cfg.ServoCfgs[i].Name)
+		d.values[cfg.ServoCfgs[i].Name] = cfg.ServoCfgs[i].Failsafe
+	}
+	return d
+}
+
+func (d *Driver) Init() error {
+	return d.driver.Init()
+}
+
+func (d *Driver) Stop() error {
+	return d.driver.Stop()
+}
+
+func (d *Driver) Set(cmd vehicle.DriverCommand) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.driver.Set(d.filter(cmd))
+}
+
+func (d *Driver) SetMany(cmds []vehicle.DriverCommand) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	filtered := make([]vehicle.DriverCommand, len(cmds))
+	for i := range cmds {
+		filtered[i] = d.filter(cmds[i])
+	}
+	return d.driver.SetMany(filtered)
+}
+
+// filter remembers the range the vehicle uses for a servo and swaps in the failsafe value when engaged,
+// otherwise applies any limit
+func (d *Driver) filter(cmd vehicle.DriverCommand) vehicle.DriverCommand {
+	d.ranges[cmd.Name] = valueRange{min: cmd.Min, max: cmd.Max}
+	if len(d.reasons) == 0 {
+		scale, limited := d.limits[cmd.Name]
+		if limited {
+			center := (cmd.Min + cmd.Max) / 2
+			cmd.Value = center + (cmd.Value-center)*scale
+		}
+		return cmd
+	}
+
+	value, ok := d.values[cmd.Name]
+	if ok {
+		cmd.Value = value
+	}
+	return cmd
+}
+
+// Limit scales a servo's output toward the center of its range, 1.0 removes the limit. Failsafe values
+// are not limited.
+func (d *Driver) Limit(servo string, scale float64) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	scale = max(0, min(scale, 1))
+	if scale == 1 {
+		delete(d.limits, servo)
+		log.Printf("%s limit removed\n", servo)
+		return
+	}
+	d.limits[servo] = scale
+	log.Printf("%s limited to %.0f%%\n", servo, scale*100)
+}
+
+// Engage holds the outputs at their failsafe values until every engaged reason is cleared
+func (d *Driver) Engage(reason string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	_, engaged := d.reasons[reason]
+	if engaged {
+		return
+	}
+	d.reasons[reason] = time.Now()
+	log.Printf("failsafe engaged: %s\n", reason)
+
+	err := d.driver.SetMany(d.failsafeCommands())
+	if err != nil {
+		log.Printf("error: failed applying failsafe values: %s\n", err.Error())
+	}
+}
+
+// Clear releases one reason, the vehicle takes over again once none are left
+func (d *Driver) Clear(reason string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	engagedAt, engaged := d.reasons[reason]
+	if !engaged {
+		return
+	}
+	delete(d.reasons, reason)
+	log.Printf("failsafe cleared after %dms: %s (%d remaining)\n", time.Since(engagedAt).Milliseconds(), reason, len(d.reasons))
+}
+
+func (d *Driver) Active() bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return len(d.reasons) > 0
+}
+
+// Reasons returns every engaged reason, sorted
+func (d *Driver) Reasons() []string {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	reasons := make([]string, 0, len(d.reasons))
+	for reason := range d.reasons {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	return reasons
+}
+
+// HudAlerts describes the engaged reasons for the seat hud
+func (d *Driver) HudAlerts() []models.HudAlert {
+	reasons := d.Reasons()
+	if len(reasons) == 0 {
+		return nil
+	}
+	return []models.HudAlert{{Source: "failsafe", Severity: models.SeverityCritical, Text: strings.Join(reasons, ", ")}}
+}
+
+// RecoverPanic is deferred at the top of goroutines that can take the car down. It applies the failsafe
+// values before letting the panic continue, the outputs keep their last value after the process exits.
+func (d *Driver) RecoverPanic() {
+	r := recover()
+	if r == nil {
+		return
+	}
+	d.Engage(fmt.Sprintf("%s: %v", PanicReason, r))
+	panic(r)
+}
+
+func (d *Driver) failsafeCommands() []vehicle.DriverCommand {
+	cmds := make([]vehicle.DriverCommand, 0, len(d.names))
+	for _, name := range d.names {
+		valueRange, ok := d.ranges[name]
+		if !ok {
+			valueRange = defaultRange
+		}
+		cmds = append(cmds, vehicle.DriverCommand{
+			Name:  name,
+			Value: d.values[name],
+			Min:   valueRange.min,
+			Max:   valueRange.max,
+		})
+	}
+	return cmds
+}
@@ -0,0 +1,266 @@
+package failsafe
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/Speshl/gorrc_client/internal/command"
+	"github.com/Speshl/gorrc_client/internal/config"
+	"github.com/Speshl/gorrc_client/internal/vehicle"
+)
+
+// recordingDriver keeps every command it is sent
+type recordingDriver struct {
+	sent []vehicle.DriverCommand
+}
+
+func (d *recordingDriver) Init() error { return nil }
+func (d *recordingDriver) Stop() error { return nil }
+
+func (d *recordingDriver) Set(cmd vehicle.DriverCommand) error {
+	d.sent = append(d.sent, cmd)
+	return nil
+}
+
+func (d *recordingDriver) SetMany(cmds []vehicle.DriverCommand) error {
+	d.sent = append(d.sent, cmds...)
+	return nil
+}
+
+// take returns and forgets everything sent so far
+func (d *recordingDriver) take() []vehicle.DriverCommand {
+	sent := d.sent
+	d.sent = nil
+	return sent
+}
+
+func newTestDriver() (*Driver, *recordingDriver) {
+	recorder := &recordingDriver{}
+	cfg := config.CommandConfig{
+		ServoCfgs: []config.ServoConfig{
+			{Name: "esc", Failsafe: -0.5}, //brake
+			{Name: "steer"},
+			{Name: "pan", Failsafe: 0.2},
+		},
+	}
+	return NewDriver(cfg, recorder), recorder
+}
+
+func cmd(name string, value, min, max float64) vehicle.DriverCommand {
+	return vehicle.DriverCommand{Name: name, Value: value, Min: min, Max: max}
+}
+
+func TestEngage(t *testing.T) {
+	tests := []struct {
+		name string
+		sent []vehicle.DriverCommand //by the vehicle before engaging
+		want []vehicle.DriverCommand
+	}{
+		{
+			name: "nothing sent yet uses the default range",
+			want: []vehicle.DriverCommand{
+				cmd("esc", -0.5, command.MinValue, command.MaxValue),
+				cmd("steer", 0, command.MinValue, command.MaxValue),
+				cmd("pan", 0.2, command.MinValue, command.MaxValue),
+			},
+		},
+		{
+			name: "sent servos keep the vehicle's range",
+			sent: []vehicle.DriverCommand{cmd("esc", 0.8, -2, 2), cmd("steer", 0.3, 0, 180)},
+			want: []vehicle.DriverCommand{
+				cmd("esc", -0.5, -2, 2),
+				cmd("steer", 0, 0, 180),
+				cmd("pan", 0.2, command.MinValue, command.MaxValue),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d, recorder := newTestDriver()
+			err := d.SetMany(test.sent)
+			if err != nil {
+				t.Fatal(err)
+			}
+			recorder.take()
+
+			d.Engage("link lost")
+			got := recorder.take()
+			if !slices.Equal(got, test.want) {
+				t.Fatalf("expected %v, got %v", test.want, got)
+			}
+
+			d.Engage("link lost")
+			if len(recorder.take()) != 0 {
+				t.Fatal("expected engaging the same reason again to write nothing")
+			}
+		})
+	}
+}
+
+func TestSetWhileEngaged(t *testing.T) {
+	tests := []struct {
+		name    string
+		engaged bool
+		cmds    []vehicle.DriverCommand
+		want    []vehicle.DriverCommand
+	}{
+		{
+			name: "passed through",
+			cmds: []vehicle.DriverCommand{cmd("esc", 0.8, -1, 1), cmd("steer", -0.4, -1, 1)},
+			want: []vehicle.DriverCommand{cmd("esc", 0.8, -1, 1), cmd("steer", -0.4, -1, 1)},
+		},
+		{
+			name:    "failsafe values substituted",
+			engaged: true,
+			cmds:    []vehicle.DriverCommand{cmd("esc", 0.8, -1, 1), cmd("steer", -0.4, -1, 1)},
+			want:    []vehicle.DriverCommand{cmd("esc", -0.5, -1, 1), cmd("steer", 0, -1, 1)},
+		},
+		{
+			name:    "servos without a config pass through",
+			engaged: true,
+			cmds:    []vehicle.DriverCommand{cmd("horn", 1, 0, 1)},
+			want:    []vehicle.DriverCommand{cmd("horn", 1, 0, 1)},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, many := range []bool{false, true} {
+				d, recorder := newTestDriver()
+				if test.engaged {
+					d.Engage("link lost")
+					recorder.take()
+				}
+
+				if many {
+					err := d.SetMany(test.cmds)
+					if err != nil {
+						t.Fatal(err)
+					}
+				} else {
+					for i := range test.cmds {
+						err := d.Set(test.cmds[i])
+						if err != nil {
+							t.Fatal(err)
+						}
+					}
+				}
+
+				got := recorder.take()
+				if !slices.Equal(got, test.want) {
+					t.Fatalf("set many %t: expected %v, got %v", many, test.want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestReasons(t *testing.T) {
+	d, recorder := newTestDriver()
+	throttle := cmd("esc", 0.8, -1, 1)
+
+	d.Engage("server lost")
+	d.Engage("driver stale")
+	if !d.Active() || !slices.Equal(d.Reasons(), []string{"driver stale", "server lost"}) {
+		t.Fatalf("expected both reasons engaged, got %v", d.Reasons())
+	}
+	if alerts := d.HudAlerts(); len(alerts) != 1 || alerts[0].Text != "driver stale, server lost" {
+		t.Fatalf("expected one alert naming both reasons, got %v", alerts)
+	}
+
+	steps := []struct {
+		clear string
+		want  float64
+	}{
+		{clear: "not engaged", want: -0.5},
+		{clear: "server lost", want: -0.5},
+		{clear: "server lost", want: -0.5},
+		{clear: "driver stale", want: 0.8},
+	}
+	for _, step := range steps {
+		d.Clear(step.clear)
+		recorder.take()
+		err := d.Set(throttle)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := recorder.take()
+		if len(got) != 1 || got[0].Value != step.want {
+			t.Fatalf("after clearing %s expected esc %.1f, got %v", step.clear, step.want, got)
+		}
+	}
+	if d.Active() || d.HudAlerts() != nil {
+		t.Fatalf("expected nothing engaged, got %v", d.Reasons())
+	}
+}
+
+func TestLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		scale   float64
+		engaged bool
+		cmd     vehicle.DriverCommand
+		want    float64
+	}{
+		{name: "full throttle halved", scale: 0.5, cmd: cmd("esc", 1, -1, 1), want: 0.5},
+		{name: "full brake halved", scale: 0.5, cmd: cmd("esc", -1, -1, 1), want: -0.5},
+		{name: "scaled around the center of the range", scale: 0.5, cmd: cmd("esc", 180, 0, 180), want: 135},
+		{name: "center stays", scale: 0.3, cmd: cmd("esc", 90, 0, 180), want: 90},
+		{name: "other servos untouched", scale: 0.5, cmd: cmd("steer", 1, -1, 1), want: 1},
+		{name: "below zero holds center", scale: -1, cmd: cmd("esc", 1, -1, 1), want: 0},
+		{name: "one removes the limit", scale: 1, cmd: cmd("esc", 1, -1, 1), want: 1},
+		{name: "above one removes the limit", scale: 2, cmd: cmd("esc", 1, -1, 1), want: 1},
+		{name: "failsafe values are not limited", scale: 0.5, engaged: true, cmd: cmd("esc", 1, -1, 1), want: -0.5},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d, recorder := newTestDriver()
+			d.Limit("esc", 0.1) //replaced by the limit under test
+			d.Limit("esc", test.scale)
+			if test.engaged {
+				d.Engage("link lost")
+			}
+			recorder.take()
+
+			err := d.Set(test.cmd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := recorder.take()
+			if len(got) != 1 || got[0].Value != test.want {
+				t.Fatalf("expected %.2f, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestRecoverPanic(t *testing.T) {
+	d, recorder := newTestDriver()
+
+	recovered := func() (r any) {
+		defer func() {
+			r = recover()
+		}()
+		defer d.RecoverPanic()
+		panic("boom")
+	}()
+	if recovered != "boom" {
+		t.Fatalf("expected the panic to continue, recovered %v", recovered)
+	}
+	if !slices.Equal(d.Reasons(), []string{PanicReason + ": boom"}) {
+		t.Fatalf("expected the panic engaged, got %v", d.Reasons())
+	}
+	if got := recorder.take(); len(got) != 3 || got[0] != cmd("esc", -0.5, command.MinValue, command.MaxValue) {
+		t.Fatalf("expected the failsafe values written, got %v", got)
+	}
+
+	d, recorder = newTestDriver()
+	func() {
+		defer d.RecoverPanic()
+	}()
+	if d.Active() || len(recorder.take()) != 0 {
+		t.Fatalf("expected nothing engaged without a panic, got %v", d.Reasons())
+	}
+}
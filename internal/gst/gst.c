@@ -15,6 +15,12 @@ void gstreamer_receive_start_mainloop(void) {
   g_main_loop_run(gstreamer_receive_main_loop);
 }
 
+void gstreamer_receive_stop_mainloop(void) {
+  if (gstreamer_receive_main_loop != NULL) {
+    g_main_loop_quit(gstreamer_receive_main_loop);
+  }
+}
+
 static gboolean gstreamer_receive_bus_call(GstBus *bus, GstMessage *msg, gpointer data) {
   switch (GST_MESSAGE_TYPE(msg)) {
 
@@ -125,6 +131,8 @@ GstElement *gstreamer_send_create_pipeline(char *pipeline) {
   return gst_parse_launch(pipeline, &error);
 }
 
+static void gstreamer_send_free_user_data(gpointer data, GClosure *closure) { free(data); }
+
 void gstreamer_send_start_pipeline(GstElement *pipeline, int pipelineId) {
   SampleHandlerUserData *s = calloc(1, sizeof(SampleHandlerUserData));
   s->pipelineId = pipelineId;
@@ -135,7 +143,7 @@ void gstreamer_send_start_pipeline(GstElement *pipeline, int pipelineId) {
 
   GstElement *appsink = gst_bin_get_by_name(GST_BIN(pipeline), "appsink");
   g_object_set(appsink, "emit-signals", TRUE, NULL);
-  g_signal_connect(appsink, "new-sample", G_CALLBACK(gstreamer_send_new_sample_handler), s);
+  g_signal_connect_data(appsink, "new-sample", G_CALLBACK(gstreamer_send_new_sample_handler), s, gstreamer_send_free_user_data, 0);
   gst_object_unref(appsink);
 
   gst_element_set_state(pipeline, GST_STATE_PLAYING);
@@ -145,3 +153,20 @@ void gstreamer_send_stop_pipeline(GstElement *pipeline) {
   gst_element_set_state(pipeline, GST_STATE_NULL);
 }
 
+// gstreamer_send_free_pipeline drops the bus watch and the last reference, the user data goes with the appsink
+void gstreamer_send_free_pipeline(GstElement *pipeline) {
+  GstBus *bus = gst_pipeline_get_bus(GST_PIPELINE(pipeline));
+  gst_bus_remove_watch(bus);
+  gst_object_unref(bus);
+  gst_object_unref(pipeline);
+}
+
+void gstreamer_send_force_keyframe(GstElement *pipeline) {
+  GstElement *appsink = gst_bin_get_by_name(GST_BIN(pipeline), "appsink");
+  if (appsink != NULL) {
+    GstStructure *structure = gst_structure_new("GstForceKeyUnit", "all-headers", G_TYPE_BOOLEAN, TRUE, NULL);
+    gst_element_send_event(appsink, gst_event_new_custom(GST_EVENT_CUSTOM_UPSTREAM, structure));
+    gst_object_unref(appsink);
+  }
+}
+
@@ -11,6 +11,8 @@ extern void goHandlePipelineBuffer(void *buffer, int bufferLen, int samples, int
 GstElement *gstreamer_send_create_pipeline(char *pipeline);
 void gstreamer_send_start_pipeline(GstElement *pipeline, int pipelineId);
 void gstreamer_send_stop_pipeline(GstElement *pipeline);
+void gstreamer_send_free_pipeline(GstElement *pipeline);
+void gstreamer_send_force_keyframe(GstElement *pipeline);
 void gstreamer_send_start_mainloop(void);
 
 GstElement *gstreamer_receive_create_pipeline(char *pipeline);
@@ -18,5 +20,6 @@ void gstreamer_receive_start_pipeline(GstElement *pipeline);
 void gstreamer_receive_stop_pipeline(GstElement *pipeline);
 void gstreamer_receive_push_buffer(GstElement *pipeline, void *buffer, int len);
 void gstreamer_receive_start_mainloop(void);
+void gstreamer_receive_stop_mainloop(void);
 
 #endif
\ No newline at end of file
@@ -25,6 +25,11 @@ func StartMainRecieveLoop() {
 	C.gstreamer_receive_start_mainloop()
 }
 
+// StopMainRecieveLoop quits GLib's main loop so StartMainRecieveLoop returns
+func StopMainRecieveLoop() {
+	C.gstreamer_receive_stop_mainloop()
+}
+
 // Pipeline is a wrapper for a GStreamer Pipeline
 type RecievePipeline struct {
 	Pipeline *C.GstElement
@@ -30,10 +30,14 @@ type SendPipeline struct {
 	id        int
 	codecName string
 	clockRate float32
+
+	lock    sync.Mutex //Stop frees the pipeline, ForceKeyframe can race it
+	stopped bool
 }
 
 var pipelines = make(map[int]*SendPipeline)
 var pipelinesLock sync.Mutex
+var nextPipelineID int //ids are not reused, a stopped pipeline is removed from pipelines
 
 const (
 	videoClockRate = 90000
@@ -58,23 +62,32 @@ func CreateMicSendPipeline(tracks []*webrtc.TrackLocalStaticSample, device, volu
 	pipeline := &SendPipeline{
 		Pipeline:  C.gstreamer_send_create_pipeline(pipelineStrUnsafe),
 		tracks:    tracks,
-		id:        len(pipelines),
+		id:        nextPipelineID,
 		codecName: "opus",
 		clockRate: clockRate,
 	}
 
+	nextPipelineID++
 	pipelines[pipeline.id] = pipeline
 	return pipeline
 }
 
-// CreatePipeline creates a GStreamer Pipeline
-func CreateSendPipeline(codecName string, tracks []*webrtc.TrackLocalStaticSample, pipelineSrc string) *SendPipeline {
+// CreatePipeline creates a GStreamer Pipeline. Bitrate in bits per second and the frames between keyframes
+// only apply to the vp8 and h264 encoders, 0 keeps the encoder defaults.
+func CreateSendPipeline(codecName string, tracks []*webrtc.TrackLocalStaticSample, pipelineSrc string, bitrate int, keyIntMax int) *SendPipeline {
 	pipelineStr := "appsink name=appsink"
 	var clockRate float32
 
 	switch codecName {
 	case "vp8":
-		pipelineStr = pipelineSrc + " ! vp8enc error-resilient=partitions keyframe-max-dist=10 auto-alt-ref=true cpu-used=5 deadline=1 ! " + pipelineStr
+		if keyIntMax <= 0 {
+			keyIntMax = 10
+		}
+		encoder := fmt.Sprintf("vp8enc error-resilient=partitions keyframe-max-dist=%d auto-alt-ref=true cpu-used=5 deadline=1", keyIntMax)
+		if bitrate > 0 {
+			encoder += fmt.Sprintf(" target-bitrate=%d", bitrate)
+		}
+		pipelineStr = pipelineSrc + " ! " + encoder + " ! " + pipelineStr
 		clockRate = videoClockRate
 
 	case "vp9":
@@ -82,7 +95,14 @@ func CreateSendPipeline(codecName string, tracks []*webrtc.TrackLocalStaticSampl
 		clockRate = videoClockRate
 
 	case "h264":
-		pipelineStr = pipelineSrc + " ! video/x-raw,format=I420 ! x264enc speed-preset=ultrafast tune=zerolatency key-int-max=20 ! video/x-h264,stream-format=byte-stream ! " + pipelineStr
+		if keyIntMax <= 0 {
+			keyIntMax = 20
+		}
+		encoder := fmt.Sprintf("x264enc speed-preset=ultrafast tune=zerolatency key-int-max=%d", keyIntMax)
+		if bitrate > 0 {
+			encoder += fmt.Sprintf(" bitrate=%d", max(bitrate/1000, 1)) //kbit/s
+		}
+		pipelineStr = pipelineSrc + " ! video/x-raw,format=I420 ! " + encoder + " ! video/x-h264,stream-format=byte-stream,profile=constrained-baseline ! " + pipelineStr
 		clockRate = videoClockRate
 
 	case "opus":
@@ -116,11 +136,12 @@ func CreateSendPipeline(codecName string, tracks []*webrtc.TrackLocalStaticSampl
 	pipeline := &SendPipeline{
 		Pipeline:  C.gstreamer_send_create_pipeline(pipelineStrUnsafe),
 		tracks:    tracks,
-		id:        len(pipelines),
+		id:        nextPipelineID,
 		codecName: codecName,
 		clockRate: clockRate,
 	}
 
+	nextPipelineID++
 	pipelines[pipeline.id] = pipeline
 	return pipeline
 }
@@ -130,9 +151,33 @@ func (p *SendPipeline) Start() {
 	C.gstreamer_send_start_pipeline(p.Pipeline, C.int(p.id))
 }
 
-// Stop stops the GStreamer Pipeline
+// Stop stops the GStreamer Pipeline and frees it, it can not be started again
 func (p *SendPipeline) Stop() {
-	C.gstreamer_send_stop_pipeline(p.Pipeline)
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.stopped {
+		return
+	}
+	p.stopped = true
+
+	C.gstreamer_send_stop_pipeline(p.Pipeline) //no samples are handed over once this returns
+
+	pipelinesLock.Lock()
+	delete(pipelines, p.id)
+	pipelinesLock.Unlock()
+
+	C.gstreamer_send_free_pipeline(p.Pipeline)
+	p.Pipeline = nil
+}
+
+// ForceKeyframe asks the encoder in the pipeline for a keyframe with SPS/PPS headers
+func (p *SendPipeline) ForceKeyframe() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.stopped {
+		return
+	}
+	C.gstreamer_send_force_keyframe(p.Pipeline)
 }
 
 //export goHandlePipelineBuffer
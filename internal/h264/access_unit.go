@@ -0,0 +1,148 @@
+package h264
+
+import (
+	"io"
+	"time"
+)
+
+// AccessUnit is every NAL unit that makes up one coded picture, along with any parameter sets and SEI sent
+// in front of it
+type AccessUnit struct {
+	NALs     []NALUnit
+	Duration time.Duration
+}
+
+// Keyframe reports whether the access unit holds an IDR picture
+func (a AccessUnit) Keyframe() bool {
+	for _, nal := range a.NALs {
+		if nal.Type() == NALTypeIDR {
+			return true
+		}
+	}
+	return false
+}
+
+// Bytes returns the access unit as an Annex-B stream using 4 byte start codes
+func (a AccessUnit) Bytes() []byte {
+	size := 0
+	for _, nal := range a.NALs {
+		size += len(StartCode) + len(nal.Data)
+	}
+
+	data := make([]byte, 0, size)
+	for _, nal := range a.NALs {
+		data = append(data, StartCode...)
+		data = append(data, nal.Data...)
+	}
+	return data
+}
+
+// Types lists the NAL types in the access unit, handy for logging
+func (a AccessUnit) Types() []NALType {
+	types := make([]NALType, len(a.NALs))
+	for i := range a.NALs {
+		types[i] = a.NALs[i].Type()
+	}
+	return types
+}
+
+// AccessUnitReader groups NAL units from a Parser into access units following the first VCL NAL rules
+// of the H.264 spec (7.4.1.2.3). Each access unit gets the duration of one frame at the given fps.
+type AccessUnitReader struct {
+	parser  *Parser
+	fps     int
+	pending []NALUnit
+	vclSeen bool
+	next    *NALUnit //first NAL of the following access unit
+	err     error
+}
+
+func NewAccessUnitReader(parser *Parser, fps int) *AccessUnitReader {
+	return &AccessUnitReader{
+		parser: parser,
+		fps:    fps,
+	}
+}
+
+// SetFps changes the frame duration of access units read from now on
+func (r *AccessUnitReader) SetFps(fps int) {
+	r.fps = fps
+}
+
+// ReadAccessUnit returns the next complete access unit. The last partial access unit is returned before
+// the parser's error.
+func (r *AccessUnitReader) ReadAccessUnit() (AccessUnit, error) {
+	if r.next != nil {
+		r.add(*r.next)
+		r.next = nil
+	}
+
+	for r.err == nil {
+		nal, err := r.parser.ReadNAL()
+		if err != nil {
+			r.err = err
+			break
+		}
+
+		if r.vclSeen && startsAccessUnit(nal) {
+			r.next = &nal
+			return r.flush(), nil
+		}
+		r.add(nal)
+	}
+
+	if len(r.pending) > 0 {
+		return r.flush(), nil
+	}
+	return AccessUnit{}, r.err
+}
+
+func (r *AccessUnitReader) add(nal NALUnit) {
+	r.pending = append(r.pending, nal)
+	if nal.Type().IsVCL() {
+		r.vclSeen = true
+	}
+}
+
+func (r *AccessUnitReader) flush() AccessUnit {
+	au := AccessUnit{
+		NALs:     r.pending,
+		Duration: frameDuration(r.fps),
+	}
+	r.pending = nil
+	r.vclSeen = false
+	return au
+}
+
+// startsAccessUnit reports whether nal begins a new access unit, only valid once the current one has a VCL NAL
+func startsAccessUnit(nal NALUnit) bool {
+	switch nal.Type() {
+	case NALTypeAUD, NALTypeSPS, NALTypePPS, NALTypeSEI, NALTypePrefix, 16, 17, 18:
+		return true
+	case NALTypeSlice, NALTypeIDR:
+		return nal.FirstSliceInPicture()
+	}
+	return false
+}
+
+func frameDuration(fps int) time.Duration {
+	if fps <= 0 {
+		return 0
+	}
+	return time.Second / time.Duration(fps)
+}
+
+// ReadAll reads every access unit until the parser reports io.EOF
+func (r *AccessUnitReader) ReadAll() ([]AccessUnit, error) {
+	units := make([]AccessUnit, 0, 64)
+	for {
+		au, err := r.ReadAccessUnit()
+		if err == io.EOF {
+			return units, nil
+		}
+		if err != nil {
+			return units, err
+		}
+		units = append(units, au)
+	}
+}
@@ -0,0 +1,136 @@
+package h264
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestAccessUnitReader(t *testing.T) {
+	tests := []struct {
+		name      string
+		stream    []byte
+		want      [][]NALType
+		keyframes []bool
+	}{
+		{
+			name:      "libcamera-vid headers before each idr",
+			stream:    testStream(),
+			want:      [][]NALType{{NALTypeSPS, NALTypePPS, NALTypeIDR}, {NALTypeSlice}, {NALTypeSlice}, {NALTypeSPS, NALTypePPS, NALTypeIDR}, {NALTypeSlice}},
+			keyframes: []bool{true, false, false, true, false},
+		},
+		{
+			name:      "aud starts each access unit",
+			stream:    annexB(StartCode, testAUD, StartCode, testSPS, shortCode, testPPS, shortCode, testIDR, StartCode, testAUD, shortCode, testSlice, StartCode, testAUD, shortCode, testSlice),
+			want:      [][]NALType{{NALTypeAUD, NALTypeSPS, NALTypePPS, NALTypeIDR}, {NALTypeAUD, NALTypeSlice}, {NALTypeAUD, NALTypeSlice}},
+			keyframes: []bool{true, false, false},
+		},
+		{
+			name:      "slices of one picture stay together",
+			stream:    annexB(StartCode, testSPS, StartCode, testPPS, StartCode, testIDR, StartCode, testIDR2, StartCode, testSlice, StartCode, testSliceB, StartCode, testSlice),
+			want:      [][]NALType{{NALTypeSPS, NALTypePPS, NALTypeIDR, NALTypeIDR}, {NALTypeSlice, NALTypeSlice}, {NALTypeSlice}},
+			keyframes: []bool{true, false, false},
+		},
+		{
+			name:      "sei goes with the following picture",
+			stream:    annexB(StartCode, testSPS, StartCode, testPPS, StartCode, testSEI, StartCode, testIDR, StartCode, testSEI, StartCode, testSlice),
+			want:      [][]NALType{{NALTypeSPS, NALTypePPS, NALTypeSEI, NALTypeIDR}, {NALTypeSEI, NALTypeSlice}},
+			keyframes: []bool{true, false},
+		},
+		{
+			name:      "end of sequence stays with its picture",
+			stream:    annexB(StartCode, testIDR, StartCode, testEndOfSeq, StartCode, testSPS, StartCode, testPPS, StartCode, testIDR),
+			want:      [][]NALType{{NALTypeIDR, NALTypeEndSeq}, {NALTypeSPS, NALTypePPS, NALTypeIDR}},
+			keyframes: []bool{true, true},
+		},
+		{
+			name:      "headers without a picture are returned at the end",
+			stream:    annexB(StartCode, testSlice, StartCode, testSPS, StartCode, testPPS),
+			want:      [][]NALType{{NALTypeSlice}, {NALTypeSPS, NALTypePPS}},
+			keyframes: []bool{false, false},
+		},
+		{
+			name:   "empty",
+			stream: nil,
+			want:   [][]NALType{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			units, err := NewAccessUnitReader(NewParser(iotest.HalfReader(bytes.NewReader(test.stream))), 30).ReadAll()
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			got := make([][]NALType, len(units))
+			for i, au := range units {
+				got[i] = au.Types()
+				if au.Keyframe() != test.keyframes[i] {
+					t.Errorf("access unit %d: expected keyframe %t", i, test.keyframes[i])
+				}
+				if au.Duration != time.Second/30 {
+					t.Errorf("access unit %d: expected duration %s, got %s", i, time.Second/30, au.Duration)
+				}
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("expected %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestAccessUnitBytes(t *testing.T) {
+	stream := annexB(StartCode, testSPS, shortCode, testPPS, shortCode, testIDR, shortCode, testSlice)
+	units, err := NewAccessUnitReader(NewParser(bytes.NewReader(stream)), 30).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(units) != 2 {
+		t.Fatalf("expected 2 access units, got %d", len(units))
+	}
+
+	want := annexB(StartCode, testSPS, StartCode, testPPS, StartCode, testIDR) //always written with 4 byte start codes
+	if !bytes.Equal(units[0].Bytes(), want) {
+		t.Fatalf("expected % x, got % x", want, units[0].Bytes())
+	}
+
+	again, err := NewAccessUnitReader(NewParser(bytes.NewReader(append(units[0].Bytes(), units[1].Bytes()...))), 30).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again, units) {
+		t.Fatalf("expected the written access units to read back the same")
+	}
+}
+
+func TestAccessUnitFps(t *testing.T) {
+	reader := NewAccessUnitReader(NewParser(bytes.NewReader(testStream())), 30)
+	au, err := reader.ReadAccessUnit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if au.Duration != time.Second/30 {
+		t.Fatalf("expected %s, got %s", time.Second/30, au.Duration)
+	}
+
+	reader.SetFps(0) //unknown fps leaves the duration for the caller
+	au, err = reader.ReadAccessUnit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if au.Duration != 0 {
+		t.Fatalf("expected no duration, got %s", au.Duration)
+	}
+
+	reader.SetFps(60)
+	au, err = reader.ReadAccessUnit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if au.Duration != time.Second/60 {
+		t.Fatalf("expected %s, got %s", time.Second/60, au.Duration)
+	}
+}
@@ -0,0 +1,93 @@
+package h264
+
+import "fmt"
+
+// NALType is the nal_unit_type from the NAL header
+type NALType byte
+
+const (
+	NALTypeSlice     NALType = 1
+	NALTypeSliceA    NALType = 2
+	NALTypeSliceB    NALType = 3
+	NALTypeSliceC    NALType = 4
+	NALTypeIDR       NALType = 5
+	NALTypeSEI       NALType = 6
+	NALTypeSPS       NALType = 7
+	NALTypePPS       NALType = 8
+	NALTypeAUD       NALType = 9
+	NALTypeEndSeq    NALType = 10
+	NALTypeEndStrm   NALType = 11
+	NALTypeFiller    NALType = 12
+	NALTypeSPSExt    NALType = 13
+	NALTypePrefix    NALType = 14
+	NALTypeSubsetSPS NALType = 15
+)
+
+// StartCode is the 4 byte Annex-B start code written in front of every NAL unit this package outputs
+var StartCode = []byte{0, 0, 0, 1}
+
+func (t NALType) String() string {
+	switch t {
+	case NALTypeSlice:
+		return "slice"
+	case NALTypeSliceA, NALTypeSliceB, NALTypeSliceC:
+		return fmt.Sprintf("slice partition %c", 'A'+byte(t-NALTypeSliceA))
+	case NALTypeIDR:
+		return "idr"
+	case NALTypeSEI:
+		return "sei"
+	case NALTypeSPS:
+		return "sps"
+	case NALTypePPS:
+		return "pps"
+	case NALTypeAUD:
+		return "aud"
+	case NALTypeEndSeq:
+		return "end of sequence"
+	case NALTypeEndStrm:
+		return "end of stream"
+	case NALTypeFiller:
+		return "filler"
+	default:
+		return fmt.Sprintf("type %d", byte(t))
+	}
+}
+
+// IsVCL reports whether the NAL carries coded picture data
+func (t NALType) IsVCL() bool {
+	return t >= NALTypeSlice && t <= NALTypeIDR
+}
+
+// NALUnit is a single NAL unit without its start code
+type NALUnit struct {
+	Data []byte
+}
+
+func (n NALUnit) Type() NALType {
+	if len(n.Data) == 0 {
+		return 0
+	}
+	return NALType(n.Data[0] & 0x1f)
+}
+
+func (n NALUnit) RefIdc() byte {
+	if len(n.Data) == 0 {
+		return 0
+	}
+	return (n.Data[0] >> 5) & 0x03
+}
+
+// FirstSliceInPicture reports whether a VCL NAL has first_mb_in_slice of 0. The value is the first
+// exp-golomb code after the header, which is 0 only when its first bit is set.
+func (n NALUnit) FirstSliceInPicture() bool {
+	return n.Type().IsVCL() && len(n.Data) > 1 && n.Data[1]&0x80 != 0
+}
+
+// ProfileLevelID is the sdp profile-level-id an SPS describes: profile_idc, the constraint flags and
+// level_idc. False for anything but an SPS.
+func (n NALUnit) ProfileLevelID() (string, bool) {
+	if n.Type() != NALTypeSPS || len(n.Data) < 4 {
+		return "", false
+	}
+	return fmt.Sprintf("%02x%02x%02x", n.Data[1], n.Data[2], n.Data[3]), true
+}
@@ -0,0 +1,63 @@
+package h264
+
+import "testing"
+
+// NALs laid out the way libcamera-vid writes them with inline headers: nal_ref_idc 1 on parameter sets and
+// slices, slice payloads cut short. No capture can be made here, so these are built to match, not recorded.
+var (
+	testSPS      = []byte{0x27, 0x64, 0x00, 0x28, 0xac, 0x2b, 0x40, 0x28, 0x02, 0xdd, 0x35, 0x01, 0x0d, 0x01, 0xe2, 0x44, 0x54}
+	testPPS      = []byte{0x28, 0xee, 0x3c, 0xb0}
+	testIDR      = []byte{0x25, 0x88, 0x80, 0x40, 0x03, 0xff, 0xfe, 0x9c, 0x11}
+	testIDR2     = []byte{0x25, 0x40, 0x88, 0x41, 0x17, 0xc3} //second slice of the same picture, first_mb_in_slice 1
+	testSlice    = []byte{0x21, 0x9a, 0x02, 0x04, 0x7f, 0xe1}
+	testSlice2   = []byte{0x21, 0x9a, 0x00, 0x00, 0x03, 0x01, 0x55} //emulation prevention, 00 00 03 is not a start code
+	testSliceB   = []byte{0x21, 0x5c, 0x31, 0x8a}                   //second slice of a P picture
+	testSEI      = []byte{0x06, 0x05, 0x10, 0xdc, 0x45, 0xe9, 0xbd, 0x80}
+	testAUD      = []byte{0x09, 0xf0}
+	testEndOfSeq = []byte{0x0a}
+)
+
+func TestNALUnit(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		typ    NALType
+		refIdc byte
+		vcl    bool
+		first  bool
+		str    string
+	}{
+		{name: "sps", data: testSPS, typ: NALTypeSPS, refIdc: 1, str: "sps"},
+		{name: "pps", data: testPPS, typ: NALTypePPS, refIdc: 1, str: "pps"},
+		{name: "idr", data: testIDR, typ: NALTypeIDR, refIdc: 1, vcl: true, first: true, str: "idr"},
+		{name: "idr second slice", data: testIDR2, typ: NALTypeIDR, refIdc: 1, vcl: true, str: "idr"},
+		{name: "slice", data: testSlice, typ: NALTypeSlice, refIdc: 1, vcl: true, first: true, str: "slice"},
+		{name: "slice partition", data: []byte{0x44, 0x80}, typ: NALTypeSliceC, refIdc: 2, vcl: true, first: true, str: "slice partition C"},
+		{name: "sei", data: testSEI, typ: NALTypeSEI, str: "sei"},
+		{name: "aud", data: testAUD, typ: NALTypeAUD, str: "aud"},
+		{name: "header only slice", data: []byte{0x21}, typ: NALTypeSlice, refIdc: 1, vcl: true, str: "slice"},
+		{name: "unknown", data: []byte{0x74}, typ: 20, refIdc: 3, str: "type 20"},
+		{name: "empty", data: nil, typ: 0, str: "type 0"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			nal := NALUnit{Data: test.data}
+			if nal.Type() != test.typ {
+				t.Errorf("expected type %d, got %d", test.typ, nal.Type())
+			}
+			if nal.RefIdc() != test.refIdc {
+				t.Errorf("expected nal_ref_idc %d, got %d", test.refIdc, nal.RefIdc())
+			}
+			if nal.Type().IsVCL() != test.vcl {
+				t.Errorf("expected vcl %t", test.vcl)
+			}
+			if nal.FirstSliceInPicture() != test.first {
+				t.Errorf("expected first slice %t", test.first)
+			}
+			if nal.Type().String() != test.str {
+				t.Errorf("expected %q, got %q", test.str, nal.Type().String())
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package h264
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	readSize       = 32 * 1024
+	initialBufSize = 256 * 1024
+	MaxNALSize     = 16 * 1024 * 1024 //bigger than any frame we stream, stops a corrupt stream eating memory
+)
+
+var (
+	ErrNALTooLarge = errors.New("nal unit larger than max size")
+
+	shortStartCode = []byte{0, 0, 1}
+)
+
+// Parser splits an Annex-B byte stream into NAL units. Both 3 and 4 byte start codes are handled and the
+// buffer grows as needed, so NALs of any size up to MaxNALSize come out whole.
+type Parser struct {
+	reader io.Reader
+	buf    []byte
+	start  int  //first unconsumed byte in buf
+	scan   int  //where to continue looking for the next start code
+	synced bool //true once the first start code was found, data before it is dropped
+	err    error
+}
+
+func NewParser(reader io.Reader) *Parser {
+	return &Parser{
+		reader: reader,
+		buf:    make([]byte, 0, initialBufSize),
+	}
+}
+
+// ReadNAL returns the next NAL unit, the data is owned by the caller. io.EOF is returned once the reader is
+// done and the last NAL has been returned.
+func (p *Parser) ReadNAL() (NALUnit, error) {
+	for {
+		if !p.synced {
+			index := bytes.Index(p.buf[p.scan:], shortStartCode)
+			if index >= 0 {
+				p.start = p.scan + index + len(shortStartCode)
+				p.scan = p.start
+				p.synced = true
+			} else {
+				p.scan = max(p.start, len(p.buf)-len(shortStartCode)+1)
+				p.start = p.scan //nothing before the first start code is kept
+			}
+		}
+
+		if p.synced {
+			index := bytes.Index(p.buf[p.scan:], shortStartCode)
+			if index >= 0 {
+				end := p.scan + index
+				nal := p.take(p.start, end)
+				p.start = end + len(shortStartCode)
+				p.scan = p.start
+				if len(nal.Data) > 0 {
+					return nal, nil
+				}
+				continue //empty NAL between back to back start codes
+			}
+			p.scan = max(p.start, len(p.buf)-len(shortStartCode)+1)
+
+			if len(p.buf)-p.start > MaxNALSize {
+				return NALUnit{}, fmt.Errorf("%w: %d bytes", ErrNALTooLarge, len(p.buf)-p.start)
+			}
+		}
+
+		if p.err != nil {
+			if p.synced && p.start < len(p.buf) {
+				nal := p.take(p.start, len(p.buf))
+				p.start = len(p.buf)
+				p.scan = p.start
+				if len(nal.Data) > 0 {
+					return nal, nil
+				}
+			}
+			return NALUnit{}, p.err
+		}
+		p.fill()
+	}
+}
+
+// take copies a NAL out of the buffer, trailing zeros are dropped since they belong to a 4 byte start code
+// or trailing_zero_8bits, a NAL always ends with a non zero byte
+func (p *Parser) take(start, end int) NALUnit {
+	for end > start && p.buf[end-1] == 0 {
+		end--
+	}
+	data := make([]byte, end-start)
+	copy(data, p.buf[start:end])
+	return NALUnit{Data: data}
+}
+
+func (p *Parser) fill() {
+	if p.start > 0 && p.start >= len(p.buf)/2 { //shift down instead of growing when most of the buffer is consumed
+		n := copy(p.buf, p.buf[p.start:])
+		p.buf = p.buf[:n]
+		p.scan -= p.start
+		p.start = 0
+	}
+
+	if cap(p.buf)-len(p.buf) < readSize {
+		grown := make([]byte, len(p.buf), 2*cap(p.buf)+readSize)
+		copy(grown, p.buf)
+		p.buf = grown
+	}
+
+	n, err := p.reader.Read(p.buf[len(p.buf):cap(p.buf)])
+	p.buf = p.buf[:len(p.buf)+n]
+	if err != nil {
+		p.err = err
+	}
+}
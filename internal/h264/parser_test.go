@@ -0,0 +1,191 @@
+package h264
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+var shortCode = []byte{0, 0, 1}
+
+// annexB joins NALs, each after the start code in front of it
+func annexB(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+// testStream is one second of a libcamera-vid style stream at the default intra of 3 frames, headers
+// repeated before each IDR, 4 byte start codes throughout
+func testStream() []byte {
+	return annexB(
+		StartCode, testSPS, StartCode, testPPS, StartCode, testIDR,
+		StartCode, testSlice,
+		StartCode, testSlice2,
+		StartCode, testSPS, StartCode, testPPS, StartCode, testIDR,
+		StartCode, testSlice,
+	)
+}
+
+// splitReader returns the stream in the given chunk sizes, the rest in one read
+type splitReader struct {
+	data   []byte
+	chunks []int
+}
+
+func (r *splitReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	n := len(r.data)
+	if len(r.chunks) > 0 {
+		n = min(r.chunks[0], n)
+		r.chunks = r.chunks[1:]
+	}
+	n = copy(p, r.data[:n])
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func readNALs(t *testing.T, reader io.Reader) [][]byte {
+	t.Helper()
+	parser := NewParser(reader)
+	nals := make([][]byte, 0, 8)
+	for {
+		nal, err := parser.ReadNAL()
+		if err == io.EOF {
+			return nals
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		nals = append(nals, nal.Data)
+	}
+}
+
+func sameNALs(t *testing.T, got [][]byte, want [][]byte) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("expected %d nals, got %d: % x", len(want), len(got), got)
+	}
+	for i := range want {
+		if !bytes.Equal(got[i], want[i]) {
+			t.Fatalf("nal %d: expected % x, got % x", i, want[i], got[i])
+		}
+	}
+}
+
+func TestParser(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream []byte
+		want   [][]byte
+	}{
+		{
+			name:   "4 byte start codes",
+			stream: testStream(),
+			want:   [][]byte{testSPS, testPPS, testIDR, testSlice, testSlice2, testSPS, testPPS, testIDR, testSlice},
+		},
+		{
+			name:   "3 byte start codes",
+			stream: annexB(shortCode, testSPS, shortCode, testPPS, shortCode, testIDR, shortCode, testSlice),
+			want:   [][]byte{testSPS, testPPS, testIDR, testSlice},
+		},
+		{
+			name:   "mixed start codes like ffmpeg writes",
+			stream: annexB(StartCode, testAUD, StartCode, testSPS, shortCode, testPPS, shortCode, testIDR, StartCode, testAUD, shortCode, testSlice),
+			want:   [][]byte{testAUD, testSPS, testPPS, testIDR, testAUD, testSlice},
+		},
+		{
+			name:   "data before the first start code is dropped",
+			stream: annexB([]byte{0x65, 0x12, 0x00}, StartCode, testSPS, StartCode, testIDR),
+			want:   [][]byte{testSPS, testIDR},
+		},
+		{
+			name:   "trailing zero bytes",
+			stream: annexB(StartCode, testSPS, []byte{0, 0}, StartCode, testIDR, []byte{0, 0, 0}),
+			want:   [][]byte{testSPS, testIDR},
+		},
+		{
+			name:   "back to back start codes",
+			stream: annexB(StartCode, shortCode, testSPS, StartCode, StartCode, testIDR),
+			want:   [][]byte{testSPS, testIDR},
+		},
+		{
+			name:   "emulation prevention is not a start code",
+			stream: annexB(StartCode, testSlice2, StartCode, testSlice2),
+			want:   [][]byte{testSlice2, testSlice2},
+		},
+		{
+			name:   "no start code",
+			stream: []byte{0x27, 0x64, 0x00, 0x28},
+			want:   [][]byte{},
+		},
+		{
+			name:   "empty",
+			stream: nil,
+			want:   [][]byte{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sameNALs(t, readNALs(t, bytes.NewReader(test.stream)), test.want)
+			sameNALs(t, readNALs(t, iotest.OneByteReader(bytes.NewReader(test.stream))), test.want)
+		})
+	}
+}
+
+// TestParserSplitReads splits the stream after every byte, which puts the split inside each start code
+// and each trailing zero
+func TestParserSplitReads(t *testing.T) {
+	stream := annexB(StartCode, testSPS, shortCode, testPPS, StartCode, testIDR, shortCode, testSlice, []byte{0})
+	want := [][]byte{testSPS, testPPS, testIDR, testSlice}
+
+	for split := 1; split < len(stream); split++ {
+		sameNALs(t, readNALs(t, &splitReader{data: stream, chunks: []int{split}}), want)
+		for second := 1; second < 4 && split+second < len(stream); second++ { //a start code spread over three reads
+			sameNALs(t, readNALs(t, &splitReader{data: stream, chunks: []int{split, second}}), want)
+		}
+	}
+}
+
+func TestParserLargeNAL(t *testing.T) {
+	frame := bytes.Repeat([]byte{0x9a, 0x41}, 2*readSize) //bigger than a read and the first buffer grows
+	frame[0] = 0x25
+	stream := annexB(StartCode, testSPS, StartCode, frame, StartCode, testSlice)
+
+	sameNALs(t, readNALs(t, bytes.NewReader(stream)), [][]byte{testSPS, frame, testSlice})
+	sameNALs(t, readNALs(t, &splitReader{data: stream, chunks: []int{len(testSPS) + 6, readSize - 1, 7}}), [][]byte{testSPS, frame, testSlice})
+}
+
+func TestParserTooLarge(t *testing.T) {
+	if testing.Short() {
+		t.Skip("reads over 16MB")
+	}
+	stream := io.MultiReader(bytes.NewReader(StartCode), bytes.NewReader(bytes.Repeat([]byte{0x25}, MaxNALSize+2*readSize)))
+	_, err := NewParser(stream).ReadNAL()
+	if !errors.Is(err, ErrNALTooLarge) {
+		t.Fatalf("expected %s, got %v", ErrNALTooLarge, err)
+	}
+}
+
+func TestParserReadError(t *testing.T) {
+	failed := errors.New("camera went away")
+	parser := NewParser(io.MultiReader(bytes.NewReader(annexB(StartCode, testSPS, StartCode, testPPS)), iotest.ErrReader(failed)))
+
+	want := [][]byte{testSPS, testPPS} //the last nal is returned before the error
+	for _, data := range want {
+		nal, err := parser.ReadNAL()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !bytes.Equal(nal.Data, data) {
+			t.Fatalf("expected % x, got % x", data, nal.Data)
+		}
+	}
+	_, err := parser.ReadNAL()
+	if !errors.Is(err, failed) {
+		t.Fatalf("expected %s, got %v", failed, err)
+	}
+}
@@ -0,0 +1,135 @@
+package hud
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Speshl/gorrc_client/internal/models"
+	"github.com/prometheus/procfs"
+)
+
+// Normalized is a gauge for a -1.0 to 1.0 value like esc or steer
+func Normalized(value float64) *models.HudGauge {
+	return &models.HudGauge{
+		Value: value,
+		Unit:  models.UnitNormalized,
+		Min:   -1.0,
+		Max:   1.0,
+	}
+}
+
+// Named is a vehicle specific gauge for Hud.Gauges
+func Named(name string, gauge *models.HudGauge) models.HudGauge {
+	named := *gauge
+	named.Name = name
+	return named
+}
+
+// Link is the wifi stats every seat shows
+func Link(netInfo procfs.NetDevLine) *models.HudLink {
+	return &models.HudLink{
+		RxPackets: netInfo.RxPackets,
+		RxErrors:  netInfo.RxErrors,
+		RxDropped: netInfo.RxDropped,
+		TxPackets: netInfo.TxPackets,
+		TxErrors:  netInfo.TxErrors,
+		TxDropped: netInfo.TxDropped,
+	}
+}
+
+// Latency is a ms gauge that warns past the given limit, no warning when the limit is 0
+func Latency(ms int64, limit int64) *models.HudGauge {
+	gauge := &models.HudGauge{
+		Value: float64(ms),
+		Unit:  models.UnitMs,
+		Max:   float64(limit),
+	}
+	if limit > 0 && ms > limit {
+		gauge.Severity = models.SeverityWarning
+	}
+	return gauge
+}
+
+// Render writes the text lines older clients show, followed by any lines already set on the hud
+func Render(h models.Hud) []string {
+	lines := make([]string, 0, 4+len(h.Alerts)+len(h.Lines))
+
+	link := make([]string, 0, 11)
+	if h.Link != nil {
+		link = append(link,
+			fmt.Sprintf("RxPkt:%d", h.Link.RxPackets),
+			fmt.Sprintf("RxErr:%d", h.Link.RxErrors),
+			fmt.Sprintf("RxDrop: %d", h.Link.RxDropped),
+			fmt.Sprintf("TxPkt:%d", h.Link.TxPackets),
+			fmt.Sprintf("TxErr:%d", h.Link.TxErrors),
+			fmt.Sprintf("TxDrop: %d", h.Link.TxDropped),
+		)
+		if h.Link.Ping != nil {
+			link = append(link, fmt.Sprintf("Ping:%.0fms", h.Link.Ping.Value))
+		}
+		if h.Link.Input != nil {
+			link = append(link, fmt.Sprintf("Input:%.0fms", h.Link.Input.Value))
+		} else if h.Link.Ping != nil {
+			link = append(link, "Input:--")
+		}
+	}
+	if h.Camera != "" {
+		link = append(link, fmt.Sprintf("Cam:%s", h.Camera))
+	}
+	if h.Video != "" {
+		link = append(link, fmt.Sprintf("Video:%s", h.Video))
+	}
+	if h.Recording {
+		link = append(link, "REC")
+	}
+	lines = appendLine(lines, link)
+
+	drive := make([]string, 0, 7)
+	drive = appendGauge(drive, "Esc", h.Esc)
+	if h.Gear != nil {
+		drive = append(drive, fmt.Sprintf("Gear:%s", h.Gear.Name))
+		if h.Gear.Mode != "" {
+			drive = append(drive, fmt.Sprintf("Type:%s", h.Gear.Mode))
+		}
+	}
+	drive = appendGauge(drive, "Steer", h.Steer)
+	drive = appendGauge(drive, "Trim", h.Trim)
+	drive = appendGauge(drive, "Pan", h.Pan)
+	drive = appendGauge(drive, "Tilt", h.Tilt)
+	lines = appendLine(lines, drive)
+
+	gauges := make([]string, 0, 1+len(h.Gauges))
+	gauges = appendGauge(gauges, "Battery", h.Battery)
+	for i := range h.Gauges {
+		gauges = appendGauge(gauges, h.Gauges[i].Name, &h.Gauges[i])
+	}
+	lines = appendLine(lines, gauges)
+
+	for _, alert := range h.Alerts {
+		lines = append(lines, fmt.Sprintf("%s: %s", strings.ToUpper(alert.Source), alert.Text))
+	}
+	return append(lines, h.Lines...)
+}
+
+func appendLine(lines []string, parts []string) []string {
+	if len(parts) == 0 {
+		return lines
+	}
+	return append(lines, strings.Join(parts, " | "))
+}
+
+func appendGauge(parts []string, name string, gauge *models.HudGauge) []string {
+	if gauge == nil {
+		return parts
+	}
+	switch gauge.Unit {
+	case models.UnitNormalized, "":
+		return append(parts, fmt.Sprintf("%s:%.2f", name, gauge.Value))
+	case models.UnitMs:
+		return append(parts, fmt.Sprintf("%s:%.0f%s", name, gauge.Value, gauge.Unit))
+	case models.UnitVolts:
+		return append(parts, fmt.Sprintf("%s:%.2f%s", name, gauge.Value, gauge.Unit))
+	default:
+		return append(parts, fmt.Sprintf("%s:%.1f%s", name, gauge.Value, gauge.Unit))
+	}
+}
@@ -12,6 +12,7 @@ import (
 type Mic struct {
 	AudioTrack *webrtc.TrackLocalStaticSample
 	config     config.MicConfig
+	pipeline   *gst.SendPipeline
 }
 
 func NewMic(cfg config.MicConfig) (*Mic, error) {
@@ -32,9 +33,18 @@ func NewMic(cfg config.MicConfig) (*Mic, error) {
 func (c *Mic) Start() {
 	if c.config.Enabled {
 		log.Println("creating mic pipeline")
-		gst.CreateMicSendPipeline([]*webrtc.TrackLocalStaticSample{c.AudioTrack}, c.config.Device, c.config.Volume).Start()
+		c.pipeline = gst.CreateMicSendPipeline([]*webrtc.TrackLocalStaticSample{c.AudioTrack}, c.config.Device, c.config.Volume)
+		c.pipeline.Start()
 	} else {
 		log.Println("mic disabled")
 	}
 
 }
+
+func (c *Mic) Stop() {
+	if c.pipeline != nil {
+		log.Println("stopping mic pipeline")
+		c.pipeline.Stop()
+		c.pipeline = nil
+	}
+}
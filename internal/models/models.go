@@ -13,9 +13,26 @@ type ConnectReq struct {
 	SeatCount int    `json:"seat_count"`
 }
 
+type DisconnectReq struct {
+	Key    string `json:"key"`
+	Reason string `json:"reason"`
+}
+
 type ConnectResp struct {
 	Car   Car
 	Track Track
+	ICE   *ICEConfig `json:"ice,omitempty"` //replaces the car's ice config for new peer connections when set
+}
+
+type ICEConfig struct {
+	Servers         []ICEServer `json:"servers"`
+	TransportPolicy string      `json:"transport_policy"` //all or relay
+}
+
+type ICEServer struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username"`
+	Credential string   `json:"credential"`
 }
 type Car struct {
 	Id        uuid.UUID `json:"id"`
@@ -43,6 +60,30 @@ type Offer struct {
 	CarShortName string                    `json:"car_name"`
 	SeatNumber   int                       `json:"seat_number"`
 	UserId       uuid.UUID                 `json:"user_id"`
+	Priority     bool                      `json:"priority"` //set by the server to let this user take the seat from whoever has it
+}
+
+// SeatBusy is sent when an offer is for a seat someone else has
+type SeatBusy struct {
+	SeatNumber int       `json:"seat_number"`
+	UserId     uuid.UUID `json:"user_id"`
+	Occupant   uuid.UUID `json:"occupant"`
+	Queued     bool      `json:"queued"`
+	Position   int       `json:"position"` //place in the queue when queued, starting at 1
+}
+
+// SeatTaken is sent when a priority offer evicts the user in a seat
+type SeatTaken struct {
+	SeatNumber     int       `json:"seat_number"`
+	UserId         uuid.UUID `json:"user_id"`
+	PreviousUserId uuid.UUID `json:"previous_user_id"`
+}
+
+// OfferError is sent instead of an answer when an offer can not be used
+type OfferError struct {
+	SeatNumber int       `json:"seat_number"`
+	UserId     uuid.UUID `json:"user_id"`
+	Error      string    `json:"error"`
 }
 
 type Answer struct {
@@ -54,16 +95,103 @@ type ControlState struct {
 	Axes      []float64 `json:"axes"`
 	BitButton uint32    `json:"bit_buttons"`
 	TimeStamp int64     `json:"time_stamp"`
+	Seq       uint32    `json:"seq"` //counts up per message, 0 from clients that do not send it
 	Buttons   []bool
 }
 
+const HudVersion = 1 //bumped when a hud field changes meaning, clients without support only read lines
+
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+
+	UnitNormalized = "norm" //-1.0 to 1.0, or 0.0 to 1.0 for one way inputs
+	UnitMs         = "ms"
+	UnitVolts      = "V"
+	UnitAmps       = "A"
+	UnitPercent    = "%"
+)
+
+// Hud is sent on the hud data channel. Clients that know the version draw from the typed fields, older
+// ones only read Lines, which the car renders from the same values before sending.
 type Hud struct {
-	Lines []string `json:"lines"`
+	Version int `json:"version"`
+
+	Esc   *HudGauge `json:"esc,omitempty"`
+	Steer *HudGauge `json:"steer,omitempty"`
+	Trim  *HudGauge `json:"trim,omitempty"`
+	Pan   *HudGauge `json:"pan,omitempty"`
+	Tilt  *HudGauge `json:"tilt,omitempty"`
+	Gear  *HudGear  `json:"gear,omitempty"`
+
+	Battery *HudGauge  `json:"battery,omitempty"`
+	Gauges  []HudGauge `json:"gauges,omitempty"` //vehicle specific values, like a turret
+
+	Link      *HudLink `json:"link,omitempty"`
+	Camera    string   `json:"camera,omitempty"`
+	Video     string   `json:"video,omitempty"`
+	Recording bool     `json:"recording"`
+
+	Alerts []HudAlert `json:"alerts,omitempty"`
+	Lines  []string   `json:"lines"`
+}
+
+type HudGauge struct {
+	Name     string  `json:"name,omitempty"` //only set in Gauges, the typed fields are named by their key
+	Value    float64 `json:"value"`
+	Unit     string  `json:"unit,omitempty"`
+	Min      float64 `json:"min"`
+	Max      float64 `json:"max"`
+	Severity string  `json:"severity,omitempty"`
+}
+
+type HudGear struct {
+	Index int    `json:"index"`
+	Name  string `json:"name"`
+	Mode  string `json:"mode,omitempty"` //transmission type on vehicles that have more than one
+}
+
+type HudLink struct {
+	RxPackets uint64    `json:"rx_packets"`
+	RxErrors  uint64    `json:"rx_errors"`
+	RxDropped uint64    `json:"rx_dropped"`
+	TxPackets uint64    `json:"tx_packets"`
+	TxErrors  uint64    `json:"tx_errors"`
+	TxDropped uint64    `json:"tx_dropped"`
+	Ping      *HudGauge `json:"ping,omitempty"`  //round trip to the browser
+	Input     *HudGauge `json:"input,omitempty"` //browser to car, only once the clocks are synced
+}
+
+type HudAlert struct {
+	Source   string `json:"source"` //failsafe, hold, cmd
+	Severity string `json:"severity"`
+	Text     string `json:"text"`
+}
+
+type CameraSelect struct {
+	Index int `json:"index"`
+}
+
+type CameraState struct {
+	Primary string   `json:"primary"`
+	Index   int      `json:"index"`
+	Tracks  []string `json:"tracks"`
+}
+
+// HealthCheck is sent with car_healthy and echoed back by the server in car_healthy_reply
+type HealthCheck struct {
+	Seq       int64 `json:"seq"`
+	TimeStamp int64 `json:"time_stamp"` //unix ms when the car sent it
 }
 
+// Ping is echoed back by the side that did not send it, the echo fills in when it got the ping and when it
+// replied on its own clock so the sender can work out the clock offset
 type Ping struct {
-	Source    string `json:"source"`
-	TimeStamp int64  `json:"time_stamp"`
+	Source     string `json:"source"`
+	TimeStamp  int64  `json:"time_stamp"`            //unix ms when the source sent it
+	ReceivedAt int64  `json:"received_at,omitempty"` //unix ms when the echoing side got it
+	RepliedAt  int64  `json:"replied_at,omitempty"`  //unix ms when the echoing side replied
 }
 
 type Seat struct {
@@ -0,0 +1,280 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Speshl/gorrc_client/internal/h264"
+)
+
+// matroska element ids used by the writer
+const (
+	idEBML               = 0x1A45DFA3
+	idEBMLVersion        = 0x4286
+	idEBMLReadVersion    = 0x42F7
+	idEBMLMaxIDLength    = 0x42F2
+	idEBMLMaxSizeLength  = 0x42F3
+	idDocType            = 0x4282
+	idDocTypeVersion     = 0x4287
+	idDocTypeReadVersion = 0x4285
+	idSegment            = 0x18538067
+	idInfo               = 0x1549A966
+	idTimecodeScale      = 0x2AD7B1
+	idMuxingApp          = 0x4D80
+	idWritingApp         = 0x5741
+	idDateUTC            = 0x4461
+	idTracks             = 0x1654AE6B
+	idTrackEntry         = 0xAE
+	idTrackNumber        = 0xD7
+	idTrackUID           = 0x73C5
+	idTrackType          = 0x83
+	idFlagLacing         = 0x9C
+	idCodecID            = 0x86
+	idCodecPrivate       = 0x63A2
+	idVideo              = 0xE0
+	idPixelWidth         = 0xB0
+	idPixelHeight        = 0xBA
+	idCluster            = 0x1F43B675
+	idTimecode           = 0xE7
+	idSimpleBlock        = 0xA3
+
+	unknownSize      = 0x01FFFFFFFFFFFFFF //segment size is not known while recording live
+	maxClusterLength = 30 * time.Second   //block timecodes are int16 milliseconds relative to the cluster
+)
+
+var matroskaEpoch = time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// mkvWriter writes a single H.264 track to a matroska file as frames arrive. Clusters are buffered in
+// memory and start on every keyframe, so a file cut short by a crash is only missing the last cluster.
+type mkvWriter struct {
+	file         *os.File
+	path         string
+	start        time.Time
+	cluster      bytes.Buffer
+	clusterStart time.Duration
+	size         int64
+}
+
+func newMKVWriter(path string, start time.Time, width, height int, sps, pps []byte) (*mkvWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating recording file: %w", err)
+	}
+
+	header := ebml{}
+	header.master(idEBML, func(e *ebml) {
+		e.uint(idEBMLVersion, 1)
+		e.uint(idEBMLReadVersion, 1)
+		e.uint(idEBMLMaxIDLength, 4)
+		e.uint(idEBMLMaxSizeLength, 8)
+		e.string(idDocType, "matroska")
+		e.uint(idDocTypeVersion, 4)
+		e.uint(idDocTypeReadVersion, 2)
+	})
+
+	header.id(idSegment)
+	header.buf.Write(binary.BigEndian.AppendUint64(nil, unknownSize))
+
+	header.master(idInfo, func(e *ebml) {
+		e.uint(idTimecodeScale, uint64(time.Millisecond)) //timecodes are in milliseconds
+		e.string(idMuxingApp, "gorrc_client")
+		e.string(idWritingApp, "gorrc_client")
+		e.int(idDateUTC, start.Sub(matroskaEpoch).Nanoseconds())
+	})
+
+	header.master(idTracks, func(e *ebml) {
+		e.master(idTrackEntry, func(e *ebml) {
+			e.uint(idTrackNumber, 1)
+			e.uint(idTrackUID, 1)
+			e.uint(idTrackType, 1) //video
+			e.uint(idFlagLacing, 0)
+			e.string(idCodecID, "V_MPEG4/ISO/AVC")
+			e.binary(idCodecPrivate, avcDecoderConfig(sps, pps))
+			e.master(idVideo, func(e *ebml) {
+				e.uint(idPixelWidth, uint64(width))
+				e.uint(idPixelHeight, uint64(height))
+			})
+		})
+	})
+
+	n, err := file.Write(header.buf.Bytes())
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed writing recording header: %w", err)
+	}
+
+	return &mkvWriter{
+		file:  file,
+		path:  path,
+		start: start,
+		size:  int64(n),
+	}, nil
+}
+
+// WriteFrame adds an access unit received at the given time
+func (w *mkvWriter) WriteFrame(at time.Time, au h264.AccessUnit) error {
+	timecode := at.Sub(w.start).Truncate(time.Millisecond)
+	if timecode < 0 {
+		timecode = 0
+	}
+
+	keyframe := au.Keyframe()
+	if w.cluster.Len() == 0 || keyframe || timecode-w.clusterStart >= maxClusterLength {
+		err := w.flushCluster()
+		if err != nil {
+			return err
+		}
+		w.clusterStart = timecode
+		cluster := ebml{buf: &w.cluster}
+		cluster.uint(idTimecode, uint64(timecode.Milliseconds()))
+	}
+
+	frame := avcFrame(au)
+	block := make([]byte, 0, 4+len(frame))
+	block = append(block, 0x81) //track number 1 as a vint
+	block = binary.BigEndian.AppendUint16(block, uint16(int16((timecode - w.clusterStart).Milliseconds())))
+	if keyframe {
+		block = append(block, 0x80)
+	} else {
+		block = append(block, 0x00)
+	}
+	block = append(block, frame...)
+
+	cluster := ebml{buf: &w.cluster}
+	cluster.binary(idSimpleBlock, block)
+	return nil
+}
+
+func (w *mkvWriter) flushCluster() error {
+	if w.cluster.Len() == 0 {
+		return nil
+	}
+
+	out := ebml{}
+	out.id(idCluster)
+	out.size(uint64(w.cluster.Len()))
+	out.buf.Write(w.cluster.Bytes())
+	w.cluster.Reset()
+
+	n, err := w.file.Write(out.buf.Bytes())
+	w.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed writing recording cluster: %w", err)
+	}
+	return nil
+}
+
+// Size is the number of bytes written to disk so far
+func (w *mkvWriter) Size() int64 {
+	return w.size
+}
+
+func (w *mkvWriter) Close() error {
+	err := w.flushCluster()
+	closeErr := w.file.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// avcDecoderConfig builds the AVCDecoderConfigurationRecord matroska expects as codec private data
+func avcDecoderConfig(sps, pps []byte) []byte {
+	config := make([]byte, 0, 11+len(sps)+len(pps))
+	config = append(config, 1, sps[1], sps[2], sps[3], 0xFF, 0xE1) //4 byte nal lengths, 1 sps
+	config = binary.BigEndian.AppendUint16(config, uint16(len(sps)))
+	config = append(config, sps...)
+	config = append(config, 1) //1 pps
+	config = binary.BigEndian.AppendUint16(config, uint16(len(pps)))
+	config = append(config, pps...)
+	return config
+}
+
+// avcFrame converts an access unit to length prefixed NAL units, parameter sets are in the codec private data
+func avcFrame(au h264.AccessUnit) []byte {
+	size := 0
+	for _, nal := range au.NALs {
+		size += 4 + len(nal.Data)
+	}
+
+	frame := make([]byte, 0, size)
+	for _, nal := range au.NALs {
+		switch nal.Type() {
+		case h264.NALTypeSPS, h264.NALTypePPS, h264.NALTypeAUD:
+			continue
+		}
+		frame = binary.BigEndian.AppendUint32(frame, uint32(len(nal.Data)))
+		frame = append(frame, nal.Data...)
+	}
+	return frame
+}
+
+// ebml builds matroska elements into a buffer
+type ebml struct {
+	buf *bytes.Buffer
+}
+
+func (e *ebml) init() {
+	if e.buf == nil {
+		e.buf = &bytes.Buffer{}
+	}
+}
+
+func (e *ebml) id(id uint32) {
+	e.init()
+	switch {
+	case id > 0xFFFFFF:
+		e.buf.Write([]byte{byte(id >> 24), byte(id >> 16), byte(id >> 8), byte(id)})
+	case id > 0xFFFF:
+		e.buf.Write([]byte{byte(id >> 16), byte(id >> 8), byte(id)})
+	case id > 0xFF:
+		e.buf.Write([]byte{byte(id >> 8), byte(id)})
+	default:
+		e.buf.WriteByte(byte(id))
+	}
+}
+
+// size writes a data size as a variable length integer using the fewest bytes
+func (e *ebml) size(size uint64) {
+	e.init()
+	length := 1
+	for length < 8 && size >= (1<<(7*length))-1 {
+		length++
+	}
+	marked := size | 1<<(7*length)
+	for i := length - 1; i >= 0; i-- {
+		e.buf.WriteByte(byte(marked >> (8 * i)))
+	}
+}
+
+func (e *ebml) binary(id uint32, data []byte) {
+	e.id(id)
+	e.size(uint64(len(data)))
+	e.buf.Write(data)
+}
+
+func (e *ebml) string(id uint32, value string) {
+	e.binary(id, []byte(value))
+}
+
+func (e *ebml) uint(id uint32, value uint64) {
+	data := binary.BigEndian.AppendUint64(nil, value)
+	for len(data) > 1 && data[0] == 0 {
+		data = data[1:]
+	}
+	e.binary(id, data)
+}
+
+func (e *ebml) int(id uint32, value int64) {
+	e.binary(id, binary.BigEndian.AppendUint64(nil, uint64(value)))
+}
+
+func (e *ebml) master(id uint32, children func(*ebml)) {
+	child := ebml{}
+	child.init()
+	children(&child)
+	e.binary(id, child.buf.Bytes())
+}
@@ -0,0 +1,508 @@
+package recorder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Speshl/gorrc_client/internal/config"
+	"github.com/Speshl/gorrc_client/internal/h264"
+	"github.com/Speshl/gorrc_client/internal/models"
+	"github.com/Speshl/gorrc_client/internal/vehicle"
+)
+
+const (
+	EntryControl = "control"
+	EntryCommand = "command"
+	EntrySegment = "segment"
+
+	sessionTimeFormat = "20060102_150405"
+	videoExtension    = ".mkv"
+	telemetryName     = "telemetry"
+	telemetryExt      = ".jsonl"
+	queueSize         = 512 //a few seconds of frames and commands, past this the sd card is not keeping up
+)
+
+var ErrDisabled = errors.New("recording is disabled")
+
+// Entry is one line of the telemetry log. Times are wall clock so they line up with the DateUTC of the
+// video segments listed in segment entries.
+type Entry struct {
+	Time    time.Time              `json:"time"`
+	Type    string                 `json:"type"`
+	Seat    int                    `json:"seat,omitempty"`
+	Control *models.ControlState   `json:"control,omitempty"`
+	Command *vehicle.DriverCommand `json:"command,omitempty"`
+	Track   string                 `json:"track,omitempty"`
+	File    string                 `json:"file,omitempty"`
+}
+
+// Recorder writes camera frames to segmented mkv files and control input and driver commands to a json
+// lines telemetry log in the same directory. The oldest files are removed to keep the directory under
+// the configured size. Callers only queue frames and entries, a writer goroutine per session does the file
+// I/O so a slow sd card never holds up the camera or the driver.
+type Recorder struct {
+	cfg config.RecorderConfig
+
+	lock      sync.RWMutex //write locked to start and stop, read locked to queue
+	recording bool
+	session   *session
+	lastName  string //session names are to the second, restarts within it are numbered
+	restarts  int
+	dropped   atomic.Int64 //frames and entries dropped because the queue was full
+
+	retentionLock sync.Mutex
+}
+
+// session is one recording, from start to stop. Its files are only touched by its writer goroutine.
+type session struct {
+	recorder *Recorder
+	name     string
+	queue    chan item
+	previous *session      //still draining, the new session waits for it before writing
+	done     chan struct{} //closed once every queued item is written and the files are closed
+	err      error         //closing errors, read after done
+
+	lock      sync.Mutex //held while the writer changes files, openFiles reads them
+	telemetry *telemetryWriter
+	tracks    map[string]*trackRecorder
+}
+
+// item is a queued frame or telemetry entry
+type item struct {
+	at      time.Time
+	trackID string
+	width   int
+	height  int
+	au      h264.AccessUnit
+	entry   *Entry
+}
+
+type telemetryWriter struct {
+	file    *os.File
+	encoder *json.Encoder
+	path    string
+	start   time.Time
+}
+
+type trackRecorder struct {
+	id     string
+	sps    []byte
+	pps    []byte
+	width  int
+	height int
+	seq    int
+	writer *mkvWriter
+}
+
+func NewRecorder(cfg config.RecorderConfig) *Recorder {
+	return &Recorder{
+		cfg: cfg,
+	}
+}
+
+func (r *Recorder) Recording() bool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.recording
+}
+
+// Start begins a new recording session
+func (r *Recorder) Start() error {
+	if !r.cfg.Enabled {
+		return ErrDisabled
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.start()
+}
+
+// Stop ends the session and waits for the queued frames and telemetry to be written, anything received
+// after stopping is dropped
+func (r *Recorder) Stop() error {
+	r.lock.Lock()
+	s := r.stop()
+	r.lock.Unlock()
+	return s.wait()
+}
+
+// Toggle starts recording when stopped and stops it when running, a stopped session finishes writing in
+// the background
+func (r *Recorder) Toggle() error {
+	if !r.cfg.Enabled {
+		return ErrDisabled
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if !r.recording {
+		return r.start()
+	}
+	s := r.stop()
+	go func() {
+		err := s.wait()
+		if err != nil {
+			log.Printf("error: failed closing recording %s: %s\n", s.name, err.Error())
+		}
+	}()
+	return nil
+}
+
+// start needs the write lock
+func (r *Recorder) start() error {
+	if r.recording {
+		return nil
+	}
+
+	err := os.MkdirAll(r.cfg.Dir, 0755)
+	if err != nil {
+		return fmt.Errorf("failed creating recording directory: %w", err)
+	}
+
+	name := time.Now().Format(sessionTimeFormat)
+	if name == r.lastName { //restarted within a second, keep the earlier files
+		r.restarts++
+		name = fmt.Sprintf("%s.%d", name, r.restarts)
+	} else {
+		r.lastName = name
+		r.restarts = 0
+	}
+
+	s := &session{
+		recorder: r,
+		name:     name,
+		queue:    make(chan item, queueSize),
+		previous: r.session,
+		done:     make(chan struct{}),
+		tracks:   make(map[string]*trackRecorder, config.MaxSupportedCams),
+	}
+	err = s.rotateTelemetry(time.Now())
+	if err != nil {
+		return err
+	}
+
+	r.session = s
+	r.recording = true
+	log.Printf("recording started: %s\n", s.name)
+	go s.write()
+	go r.enforceRetention()
+	return nil
+}
+
+// stop needs the write lock, the returned session is nil when not recording
+func (r *Recorder) stop() *session {
+	if !r.recording {
+		return nil
+	}
+	r.recording = false
+	close(r.session.queue)
+	log.Printf("recording stopped: %s\n", r.session.name)
+	return r.session
+}
+
+// wait blocks until the session's queue is written and its files are closed
+func (s *session) wait() error {
+	if s == nil {
+		return nil
+	}
+	<-s.done
+	return s.err
+}
+
+// queue hands an item to the writer, dropping it when the writer has fallen behind
+func (r *Recorder) queue(i item) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if !r.recording {
+		return
+	}
+
+	select {
+	case r.session.queue <- i:
+	default:
+		r.dropped.Add(1)
+	}
+}
+
+// WriteFrame records an access unit from a camera, a new segment starts on the first keyframe after the
+// segment length passes or the resolution changes
+func (r *Recorder) WriteFrame(trackID string, width, height int, au h264.AccessUnit) {
+	r.queue(item{at: time.Now(), trackID: trackID, width: width, height: height, au: au})
+}
+
+// RecordControl logs a control state received from a seat
+func (r *Recorder) RecordControl(seat int, state models.ControlState) {
+	r.queue(item{entry: &Entry{Time: time.Now(), Type: EntryControl, Seat: seat, Control: &state}})
+}
+
+// RecordCommand logs a command applied to the driver
+func (r *Recorder) RecordCommand(cmd vehicle.DriverCommand) {
+	r.queue(item{entry: &Entry{Time: time.Now(), Type: EntryCommand, Command: &cmd}})
+}
+
+// write drains the queue until the session stops, then closes its files
+func (s *session) write() {
+	defer close(s.done)
+	s.lock.Lock()
+	previous := s.previous
+	s.lock.Unlock()
+	if previous != nil {
+		<-previous.done
+		s.lock.Lock()
+		s.previous = nil
+		s.lock.Unlock()
+	}
+
+	for i := range s.queue {
+		s.lock.Lock()
+		if i.entry != nil {
+			s.writeEntry(*i.entry)
+		} else {
+			s.writeFrame(i)
+		}
+		s.lock.Unlock()
+
+		dropped := s.recorder.dropped.Swap(0)
+		if dropped > 0 {
+			log.Printf("warning: recording fell behind, dropped %d frames and entries\n", dropped)
+		}
+	}
+
+	s.lock.Lock()
+	s.err = s.close()
+	s.lock.Unlock()
+	go s.recorder.enforceRetention()
+}
+
+func (s *session) close() error {
+	var errs []error
+	for _, track := range s.tracks {
+		if track.writer != nil {
+			errs = append(errs, track.writer.Close())
+			track.writer = nil
+		}
+	}
+	if s.telemetry != nil {
+		errs = append(errs, s.telemetry.file.Close())
+		s.telemetry = nil
+	}
+	return errors.Join(errs...)
+}
+
+func (s *session) writeFrame(i item) {
+	track, ok := s.tracks[i.trackID]
+	if !ok {
+		track = &trackRecorder{id: i.trackID}
+		s.tracks[i.trackID] = track
+	}
+
+	for _, nal := range i.au.NALs {
+		switch nal.Type() {
+		case h264.NALTypeSPS:
+			track.sps = append(track.sps[:0], nal.Data...)
+		case h264.NALTypePPS:
+			track.pps = append(track.pps[:0], nal.Data...)
+		}
+	}
+
+	if i.au.Keyframe() && len(track.sps) > 3 && len(track.pps) > 0 {
+		if track.writer == nil || i.at.Sub(track.writer.start) >= s.recorder.segmentLength() || i.width != track.width || i.height != track.height {
+			s.startSegment(track, i.at, i.width, i.height)
+		}
+	}
+
+	if track.writer == nil {
+		return //waiting for a keyframe
+	}
+
+	err := track.writer.WriteFrame(i.at, i.au)
+	if err != nil {
+		log.Printf("error: failed recording frame for %s: %s\n", i.trackID, err.Error())
+		track.writer.Close()
+		track.writer = nil
+	}
+}
+
+func (s *session) startSegment(track *trackRecorder, now time.Time, width, height int) {
+	if track.writer != nil {
+		err := track.writer.Close()
+		if err != nil {
+			log.Printf("error: failed closing recording segment %s: %s\n", track.writer.path, err.Error())
+		}
+		track.writer = nil
+		go s.recorder.enforceRetention()
+	}
+
+	track.seq++
+	path := filepath.Join(s.recorder.cfg.Dir, fmt.Sprintf("%s_%s_%03d%s", s.name, track.id, track.seq, videoExtension))
+	writer, err := newMKVWriter(path, now, width, height, track.sps, track.pps)
+	if err != nil {
+		log.Printf("error: failed starting recording segment for %s: %s\n", track.id, err.Error())
+		return
+	}
+	track.writer = writer
+	track.width = width
+	track.height = height
+
+	s.writeEntry(Entry{Time: now, Type: EntrySegment, Track: track.id, File: filepath.Base(path)})
+}
+
+func (s *session) writeEntry(entry Entry) {
+	if s.telemetry != nil && entry.Time.Sub(s.telemetry.start) >= s.recorder.segmentLength() {
+		err := s.rotateTelemetry(entry.Time)
+		if err != nil {
+			log.Printf("error: %s\n", err.Error())
+		}
+	}
+	if s.telemetry == nil {
+		return
+	}
+
+	err := s.telemetry.encoder.Encode(entry)
+	if err != nil {
+		log.Printf("error: failed writing telemetry: %s\n", err.Error())
+	}
+}
+
+func (s *session) rotateTelemetry(now time.Time) error {
+	if s.telemetry != nil {
+		s.telemetry.file.Close()
+		s.telemetry = nil
+		go s.recorder.enforceRetention()
+	}
+
+	path := filepath.Join(s.recorder.cfg.Dir, fmt.Sprintf("%s_%s_%s%s", s.name, telemetryName, now.Format(sessionTimeFormat), telemetryExt))
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed creating telemetry file: %w", err)
+	}
+	s.telemetry = &telemetryWriter{
+		file:    file,
+		encoder: json.NewEncoder(file),
+		path:    path,
+		start:   now,
+	}
+	return nil
+}
+
+func (r *Recorder) segmentLength() time.Duration {
+	return time.Duration(r.cfg.SegmentSeconds) * time.Second
+}
+
+// openFiles lists the files currently being written so retention never removes them
+func (r *Recorder) openFiles() map[string]bool {
+	r.lock.RLock()
+	s := r.session
+	r.lock.RUnlock()
+
+	open := make(map[string]bool, config.MaxSupportedCams+1)
+	for s != nil { //a stopped session can still be draining
+		s.lock.Lock()
+		if s.telemetry != nil {
+			open[filepath.Base(s.telemetry.path)] = true
+		}
+		for _, track := range s.tracks {
+			if track.writer != nil {
+				open[filepath.Base(track.writer.path)] = true
+			}
+		}
+		previous := s.previous
+		s.lock.Unlock()
+		s = previous
+	}
+	return open
+}
+
+// enforceRetention removes the oldest recordings until the directory is under the size limit
+func (r *Recorder) enforceRetention() {
+	if r.cfg.MaxMB <= 0 {
+		return
+	}
+	r.retentionLock.Lock()
+	defer r.retentionLock.Unlock()
+
+	entries, err := os.ReadDir(r.cfg.Dir)
+	if err != nil {
+		log.Printf("error: failed reading recording directory: %s\n", err.Error())
+		return
+	}
+
+	type recording struct {
+		name    string
+		size    int64
+		modTime time.Time
+	}
+
+	open := r.openFiles()
+	files := make([]recording, 0, len(entries))
+	total := int64(0)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || (!strings.HasSuffix(name, videoExtension) && !strings.HasSuffix(name, telemetryExt)) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		total += info.Size()
+		if !open[name] {
+			files = append(files, recording{name: name, size: info.Size(), modTime: info.ModTime()})
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+
+	limit := int64(r.cfg.MaxMB) * 1024 * 1024
+	for i := 0; total > limit && i < len(files); i++ {
+		err := os.Remove(filepath.Join(r.cfg.Dir, files[i].name))
+		if err != nil {
+			log.Printf("error: failed removing old recording %s: %s\n", files[i].name, err.Error())
+			continue
+		}
+		total -= files[i].size
+		log.Printf("removed old recording %s to stay under %dMB\n", files[i].name, r.cfg.MaxMB)
+	}
+}
+
+// WrapDriver returns a driver that records every command before passing it on
+func (r *Recorder) WrapDriver(driver vehicle.CommandDriverIFace) vehicle.CommandDriverIFace {
+	return &recordingDriver{
+		CommandDriverIFace: driver,
+		recorder:           r,
+	}
+}
+
+type recordingDriver struct {
+	vehicle.CommandDriverIFace
+	recorder *Recorder
+}
+
+func (d *recordingDriver) Set(cmd vehicle.DriverCommand) error {
+	err := d.CommandDriverIFace.Set(cmd)
+	if err == nil {
+		d.recorder.RecordCommand(cmd)
+	}
+	return err
+}
+
+func (d *recordingDriver) SetMany(cmds []vehicle.DriverCommand) error {
+	err := d.CommandDriverIFace.SetMany(cmds)
+	if err == nil {
+		for i := range cmds {
+			d.recorder.RecordCommand(cmds[i])
+		}
+	}
+	return err
+}
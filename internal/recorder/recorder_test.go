@@ -0,0 +1,201 @@
+package recorder
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Speshl/gorrc_client/internal/config"
+	"github.com/Speshl/gorrc_client/internal/h264"
+	"github.com/Speshl/gorrc_client/internal/models"
+	"github.com/Speshl/gorrc_client/internal/vehicle"
+)
+
+func newTestRecorder(t *testing.T) *Recorder {
+	t.Helper()
+	return NewRecorder(config.RecorderConfig{
+		Enabled:        true,
+		Dir:            t.TempDir(),
+		SegmentSeconds: 60,
+	})
+}
+
+func testKeyframe() h264.AccessUnit {
+	return h264.AccessUnit{
+		NALs: []h264.NALUnit{
+			{Data: []byte{0x27, 0x64, 0x00, 0x28, 0xac, 0x2b, 0x40}},
+			{Data: []byte{0x28, 0xee, 0x3c, 0xb0}},
+			{Data: []byte{0x25, 0x88, 0x80, 0x40}},
+		},
+		Duration: time.Second / 30,
+	}
+}
+
+// readEntries reads every telemetry file in the directory
+func readEntries(t *testing.T, dir string) []Entry {
+	t.Helper()
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+telemetryExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := make([]Entry, 0, 64)
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			entry := Entry{}
+			err = json.Unmarshal(scanner.Bytes(), &entry)
+			if err != nil {
+				t.Fatalf("bad telemetry line %q: %s", scanner.Text(), err)
+			}
+			entries = append(entries, entry)
+		}
+		file.Close()
+	}
+	return entries
+}
+
+func TestStopWritesQueued(t *testing.T) {
+	r := newTestRecorder(t)
+	r.RecordControl(0, models.ControlState{Seq: 1}) //not recording yet
+	err := r.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.WriteFrame("cam0", 640, 480, testKeyframe())
+	for i := 0; i < 100; i++ {
+		r.RecordControl(1, models.ControlState{Seq: uint32(i)})
+		r.RecordCommand(vehicle.DriverCommand{Name: "esc", Value: float64(i) / 100})
+	}
+	err = r.Stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.RecordControl(0, models.ControlState{Seq: 1}) //stopped again
+
+	counts := make(map[string]int, 3)
+	for _, entry := range readEntries(t, r.cfg.Dir) {
+		counts[entry.Type]++
+	}
+	if counts[EntryControl] != 100 || counts[EntryCommand] != 100 || counts[EntrySegment] != 1 {
+		t.Fatalf("expected 100 controls, 100 commands and a segment, got %v", counts)
+	}
+	videos, _ := filepath.Glob(filepath.Join(r.cfg.Dir, "*"+videoExtension))
+	if len(videos) != 1 {
+		t.Fatalf("expected 1 video segment, got %v", videos)
+	}
+}
+
+// TestQueueNeverBlocks stalls the writer the way a slow sd card would, callers must drop instead of wait
+func TestQueueNeverBlocks(t *testing.T) {
+	r := newTestRecorder(t)
+	err := r.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.lock.RLock()
+	s := r.session
+	r.lock.RUnlock()
+	s.lock.Lock()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < queueSize+10; i++ {
+			r.RecordCommand(vehicle.DriverCommand{Name: "esc"})
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("recording a command blocked on a stalled writer")
+	}
+	dropped := int(r.dropped.Load()) //the writer may have taken one before stalling
+	if dropped < 9 {
+		t.Fatalf("expected commands past the queue size to be dropped, %d were", dropped)
+	}
+
+	s.lock.Unlock()
+	err = r.Stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	written := len(readEntries(t, r.cfg.Dir))
+	if written+dropped != queueSize+10 {
+		t.Fatalf("expected every command written or dropped, %d written and %d dropped", written, dropped)
+	}
+}
+
+// TestToggleConcurrent toggles from several seats while frames and commands arrive, run it with -race
+func TestToggleConcurrent(t *testing.T) {
+	r := newTestRecorder(t)
+
+	var group sync.WaitGroup
+	for seat := 0; seat < 4; seat++ {
+		seat := seat
+		group.Add(2)
+		go func() {
+			defer group.Done()
+			for i := 0; i < 20; i++ {
+				err := r.Toggle()
+				if err != nil {
+					t.Error(err)
+				}
+				r.openFiles()
+			}
+		}()
+		go func() {
+			defer group.Done()
+			for i := 0; i < 200; i++ {
+				r.WriteFrame("cam0", 640, 480, testKeyframe())
+				r.RecordControl(seat, models.ControlState{Seq: uint32(i)})
+				r.RecordCommand(vehicle.DriverCommand{Name: "esc"})
+				r.Recording()
+			}
+		}()
+	}
+	group.Wait()
+
+	if r.Recording() {
+		t.Fatal("expected an even number of toggles to leave recording stopped")
+	}
+	err := r.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.Stop() //waits for this session, which waited for every one before it
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := make(map[string]bool, 80)
+	for _, entry := range readEntries(t, r.cfg.Dir) {
+		if entry.Type == EntrySegment {
+			session := strings.TrimSuffix(entry.File, "_cam0_001"+videoExtension)
+			if names[session] {
+				t.Fatalf("two sessions wrote %s", entry.File)
+			}
+			names[session] = true
+		}
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	r := NewRecorder(config.RecorderConfig{Dir: t.TempDir()})
+	if r.Start() != ErrDisabled || r.Toggle() != ErrDisabled {
+		t.Fatal("expected recording to be disabled")
+	}
+	if r.Stop() != nil {
+		t.Fatal("expected stopping a disabled recorder to do nothing")
+	}
+}
@@ -0,0 +1,305 @@
+package replay
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Speshl/gorrc_client/internal/command/sim"
+	"github.com/Speshl/gorrc_client/internal/config"
+	"github.com/Speshl/gorrc_client/internal/models"
+	"github.com/Speshl/gorrc_client/internal/recorder"
+	"github.com/Speshl/gorrc_client/internal/vehicle"
+	"github.com/Speshl/gorrc_client/internal/vehicle/crawler"
+	smallracer "github.com/Speshl/gorrc_client/internal/vehicle/smallRacer"
+)
+
+const (
+	FormatCSV   = "csv"
+	FormatJSONL = "jsonl"
+
+	DefaultTick = 33 * time.Millisecond //matches the vehicle command ticker
+
+	maxSeats       = 2
+	maxLineSize    = 1024 * 1024
+	settleDuration = vehicle.SaftyTime * 3 //keep ticking after the last input so the seat timeout shows up
+)
+
+type Options struct {
+	VehicleType string
+	Format      string
+	Tick        time.Duration
+}
+
+// Tick is the outcome of one vehicle tick
+type Tick struct {
+	Index    int              `json:"tick"`
+	Offset   int64            `json:"offset_ms"` //since the first recorded control state
+	Inputs   int              `json:"inputs"`    //control states delivered before this tick
+	Commands []CommandOutcome `json:"commands"`
+}
+
+type CommandOutcome struct {
+	Name  string  `json:"name"`
+	Value float64 `json:"value"`
+	Pulse float64 `json:"pulse,omitempty"` //0 when the name is not mapped to a servo
+}
+
+type input struct {
+	at    time.Time
+	seat  int
+	state models.ControlState
+}
+
+// Run feeds the control states in a recorded session through a vehicle using the sim command driver and
+// writes the resulting commands for every tick. Time is simulated so the output is the same on every run.
+func Run(cfg config.Config, opts Options, session io.Reader, output io.Writer) error {
+	if opts.Tick <= 0 {
+		opts.Tick = DefaultTick
+	}
+
+	inputs, err := readInputs(session)
+	if err != nil {
+		return err
+	}
+	if len(inputs) == 0 {
+		return fmt.Errorf("no control states found in session")
+	}
+
+	seatCount := 1
+	for i := range inputs {
+		seatCount = max(seatCount, inputs[i].seat+1)
+	}
+	seats := make([]models.Seat, 0, seatCount)
+	for i := 0; i < seatCount; i++ {
+		seats = append(seats, models.Seat{
+			Index:          i,
+			CommandChannel: make(chan models.ControlState, 1), //unused, commands are handed to the seats directly
+			HudChannel:     make(chan models.Hud, 1),
+		})
+	}
+
+	cfg.CommandCfg.SimTableInterval = 0
+	cfg.CommandCfg.SimExportPath = ""
+	driver := sim.NewCommand(cfg.CommandCfg)
+	capture := &captureDriver{CommandDriverIFace: driver}
+
+	v, err := newVehicle(cfg, opts.VehicleType, capture, seats)
+	if err != nil {
+		return err
+	}
+	err = v.Init()
+	if err != nil {
+		return fmt.Errorf("failed initializing vehicle: %w", err)
+	}
+	defer v.Stop()
+
+	writer, err := newTickWriter(opts.Format, output)
+	if err != nil {
+		return err
+	}
+
+	start := inputs[0].at
+	end := inputs[len(inputs)-1].at.Add(settleDuration)
+	lastSafetyCheck := start
+	next := 0
+	for index := 0; ; index++ {
+		now := start.Add(time.Duration(index) * opts.Tick)
+		if now.After(end) {
+			break
+		}
+
+		delivered := 0
+		for next < len(inputs) && !inputs[next].at.After(now) {
+			v.Receive(inputs[next].seat, inputs[next].state, inputs[next].at)
+			next++
+			delivered++
+		}
+
+		if now.Sub(lastSafetyCheck) >= vehicle.SaftyTime { //the seats check on their own ticker
+			v.CheckSafety(now)
+			lastSafetyCheck = now
+		}
+
+		capture.commands = capture.commands[:0]
+		err = v.Step()
+		if err != nil {
+			return fmt.Errorf("failed at tick %d: %w", index, err)
+		}
+
+		tick := Tick{
+			Index:    index,
+			Offset:   now.Sub(start).Milliseconds(),
+			Inputs:   delivered,
+			Commands: make([]CommandOutcome, 0, len(capture.commands)),
+		}
+		for _, cmd := range capture.commands {
+			pulse, _ := driver.Pulse(cmd.Name)
+			tick.Commands = append(tick.Commands, CommandOutcome{Name: cmd.Name, Value: cmd.Value, Pulse: pulse})
+		}
+
+		err = writer.Write(tick)
+		if err != nil {
+			return fmt.Errorf("failed writing tick %d: %w", index, err)
+		}
+	}
+	return writer.Flush()
+}
+
+func newVehicle(cfg config.Config, vehicleType string, driver vehicle.CommandDriverIFace, seats []models.Seat) (vehicle.ReplayableVehicle, error) {
+	if vehicleType == "" {
+		vehicleType = cfg.SmallRacerCfg.VehicleType
+	}
+
+	switch vehicleType {
+	case "crawler":
+		return crawler.NewCrawler(cfg.CrawlerCfg, driver, seats), nil
+	case "smallracer":
+		return smallracer.NewSmallRacer(cfg.SmallRacerCfg, driver, seats), nil
+	default:
+		return nil, fmt.Errorf("unsupported vehicle type: %s", vehicleType)
+	}
+}
+
+// readInputs reads control entries written by the recorder. Lines holding a bare ControlState are also
+// accepted, they are timed by their browser timestamp and sent to the driver seat.
+func readInputs(session io.Reader) ([]input, error) {
+	inputs := make([]input, 0, 1024)
+	scanner := bufio.NewScanner(session)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		entry := recorder.Entry{}
+		err := json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			return nil, fmt.Errorf("failed decoding line %d: %w", line, err)
+		}
+
+		switch entry.Type {
+		case recorder.EntryControl:
+			if entry.Control == nil {
+				continue
+			}
+			inputs = append(inputs, input{at: entry.Time, seat: entry.Seat, state: *entry.Control})
+		case "":
+			state := models.ControlState{}
+			err = json.Unmarshal(scanner.Bytes(), &state)
+			if err != nil {
+				return nil, fmt.Errorf("failed decoding control state on line %d: %w", line, err)
+			}
+			inputs = append(inputs, input{at: time.UnixMilli(state.TimeStamp), state: state})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed reading session: %w", err)
+	}
+
+	for i := range inputs {
+		if inputs[i].seat < 0 || inputs[i].seat >= maxSeats {
+			return nil, fmt.Errorf("unsupported seat %d in session", inputs[i].seat)
+		}
+		if len(inputs[i].state.Axes) < models.ClientAxesCount {
+			axes := make([]float64, models.ClientAxesCount) //parsers index axes directly
+			copy(axes, inputs[i].state.Axes)
+			inputs[i].state.Axes = axes
+		}
+	}
+
+	sort.SliceStable(inputs, func(i, j int) bool {
+		return inputs[i].at.Before(inputs[j].at)
+	})
+	log.Printf("replaying %d control states\n", len(inputs))
+	return inputs, nil
+}
+
+// captureDriver keeps the commands sent during the current tick
+type captureDriver struct {
+	vehicle.CommandDriverIFace
+	commands []vehicle.DriverCommand
+}
+
+func (d *captureDriver) Set(cmd vehicle.DriverCommand) error {
+	d.commands = append(d.commands, cmd)
+	return d.CommandDriverIFace.Set(cmd)
+}
+
+func (d *captureDriver) SetMany(cmds []vehicle.DriverCommand) error {
+	d.commands = append(d.commands, cmds...)
+	return d.CommandDriverIFace.SetMany(cmds)
+}
+
+type tickWriter interface {
+	Write(Tick) error
+	Flush() error
+}
+
+func newTickWriter(format string, output io.Writer) (tickWriter, error) {
+	switch format {
+	case FormatCSV, "":
+		return &csvTickWriter{writer: csv.NewWriter(output)}, nil
+	case FormatJSONL:
+		return &jsonTickWriter{encoder: json.NewEncoder(output)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported output format: %s", format)
+	}
+}
+
+// csvTickWriter writes one row per tick with a value and pulse column per command, the columns come from
+// the first tick since a vehicle always sends the same commands
+type csvTickWriter struct {
+	writer  *csv.Writer
+	columns []string
+}
+
+func (w *csvTickWriter) Write(tick Tick) error {
+	if w.columns == nil {
+		w.columns = make([]string, 0, len(tick.Commands))
+		header := []string{"tick", "offset_ms", "inputs"}
+		for _, cmd := range tick.Commands {
+			w.columns = append(w.columns, cmd.Name)
+			header = append(header, cmd.Name, cmd.Name+"_pulse")
+		}
+		err := w.writer.Write(header)
+		if err != nil {
+			return err
+		}
+	}
+
+	byName := make(map[string]CommandOutcome, len(tick.Commands))
+	for _, cmd := range tick.Commands {
+		byName[cmd.Name] = cmd
+	}
+
+	row := []string{strconv.Itoa(tick.Index), strconv.FormatInt(tick.Offset, 10), strconv.Itoa(tick.Inputs)}
+	for _, name := range w.columns {
+		cmd := byName[name]
+		row = append(row, strconv.FormatFloat(cmd.Value, 'f', 4, 64), strconv.FormatFloat(cmd.Pulse, 'f', 0, 64))
+	}
+	return w.writer.Write(row)
+}
+
+func (w *csvTickWriter) Flush() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+type jsonTickWriter struct {
+	encoder *json.Encoder
+}
+
+func (w *jsonTickWriter) Write(tick Tick) error {
+	return w.encoder.Encode(tick)
+}
+
+func (w *jsonTickWriter) Flush() error {
+	return nil
+}
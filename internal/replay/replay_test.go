@@ -0,0 +1,161 @@
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Speshl/gorrc_client/internal/config"
+	"github.com/Speshl/gorrc_client/internal/models"
+	"github.com/Speshl/gorrc_client/internal/recorder"
+	"github.com/Speshl/gorrc_client/internal/vehicle"
+)
+
+const upShift = 3 //the same button on every vehicle
+
+var sessionStart = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func testConfig() config.Config {
+	gears := config.VehicleConfig{GearRMin: -0.4, Gear1Min: -0.5, Gear1Max: 0.5}
+	return config.Config{
+		CommandCfg: config.CommandConfig{
+			ServoCfgs: []config.ServoConfig{
+				{Name: "esc", Channel: 0, MinPulse: 1000, MaxPulse: 2000},
+				{Name: "steer", Channel: 1, MinPulse: 1000, MaxPulse: 2000},
+			},
+		},
+		CrawlerCfg:    config.CrawlerConfig{VehicleConfig: gears},
+		SmallRacerCfg: config.SmallRacerConfig{VehicleConfig: gears},
+	}
+}
+
+// testSession is a recorder session where the driver shifts into first, drives full throttle and right for
+// 330ms, then goes silent
+func testSession(t *testing.T, seat int) string {
+	t.Helper()
+	builder := strings.Builder{}
+	encoder := json.NewEncoder(&builder)
+	for i := 0; i < 10; i++ {
+		control := models.ControlState{
+			Axes:      []float64{1, 1, -1}, //steer, throttle and a released brake, the rest are filled in
+			TimeStamp: sessionStart.Add(time.Duration(i) * 33 * time.Millisecond).UnixMilli(),
+			Seq:       uint32(i + 1),
+		}
+		if i >= 2 {
+			control.BitButton = 1 << upShift
+		}
+		at := sessionStart.Add(time.Duration(i)*33*time.Millisecond + 5*time.Millisecond)
+		err := encoder.Encode(recorder.Entry{Time: at, Type: recorder.EntryControl, Seat: seat, Control: &control})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = encoder.Encode(recorder.Entry{Time: at, Type: recorder.EntryCommand, Command: &vehicle.DriverCommand{Name: "esc"}}) //not replayed
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return builder.String()
+}
+
+func runSession(t *testing.T, vehicleType string, format string, session string) string {
+	t.Helper()
+	output := bytes.Buffer{}
+	err := Run(testConfig(), Options{VehicleType: vehicleType, Format: format}, strings.NewReader(session), &output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return output.String()
+}
+
+func TestRunDeterministic(t *testing.T) {
+	session := testSession(t, 0)
+	for _, vehicleType := range []string{"smallracer", "crawler"} {
+		for _, format := range []string{FormatCSV, FormatJSONL} {
+			t.Run(vehicleType+" "+format, func(t *testing.T) {
+				first := runSession(t, vehicleType, format, session)
+				second := runSession(t, vehicleType, format, session)
+				if first == "" || first != second {
+					t.Fatalf("expected the same output on every run, got\n%s\nthen\n%s", first, second)
+				}
+			})
+		}
+	}
+
+	csv := runSession(t, "smallracer", FormatCSV, session)
+	header, _, _ := strings.Cut(csv, "\n")
+	if header != "tick,offset_ms,inputs,esc,esc_pulse,steer,steer_pulse" {
+		t.Fatalf("unexpected csv header %s", header)
+	}
+}
+
+func TestRunSettles(t *testing.T) {
+	session := testSession(t, 0)
+	lastInput := 9*33*time.Millisecond + 5*time.Millisecond
+
+	for _, vehicleType := range []string{"smallracer", "crawler"} {
+		t.Run(vehicleType, func(t *testing.T) {
+			output := runSession(t, vehicleType, FormatJSONL, session)
+
+			ticks := make([]Tick, 0, 64)
+			scanner := bufio.NewScanner(strings.NewReader(output))
+			for scanner.Scan() {
+				tick := Tick{}
+				err := json.Unmarshal(scanner.Bytes(), &tick)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ticks = append(ticks, tick)
+			}
+
+			inputs := 0
+			drove := false
+			for _, tick := range ticks {
+				inputs += tick.Inputs
+				values := make(map[string]CommandOutcome, len(tick.Commands))
+				for _, cmd := range tick.Commands {
+					values[cmd.Name] = cmd
+				}
+				offset := time.Duration(tick.Offset) * time.Millisecond
+
+				if offset <= lastInput && values["esc"].Value > 0 {
+					drove = true
+					if values["esc"].Pulse != 1750 || values["steer"].Value <= 0 {
+						t.Fatalf("tick %d: expected half throttle in first and steering right, got %+v", tick.Index, tick.Commands)
+					}
+				}
+				if offset > lastInput+2*vehicle.SaftyTime { //a safety check has found the seat silent by now
+					if values["esc"].Value != 0 || values["steer"].Value != 0 || values["esc"].Pulse != 1500 || values["steer"].Pulse != 1500 {
+						t.Fatalf("tick %d at %s: expected centered servos once the seat times out, got %+v", tick.Index, offset, tick.Commands)
+					}
+				}
+			}
+
+			if !drove {
+				t.Fatal("expected the session to drive before it went silent")
+			}
+			if inputs != 10 {
+				t.Fatalf("expected every control state delivered once, got %d", inputs)
+			}
+			if end := time.Duration(ticks[len(ticks)-1].Offset) * time.Millisecond; end < lastInput+2*vehicle.SaftyTime {
+				t.Fatalf("expected ticks to settle past the seat timeout, last tick at %s", end)
+			}
+		})
+	}
+}
+
+func TestRunRejectsSeats(t *testing.T) {
+	for _, seat := range []int{-1, maxSeats} {
+		err := Run(testConfig(), Options{VehicleType: "smallracer"}, strings.NewReader(testSession(t, seat)), &bytes.Buffer{})
+		if err == nil {
+			t.Fatalf("expected seat %d to be rejected", seat)
+		}
+	}
+
+	err := Run(testConfig(), Options{VehicleType: "smallracer"}, strings.NewReader(testSession(t, 1)), &bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("expected the passenger seat to replay: %s", err)
+	}
+}
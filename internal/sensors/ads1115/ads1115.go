@@ -0,0 +1,131 @@
+package ads1115
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Speshl/gorrc_client/internal/config"
+	"github.com/Speshl/gorrc_client/internal/sensors"
+	"github.com/googolgl/go-i2c"
+)
+
+const (
+	DefaultAddress = 0x48
+
+	NoChannel   = -1
+	MaxChannels = 4
+
+	regConversion = 0x00
+	regConfig     = 0x01
+
+	configStart      = 0x8000 //start a single conversion, reads back 1 when done
+	configSingleEnd  = 0x4000 //ainN against ground, the channel goes in bits 12-13
+	configGain4V     = 0x0200 //+-4.096V full scale
+	configSingleShot = 0x0100
+	config128SPS     = 0x0080
+	configNoCompare  = 0x0003
+	channelShift     = 12
+
+	fullScale      = 4.096
+	conversionWait = 8 * time.Millisecond //one conversion at 128 samples per second
+	maxPolls       = 5
+)
+
+// Sensor reads the battery through a voltage divider on one channel and optionally a hall or shunt
+// amplifier current sensor on another, one single shot conversion at a time
+type Sensor struct {
+	cfg     config.SensorConfig
+	address uint8
+	lock    sync.Mutex
+	i2c     *i2c.Options
+}
+
+func NewSensor(cfg config.SensorConfig) (*Sensor, error) {
+	address, err := sensors.Address(cfg, DefaultAddress)
+	if err != nil {
+		return nil, err
+	}
+	return &Sensor{
+		cfg:     cfg,
+		address: address,
+	}, nil
+}
+
+func (s *Sensor) Init() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.cfg.ADS1115VoltageChannel < 0 || s.cfg.ADS1115VoltageChannel >= MaxChannels {
+		return fmt.Errorf("invalid ads1115 voltage channel %d", s.cfg.ADS1115VoltageChannel)
+	}
+	if s.cfg.ADS1115CurrentChannel < NoChannel || s.cfg.ADS1115CurrentChannel >= MaxChannels {
+		return fmt.Errorf("invalid ads1115 current channel %d", s.cfg.ADS1115CurrentChannel)
+	}
+
+	var err error
+	s.i2c, err = i2c.New(s.address, s.cfg.I2CDevice)
+	if err != nil {
+		return fmt.Errorf("error starting i2c with address - %w", err)
+	}
+	log.Printf("battery sensor: ads1115 at 0x%02x on %s\n", s.address, s.cfg.I2CDevice)
+	return nil
+}
+
+func (s *Sensor) Read() (sensors.Reading, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	voltage, err := s.convert(s.cfg.ADS1115VoltageChannel)
+	if err != nil {
+		return sensors.Reading{}, err
+	}
+	reading := sensors.Reading{
+		Voltage: voltage * s.cfg.ADS1115VoltageScale,
+	}
+
+	if s.cfg.ADS1115CurrentChannel != NoChannel {
+		current, err := s.convert(s.cfg.ADS1115CurrentChannel)
+		if err != nil {
+			return sensors.Reading{}, err
+		}
+		reading.Current = (current - s.cfg.ADS1115CurrentOffset) * s.cfg.ADS1115CurrentScale
+		reading.HasCurrent = true
+	}
+	return reading, nil
+}
+
+// convert runs a single shot conversion on a channel and returns the volts at its pin
+func (s *Sensor) convert(channel int) (float64, error) {
+	bits := uint16(configStart | configSingleEnd | channel<<channelShift | configGain4V | configSingleShot | config128SPS | configNoCompare)
+	err := s.i2c.WriteRegU16BE(regConfig, bits)
+	if err != nil {
+		return 0, fmt.Errorf("failed starting ads1115 conversion on ain%d: %w", channel, err)
+	}
+
+	for i := 0; ; i++ {
+		time.Sleep(conversionWait)
+		status, err := s.i2c.ReadRegU16BE(regConfig)
+		if err != nil {
+			return 0, fmt.Errorf("failed reading ads1115 status: %w", err)
+		}
+		if status&configStart != 0 {
+			break
+		}
+		if i == maxPolls {
+			return 0, fmt.Errorf("ads1115 conversion on ain%d did not finish", channel)
+		}
+	}
+
+	value, err := s.i2c.ReadRegS16BE(regConversion)
+	if err != nil {
+		return 0, fmt.Errorf("failed reading ads1115 conversion on ain%d: %w", channel, err)
+	}
+	return float64(value) * fullScale / 32768, nil
+}
+
+func (s *Sensor) Stop() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.i2c.Close()
+}
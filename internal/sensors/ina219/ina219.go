@@ -0,0 +1,97 @@
+package ina219
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/Speshl/gorrc_client/internal/config"
+	"github.com/Speshl/gorrc_client/internal/sensors"
+	"github.com/googolgl/go-i2c"
+)
+
+const (
+	DefaultAddress = 0x41 //A0 bridged, the chip's own default 0x40 is taken by the pca9685
+
+	regConfig  = 0x00
+	regShunt   = 0x01
+	regBus     = 0x02
+	configBits = 0x399f //32V bus range, 320mV shunt range, 12 bit, continuous
+
+	shuntLSB     = 0.00001 //volts
+	busLSB       = 0.004   //volts
+	busOverflow  = 0x01
+	busDataShift = 3
+)
+
+// Sensor reads bus voltage and the drop over the shunt. The current is worked out from the shunt
+// resistance instead of the chip's calibration register so no rounding is involved.
+type Sensor struct {
+	cfg     config.SensorConfig
+	address uint8
+	lock    sync.Mutex
+	i2c     *i2c.Options
+}
+
+func NewSensor(cfg config.SensorConfig) (*Sensor, error) {
+	address, err := sensors.Address(cfg, DefaultAddress)
+	if err != nil {
+		return nil, err
+	}
+	return &Sensor{
+		cfg:     cfg,
+		address: address,
+	}, nil
+}
+
+func (s *Sensor) Init() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.cfg.INA219Shunt <= 0 {
+		return fmt.Errorf("invalid ina219 shunt %f ohms", s.cfg.INA219Shunt)
+	}
+
+	var err error
+	s.i2c, err = i2c.New(s.address, s.cfg.I2CDevice)
+	if err != nil {
+		return fmt.Errorf("error starting i2c with address - %w", err)
+	}
+
+	err = s.i2c.WriteRegU16BE(regConfig, configBits)
+	if err != nil {
+		s.i2c.Close()
+		return fmt.Errorf("failed configuring ina219: %w", err)
+	}
+	log.Printf("battery sensor: ina219 at 0x%02x on %s\n", s.address, s.cfg.I2CDevice)
+	return nil
+}
+
+func (s *Sensor) Read() (sensors.Reading, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	bus, err := s.i2c.ReadRegU16BE(regBus)
+	if err != nil {
+		return sensors.Reading{}, fmt.Errorf("failed reading ina219 bus voltage: %w", err)
+	}
+	if bus&busOverflow != 0 {
+		return sensors.Reading{}, fmt.Errorf("ina219 overflow, current is past the shunt range")
+	}
+	shunt, err := s.i2c.ReadRegS16BE(regShunt)
+	if err != nil {
+		return sensors.Reading{}, fmt.Errorf("failed reading ina219 shunt voltage: %w", err)
+	}
+
+	shuntVoltage := float64(shunt) * shuntLSB
+	return sensors.Reading{
+		Voltage:    float64(bus>>busDataShift)*busLSB + shuntVoltage, //bus is measured after the shunt
+		Current:    shuntVoltage / s.cfg.INA219Shunt,
+		HasCurrent: true,
+	}, nil
+}
+
+func (s *Sensor) Stop() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.i2c.Close()
+}
@@ -0,0 +1,274 @@
+package sensors
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Speshl/gorrc_client/internal/config"
+	"github.com/Speshl/gorrc_client/internal/models"
+)
+
+const (
+	LevelOK    = "ok"
+	LevelWarn  = "warn"
+	LevelLimit = "limit"
+
+	CellFull  = 4.2
+	CellEmpty = 3.27
+
+	maxCellGuess  = 4.35 //highest a charged cell reads, used to guess the cell count
+	minCellGuess  = 3.0  //lowest a pack worth guessing from reads per cell, below is noise or usb power
+	voltageSmooth = 0.2  //weight of a new reading, load sag has to last a few seconds to count
+)
+
+// resting lipo cell voltage to percent remaining, highest first
+var cellCurve = []struct {
+	voltage float64
+	percent float64
+}{
+	{4.20, 100}, {4.15, 95}, {4.11, 90}, {4.08, 85}, {4.02, 80}, {3.98, 75}, {3.95, 70},
+	{3.91, 65}, {3.87, 60}, {3.85, 55}, {3.84, 50}, {3.82, 45}, {3.80, 40}, {3.79, 35},
+	{3.77, 30}, {3.75, 25}, {3.73, 20}, {3.71, 15}, {3.69, 10}, {3.61, 5}, {CellEmpty, 0},
+}
+
+// Sensor is a voltage and current source, like the command drivers each chip has its own package
+type Sensor interface {
+	Init() error
+	Read() (Reading, error)
+	Stop() error
+}
+
+type Reading struct {
+	Voltage    float64
+	Current    float64 //amps out of the battery
+	HasCurrent bool    //false when the sensor only measures voltage
+}
+
+// Limiter scales a servo's output toward center, the failsafe driver does this for the throttle
+type Limiter interface {
+	Limit(servo string, scale float64)
+}
+
+// Battery is the latest state of the pack
+type Battery struct {
+	Voltage     float64
+	Current     float64
+	HasCurrent  bool
+	Cells       int
+	CellVoltage float64 //smoothed, what the levels are checked against
+	Remaining   float64 //percent
+	UsedMAh     float64
+	Level       string
+	At          time.Time
+}
+
+// Monitor reads the battery sensor and warns or limits throttle when the cells run low. Levels only go
+// down: a pack recovers when the load comes off, which would flap the limit on and off.
+type Monitor struct {
+	cfg     config.SensorConfig
+	sensor  Sensor //nil when no sensor is configured
+	limiter Limiter
+
+	lock         sync.RWMutex
+	battery      Battery
+	startPercent float64
+	err          error   //last read error, cleared by the next good reading
+	unguessed    float64 //last voltage too low to guess the cell count from
+	guessing     bool    //readings so far were too low to guess from, the hud says so
+}
+
+func NewMonitor(cfg config.SensorConfig, sensor Sensor, limiter Limiter) *Monitor {
+	if cfg.Interval <= 0 {
+		cfg.Interval = config.DefaultSensorInterval
+	}
+	return &Monitor{
+		cfg:     cfg,
+		sensor:  sensor,
+		limiter: limiter,
+		battery: Battery{Level: LevelOK},
+	}
+}
+
+// Start reads the sensor until the context is done. Sensor problems are shown on the hud instead of
+// stopping the car.
+func (m *Monitor) Start(ctx context.Context) error {
+	if m.sensor == nil {
+		return nil
+	}
+
+	err := m.sensor.Init()
+	if err != nil {
+		log.Printf("error: failed initializing battery sensor, battery is not monitored: %s\n", err.Error())
+		m.setErr(fmt.Errorf("sensor init failed: %w", err))
+		return nil
+	}
+	defer func() {
+		err := m.sensor.Stop()
+		if err != nil {
+			log.Printf("error: failed stopping battery sensor: %s\n", err.Error())
+		}
+	}()
+
+	ticker := time.NewTicker(time.Duration(m.cfg.Interval) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("stopping battery monitor: %s\n", ctx.Err().Error())
+			return nil
+		case now := <-ticker.C:
+			reading, err := m.sensor.Read()
+			if err != nil {
+				m.setErr(err)
+				continue
+			}
+			m.update(reading, now)
+		}
+	}
+}
+
+func (m *Monitor) setErr(err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.err == nil {
+		log.Printf("error: failed reading battery sensor: %s\n", err.Error())
+	}
+	m.err = err
+}
+
+func (m *Monitor) update(reading Reading, now time.Time) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.err != nil {
+		log.Println("battery sensor reading again")
+		m.err = nil
+	}
+
+	b := &m.battery
+	if b.Cells == 0 {
+		b.Cells = m.cfg.BatteryCells
+		if b.Cells <= 0 {
+			cells := int(math.Ceil(reading.Voltage / maxCellGuess))
+			if cells == 0 || reading.Voltage/float64(cells) < minCellGuess { //no pack yet, guess again next reading
+				if !m.guessing {
+					log.Printf("warning: %.2fV is not a battery, set BATTERY_CELLS if one is connected\n", reading.Voltage)
+				}
+				m.guessing = true
+				m.unguessed = reading.Voltage
+				return
+			}
+			b.Cells = cells
+			m.guessing = false
+			log.Printf("guessing a %dS battery from %.2fV, set BATTERY_CELLS if that is wrong\n", b.Cells, reading.Voltage)
+		}
+		b.CellVoltage = reading.Voltage / float64(b.Cells)
+		m.startPercent = cellPercent(b.CellVoltage)
+	}
+
+	if reading.HasCurrent && !b.At.IsZero() {
+		b.UsedMAh += reading.Current * now.Sub(b.At).Hours() * 1000
+	}
+	b.Voltage = reading.Voltage
+	b.Current = reading.Current
+	b.HasCurrent = reading.HasCurrent
+	b.At = now
+	b.CellVoltage += voltageSmooth * (reading.Voltage/float64(b.Cells) - b.CellVoltage)
+
+	b.Remaining = cellPercent(b.CellVoltage)
+	if m.cfg.BatteryCapacity > 0 && reading.HasCurrent { //counting charge is steadier than voltage under load
+		b.Remaining = math.Max(0, m.startPercent-b.UsedMAh/float64(m.cfg.BatteryCapacity)*100)
+	}
+
+	switch {
+	case b.Level != LevelLimit && b.CellVoltage < m.cfg.BatteryCellLimit:
+		b.Level = LevelLimit
+		log.Printf("warning: battery at %.2fV per cell, limiting %s to %.0f%%\n", b.CellVoltage, m.cfg.BatteryLimitServo, m.cfg.BatteryThrottleLimit*100)
+		if m.limiter != nil {
+			m.limiter.Limit(m.cfg.BatteryLimitServo, m.cfg.BatteryThrottleLimit)
+		}
+	case b.Level == LevelOK && b.CellVoltage < m.cfg.BatteryCellWarn:
+		b.Level = LevelWarn
+		log.Printf("warning: battery low at %.2fV per cell\n", b.CellVoltage)
+	}
+}
+
+// Hud adds the battery gauges and any low battery or sensor alert to a seat hud
+func (m *Monitor) Hud(h *models.Hud) {
+	if m.sensor == nil {
+		return
+	}
+	m.lock.RLock()
+	b, err, guessing, unguessed := m.battery, m.err, m.guessing, m.unguessed
+	m.lock.RUnlock()
+
+	if err != nil {
+		h.Alerts = append(h.Alerts, models.HudAlert{Source: "battery", Severity: models.SeverityWarning, Text: err.Error()})
+	}
+	if guessing {
+		h.Alerts = append(h.Alerts, models.HudAlert{Source: "battery", Severity: models.SeverityWarning, Text: fmt.Sprintf("no pack detected at %.2fV, not monitored", unguessed)})
+	}
+	if b.At.IsZero() {
+		return
+	}
+
+	severity := ""
+	switch b.Level {
+	case LevelWarn:
+		severity = models.SeverityWarning
+		h.Alerts = append(h.Alerts, models.HudAlert{Source: "battery", Severity: severity, Text: fmt.Sprintf("low, %.2fV per cell", b.CellVoltage)})
+	case LevelLimit:
+		severity = models.SeverityCritical
+		h.Alerts = append(h.Alerts, models.HudAlert{Source: "battery", Severity: severity, Text: fmt.Sprintf("throttle limited to %.0f%%, %.2fV per cell", m.cfg.BatteryThrottleLimit*100, b.CellVoltage)})
+	}
+
+	h.Battery = &models.HudGauge{
+		Value:    b.Voltage,
+		Unit:     models.UnitVolts,
+		Min:      CellEmpty * float64(b.Cells),
+		Max:      CellFull * float64(b.Cells),
+		Severity: severity,
+	}
+	h.Gauges = append(h.Gauges,
+		models.HudGauge{Name: "Cell", Value: b.CellVoltage, Unit: models.UnitVolts, Min: CellEmpty, Max: CellFull, Severity: severity},
+		models.HudGauge{Name: "Remaining", Value: b.Remaining, Unit: models.UnitPercent, Min: 0, Max: 100, Severity: severity},
+	)
+	if b.HasCurrent {
+		h.Gauges = append(h.Gauges, models.HudGauge{Name: "Current", Value: b.Current, Unit: models.UnitAmps})
+	}
+}
+
+// cellPercent interpolates the lipo curve
+func cellPercent(cellVoltage float64) float64 {
+	if cellVoltage >= cellCurve[0].voltage {
+		return 100
+	}
+	for i := 1; i < len(cellCurve); i++ {
+		high, low := cellCurve[i-1], cellCurve[i]
+		if cellVoltage >= low.voltage {
+			return low.percent + (cellVoltage-low.voltage)/(high.voltage-low.voltage)*(high.percent-low.percent)
+		}
+	}
+	return 0
+}
+
+// Address parses the configured i2c address like 0x41, empty uses the driver's default. A sensor at the
+// servo chip's address would have its setup written into the servo chip, so that is refused.
+func Address(cfg config.SensorConfig, defaultAddress uint8) (uint8, error) {
+	address := defaultAddress
+	if cfg.Address != "" {
+		value, err := strconv.ParseUint(cfg.Address, 0, 8)
+		if err != nil {
+			return 0, fmt.Errorf("invalid i2c address %s: %w", cfg.Address, err)
+		}
+		address = uint8(value)
+	}
+	if cfg.ServoAddress != 0 && address == cfg.ServoAddress {
+		return 0, fmt.Errorf("sensor address 0x%02x is the servo driver's address, set SENSOR_ADDRESS to where the sensor answers", address)
+	}
+	return address, nil
+}
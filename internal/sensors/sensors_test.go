@@ -0,0 +1,85 @@
+package sensors
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Speshl/gorrc_client/internal/config"
+)
+
+type limiter struct {
+	servo string
+	scale float64
+}
+
+func (l *limiter) Limit(servo string, scale float64) {
+	l.servo = servo
+	l.scale = scale
+}
+
+func TestCellGuess(t *testing.T) {
+	tests := []struct {
+		name     string
+		voltages []float64
+		cells    int
+	}{
+		{name: "3S pack", voltages: []float64{12.4}, cells: 3},
+		{name: "usb power then 3S pack", voltages: []float64{5.0, 11.8}, cells: 3},
+		{name: "adc noise then 2S pack", voltages: []float64{0.3, 0, 8.2}, cells: 2},
+		{name: "no pack", voltages: []float64{0.3, 5.0}, cells: 0},
+		{name: "1S pack", voltages: []float64{3.9}, cells: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := NewMonitor(config.GetSensorConfig(), nil, nil)
+			now := time.Now()
+			for i, voltage := range test.voltages {
+				m.update(Reading{Voltage: voltage}, now.Add(time.Duration(i)*time.Second))
+			}
+			if m.battery.Cells != test.cells {
+				t.Fatalf("expected %d cells, got %d", test.cells, m.battery.Cells)
+			}
+			if m.guessing != (test.cells == 0) {
+				t.Fatalf("expected guessing %t, got %t", test.cells == 0, m.guessing)
+			}
+		})
+	}
+}
+
+func TestLowBatteryLimits(t *testing.T) {
+	cfg := config.GetSensorConfig()
+	cfg.BatteryCells = 3
+	l := &limiter{}
+	m := NewMonitor(cfg, nil, l)
+
+	now := time.Now()
+	levels := []struct {
+		voltage float64
+		level   string
+	}{
+		{voltage: 12.3, level: LevelOK},
+		{voltage: 9.0, level: LevelOK}, //one sagging reading is smoothed out
+	}
+	for i, step := range levels {
+		m.update(Reading{Voltage: step.voltage}, now.Add(time.Duration(i)*time.Second))
+		if m.battery.Level != step.level {
+			t.Fatalf("reading %d: expected level %s, got %s", i, step.level, m.battery.Level)
+		}
+	}
+
+	for i := 0; i < 30 && m.battery.Level != LevelLimit; i++ {
+		m.update(Reading{Voltage: 9.6}, now.Add(time.Duration(10+i)*time.Second))
+	}
+	if m.battery.Level != LevelLimit {
+		t.Fatalf("expected level %s after a long sag, got %s", LevelLimit, m.battery.Level)
+	}
+	if l.servo != cfg.BatteryLimitServo || l.scale != cfg.BatteryThrottleLimit {
+		t.Fatalf("expected %s limited to %f, got %s limited to %f", cfg.BatteryLimitServo, cfg.BatteryThrottleLimit, l.servo, l.scale)
+	}
+
+	m.update(Reading{Voltage: 12.0}, now.Add(time.Minute)) //recovering at rest does not lift the limit
+	if m.battery.Level != LevelLimit {
+		t.Fatalf("expected level to stay %s, got %s", LevelLimit, m.battery.Level)
+	}
+}
@@ -0,0 +1,52 @@
+package sim
+
+import (
+	"log"
+	"time"
+
+	"github.com/Speshl/gorrc_client/internal/config"
+	"github.com/Speshl/gorrc_client/internal/sensors"
+)
+
+const (
+	DefaultCells = 3
+	startCell    = 4.1  //volts
+	drainPerMin  = 0.02 //volts per cell
+	current      = 5.0  //amps
+)
+
+// Sensor is a pack that drains at a steady rate, for running the hud and low battery handling without
+// hardware
+type Sensor struct {
+	cells   int
+	started time.Time
+}
+
+func NewSensor(cfg config.SensorConfig) *Sensor {
+	cells := cfg.BatteryCells
+	if cells <= 0 {
+		cells = DefaultCells
+	}
+	return &Sensor{
+		cells: cells,
+	}
+}
+
+func (s *Sensor) Init() error {
+	s.started = time.Now()
+	log.Printf("battery sensor: sim %dS pack\n", s.cells)
+	return nil
+}
+
+func (s *Sensor) Read() (sensors.Reading, error) {
+	cellVoltage := max(startCell-drainPerMin*time.Since(s.started).Minutes(), 0)
+	return sensors.Reading{
+		Voltage:    cellVoltage * float64(s.cells),
+		Current:    current,
+		HasCurrent: true,
+	}, nil
+}
+
+func (s *Sensor) Stop() error {
+	return nil
+}
@@ -17,6 +17,8 @@ var soundMap = map[string]string{
 	"shutdown":            "./internal/speaker/audio/shutting_down.wav",
 	"client_connected":    "./internal/speaker/audio/connected.wav",
 	"client_disconnected": "./internal/speaker/audio/disconnected.wav",
+	"server_connected":    "./internal/speaker/audio/connected.wav",
+	"server_disconnected": "./internal/speaker/audio/disconnected.wav",
 }
 
 type Speaker struct {
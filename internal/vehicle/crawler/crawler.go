@@ -151,16 +151,9 @@ func (c *Crawler) Start(ctx context.Context) error {
 				log.Printf("stopping crawer state syncer: %s\n", ctx.Err().Error())
 				return ctx.Err()
 			case <-commandTicker.C:
-				statesWithNewCommand := make([]CrawlerState, 0, len(c.seats))
-				for i := range c.seats {
-					newState := c.seats[i].ApplyCommand(c.state).(CrawlerState)
-					statesWithNewCommand = append(statesWithNewCommand, newState)
-				}
-
-				mixedState := c.mergeSeatStates(statesWithNewCommand)
-				err := c.applyState(mixedState)
+				err := c.Step()
 				if err != nil {
-					return fmt.Errorf("failed applying crawler state: %w", err)
+					return err
 				}
 
 				netDev, err := p.NetDev() //update network stats
@@ -168,10 +161,7 @@ func (c *Crawler) Start(ctx context.Context) error {
 					return fmt.Errorf("error: failed getting netstat: %w", err)
 				}
 
-				wlan0Stats, ok := netDev["wlan0"]
-				if !ok {
-					return fmt.Errorf("error: failed getting wlan0 stats: not found")
-				}
+				wlan0Stats := netDev["wlan0"] //zero stats when there is no wifi, e.g. running with the sim driver
 
 				for i := range c.seats {
 					c.seats[i].UpdateHud(c.state, wlan0Stats)
@@ -187,6 +177,49 @@ func (c *Crawler) Start(ctx context.Context) error {
 	return nil
 }
 
+// Step applies the latest command from every seat and sends the merged state to the command driver
+func (c *Crawler) Step() error {
+	statesWithNewCommand := make([]CrawlerState, 0, len(c.seats))
+	for i := range c.seats {
+		newState := c.seats[i].ApplyCommand(c.state).(CrawlerState)
+		statesWithNewCommand = append(statesWithNewCommand, newState)
+	}
+
+	mixedState := c.mergeSeatStates(statesWithNewCommand)
+	err := c.applyState(mixedState)
+	if err != nil {
+		return fmt.Errorf("failed applying crawler state: %w", err)
+	}
+	return nil
+}
+
+// Receive hands a command straight to a seat, bypassing the seat command channel
+func (c *Crawler) Receive(seat int, command models.ControlState, at time.Time) {
+	if seat < 0 || seat >= len(c.seats) {
+		return
+	}
+	c.seats[seat].Receive(command, at)
+}
+
+// CheckSafety runs the seat command timeout against the given time
+func (c *Crawler) CheckSafety(at time.Time) {
+	for i := range c.seats {
+		c.seats[i].CheckSafety(at)
+	}
+}
+
+func (c *Crawler) Hold(reason string) {
+	for i := range c.seats {
+		c.seats[i].Hold(reason)
+	}
+}
+
+func (c *Crawler) Release(reason string) {
+	for i := range c.seats {
+		c.seats[i].Release(reason)
+	}
+}
+
 // mergeSeatStates merges multiple states into one state. For cases where two seats have control over 1 axis, you can determine mixing here
 func (c *Crawler) mergeSeatStates(states []CrawlerState) CrawlerState {
 	if len(states) < 1 {
@@ -1,8 +1,7 @@
 package crawler
 
 import (
-	"fmt"
-
+	"github.com/Speshl/gorrc_client/internal/hud"
 	"github.com/Speshl/gorrc_client/internal/models"
 	"github.com/Speshl/gorrc_client/internal/vehicle"
 	"github.com/prometheus/procfs"
@@ -47,28 +46,15 @@ func driverCenter[T CrawlerState](state vehicle.VehicleStateIFace[T]) vehicle.Ve
 
 func driverHudUpdater[T CrawlerState](state vehicle.VehicleStateIFace[T], netInfo procfs.NetDevLine) models.Hud {
 	newState := state.(CrawlerState)
-	lines := make([]string, 2)
-
-	lines[0] = fmt.Sprintf("RxPkt:%d | RxErr:%d | RxDrop: %d | TxPkt:%d | TxErr:%d | TxDrop: %d",
-		netInfo.RxPackets,
-		netInfo.RxErrors,
-		netInfo.RxDropped,
-		netInfo.TxPackets,
-		netInfo.TxErrors,
-		netInfo.TxDropped,
-	)
-
-	lines[1] = fmt.Sprintf("Esc:%.2f | Gear:%s | Steer:%.2f | Trim:%.2f | Pan:%.2f | Tilt:%.2f",
-		newState.Esc,
-		newState.Ratios[newState.Gear].Name,
-		newState.Steer,
-		newState.SteerTrim,
-		newState.Pan,
-		newState.Tilt,
-	)
-
 	return models.Hud{
-		Lines: lines,
+		Version: models.HudVersion,
+		Esc:     hud.Normalized(newState.Esc),
+		Steer:   hud.Normalized(newState.Steer),
+		Trim:    hud.Normalized(newState.SteerTrim),
+		Pan:     hud.Normalized(newState.Pan),
+		Tilt:    hud.Normalized(newState.Tilt),
+		Gear:    &models.HudGear{Index: newState.Gear, Name: newState.Ratios[newState.Gear].Name},
+		Link:    hud.Link(netInfo),
 	}
 }
 
@@ -44,6 +44,9 @@ const (
 	MinOutput = -1.0
 )
 
+// Buttons are every button the seats read, the client's own buttons must not use them
+var Buttons = []int{TrimLeft, TrimRight, CamCenter, UpShift, DownShift, VolumeMute, VolumeUp, VolumeDown}
+
 var TransTypeMap = map[int]string{
 	0: TransTypeSequential,
 	1: TransTypeHPattern,
@@ -1,8 +1,7 @@
 package crawler
 
 import (
-	"fmt"
-
+	"github.com/Speshl/gorrc_client/internal/hud"
 	"github.com/Speshl/gorrc_client/internal/models"
 	"github.com/Speshl/gorrc_client/internal/vehicle"
 	"github.com/prometheus/procfs"
@@ -45,12 +44,13 @@ func passngerCenter[T CrawlerState](state vehicle.VehicleStateIFace[T]) vehicle.
 
 func passengerHudUpdater[T CrawlerState](state vehicle.VehicleStateIFace[T], netInfo procfs.NetDevLine) models.Hud {
 	newState := state.(CrawlerState)
-	lines := make([]string, 3)
-	lines[0] = fmt.Sprintf("Trigger: %.2f", newState.Esc)
-	lines[1] = fmt.Sprintf("TurretPan: %.2f", newState.TurretPan)
-	lines[2] = fmt.Sprintf("TurretTilt: %.2f", newState.TurretTilt)
-
 	return models.Hud{
-		Lines: lines,
+		Version: models.HudVersion,
+		Gauges: []models.HudGauge{
+			hud.Named("Trigger", hud.Normalized(newState.Trigger)),
+			hud.Named("TurretPan", hud.Normalized(newState.TurretPan)),
+			hud.Named("TurretTilt", hud.Normalized(newState.TurretTilt)),
+		},
+		Link: hud.Link(netInfo),
 	}
 }
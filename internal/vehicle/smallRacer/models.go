@@ -57,6 +57,9 @@ const (
 	MinOutput = -1.0
 )
 
+// Buttons are every button the seats read, the client's own buttons must not use them
+var Buttons = []int{TrimLeft, TrimRight, CamCenter, UpShift, DownShift, SwitchTransType, ReverseGear, FirstGear, SecondGear, ThirdGear, FourthGear, FifthGear, SixthGear, VolumeMute, VolumeUp, VolumeDown}
+
 type Ratio struct {
 	Name string
 	Max  float64
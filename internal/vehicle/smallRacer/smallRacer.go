@@ -144,16 +144,9 @@ func (c *SmallRacer) Start(ctx context.Context) error {
 				log.Printf("stopping small racer state syncer: %s\n", ctx.Err().Error())
 				return ctx.Err()
 			case <-commandTicker.C:
-				statesWithNewCommand := make([]SmallRacerState, 0, len(c.seats))
-				for i := range c.seats {
-					newState := c.seats[i].ApplyCommand(c.state).(SmallRacerState)
-					statesWithNewCommand = append(statesWithNewCommand, newState)
-				}
-
-				mixedState := c.mergeSeatStates(statesWithNewCommand)
-				err := c.applyState(mixedState)
+				err := c.Step()
 				if err != nil {
-					return fmt.Errorf("failed applying small racer state: %w", err)
+					return err
 				}
 
 				netDev, err := p.NetDev() //update network stats
@@ -161,10 +154,7 @@ func (c *SmallRacer) Start(ctx context.Context) error {
 					return fmt.Errorf("error: failed getting netstat: %w", err)
 				}
 
-				wlan0Stats, ok := netDev["wlan0"] //TODO make configurable
-				if !ok {
-					return fmt.Errorf("error: failed getting wlan0 stats: not found")
-				}
+				wlan0Stats := netDev["wlan0"] //TODO make configurable, zero stats when there is no wifi, e.g. running with the sim driver
 
 				for i := range c.seats {
 					c.seats[i].UpdateHud(c.state, wlan0Stats)
@@ -180,6 +170,49 @@ func (c *SmallRacer) Start(ctx context.Context) error {
 	return nil
 }
 
+// Step applies the latest command from every seat and sends the merged state to the command driver
+func (c *SmallRacer) Step() error {
+	statesWithNewCommand := make([]SmallRacerState, 0, len(c.seats))
+	for i := range c.seats {
+		newState := c.seats[i].ApplyCommand(c.state).(SmallRacerState)
+		statesWithNewCommand = append(statesWithNewCommand, newState)
+	}
+
+	mixedState := c.mergeSeatStates(statesWithNewCommand)
+	err := c.applyState(mixedState)
+	if err != nil {
+		return fmt.Errorf("failed applying small racer state: %w", err)
+	}
+	return nil
+}
+
+// Receive hands a command straight to a seat, bypassing the seat command channel
+func (c *SmallRacer) Receive(seat int, command models.ControlState, at time.Time) {
+	if seat < 0 || seat >= len(c.seats) {
+		return
+	}
+	c.seats[seat].Receive(command, at)
+}
+
+// CheckSafety runs the seat command timeout against the given time
+func (c *SmallRacer) CheckSafety(at time.Time) {
+	for i := range c.seats {
+		c.seats[i].CheckSafety(at)
+	}
+}
+
+func (c *SmallRacer) Hold(reason string) {
+	for i := range c.seats {
+		c.seats[i].Hold(reason)
+	}
+}
+
+func (c *SmallRacer) Release(reason string) {
+	for i := range c.seats {
+		c.seats[i].Release(reason)
+	}
+}
+
 // mergeSeatStates merges multiple states into one state. For cases where two seats have control over 1 axis, you can determine mixing here
 func (c *SmallRacer) mergeSeatStates(states []SmallRacerState) SmallRacerState {
 	if len(states) < 1 {
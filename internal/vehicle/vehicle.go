@@ -3,6 +3,9 @@ package vehicle
 import (
 	"context"
 	"math"
+	"time"
+
+	"github.com/Speshl/gorrc_client/internal/models"
 )
 
 type DriverCommand struct {
@@ -22,9 +25,20 @@ type CommandDriverIFace interface {
 type Vehicle interface {
 	Init() error
 	Start(context.Context) error
+	Hold(reason string) //center every seat and ignore commands until released
+	Release(reason string)
 	//String() string
 }
 
+// ReplayableVehicle can be driven one tick at a time with recorded input instead of its seat channels
+type ReplayableVehicle interface {
+	Vehicle
+	Stop() error
+	Receive(seat int, command models.ControlState, at time.Time)
+	CheckSafety(at time.Time)
+	Step() error
+}
+
 // Creates 32 uints each with only 1 bit. 1,2,4,8,16,32...
 func BuildButtonMasks() []uint32 {
 	buttonMasks := make([]uint32, 32)
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"math"
+	"sort"
 	"sync"
 	"time"
 
@@ -12,7 +13,11 @@ import (
 	"github.com/prometheus/procfs"
 )
 
-const saftyTime = 200 * time.Millisecond
+const SaftyTime = 200 * time.Millisecond
+
+// SeqRestartWindow is how far back a sequence number can jump before it is taken as a restarted client
+// rather than a late command
+const SeqRestartWindow = 1000
 
 type VehicleStateIFace[T any] interface {
 }
@@ -27,12 +32,17 @@ type VehicleSeat[T any] struct {
 
 	seatType string
 	active   bool
+	holds    map[string]bool //reasons the seat is held centered regardless of commands
 
 	buttonMasks []uint32
 
 	nextCommand     models.ControlState
 	lastCommand     models.ControlState
 	lastCommandTime time.Time
+
+	lastSeq      uint32 //0 until a command with a sequence number arrives, and again after the seat goes inactive
+	lateCommands uint64 //arrived after a newer one on an unordered channel, dropped
+	lostCommands uint64 //skipped sequence numbers, never arrived
 }
 
 func NewVehicleSeat[T any](seat *models.Seat, seatType string,
@@ -46,6 +56,7 @@ func NewVehicleSeat[T any](seat *models.Seat, seatType string,
 		hudUpdater:        hudUpdater,
 		seatType:          seatType,
 		active:            false,
+		holds:             make(map[string]bool, 2),
 		buttonMasks:       BuildButtonMasks(),
 	}
 }
@@ -57,43 +68,116 @@ func (c *VehicleSeat[T]) Init() error {
 func (c *VehicleSeat[T]) Start(ctx context.Context) error {
 	log.Printf("starting %s seat\n", c.seatType)
 
-	saftyTicker := time.NewTicker(saftyTime)
+	saftyTicker := time.NewTicker(SaftyTime)
 	for {
 		select {
 		case <-ctx.Done():
 			log.Printf("stopping %s seat state syncer: %s\n", c.seatType, ctx.Err().Error())
 			return ctx.Err()
 		case <-saftyTicker.C:
-			c.lock.Lock()
-			if c.active && time.Since(c.lastCommandTime) > saftyTime {
-				//log.Printf("setting %s seat inactive due to time since last command\n", c.seatType)
-				c.active = false
-			}
-			c.lock.Unlock()
+			c.CheckSafety(time.Now())
 		case command, ok := <-c.seat.CommandChannel:
 			if !ok {
 				return fmt.Errorf("%s seat command channel closed", c.seatType)
 			}
+			c.Receive(command, time.Now())
+		}
+	}
+}
 
-			c.lock.Lock()
-			if c.nextCommand.TimeStamp == 0 {
-				c.nextCommand = command
-			}
+// Receive takes a command as if it arrived on the seat's command channel at the given time
+func (c *VehicleSeat[T]) Receive(command models.ControlState, at time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !c.inOrder(command) {
+		c.lateCommands++
+		return
+	}
 
-			if command.TimeStamp >= c.nextCommand.TimeStamp {
-				c.nextCommand = command
-				c.lastCommandTime = time.Now()
-				c.active = true
-			}
-			c.lock.Unlock()
+	c.nextCommand = command
+	c.lastCommandTime = at
+	c.active = true
+	if command.Seq != 0 {
+		c.lastSeq = command.Seq
+	}
+}
+
+// inOrder reports whether a command is newer than the last one taken, lock must be held. Sequence numbers
+// are used when the client sends them since time stamps only have millisecond resolution.
+func (c *VehicleSeat[T]) inOrder(command models.ControlState) bool {
+	if command.Seq == 0 {
+		return command.TimeStamp >= c.nextCommand.TimeStamp
+	}
+	if c.lastSeq == 0 {
+		return true
+	}
+
+	diff := int32(command.Seq - c.lastSeq) //wraps around
+	switch {
+	case diff > 0:
+		c.lostCommands += uint64(diff - 1)
+		return true
+	case SeqLate(command.Seq, c.lastSeq):
+		return false
+	default:
+		log.Printf("%s seat sequence restarted at %d, was %d, %d late and %d lost commands\n", c.seatType, command.Seq, c.lastSeq, c.lateCommands, c.lostCommands)
+		c.lateCommands = 0
+		c.lostCommands = 0
+		return true
+	}
+}
+
+// SeqLate reports whether a sequence number is the last one or just behind it, allowing for wrap around.
+// Anything further back than SeqRestartWindow is a restarted client and counts as newer.
+func SeqLate(seq, last uint32) bool {
+	diff := int32(seq - last)
+	return diff <= 0 && diff > -SeqRestartWindow
+}
+
+// CheckSafety sets the seat inactive when no command was received within SaftyTime of the given time
+func (c *VehicleSeat[T]) CheckSafety(at time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.active && at.Sub(c.lastCommandTime) > SaftyTime {
+		//log.Printf("setting %s seat inactive due to time since last command\n", c.seatType)
+		c.active = false
+		c.lastSeq = 0 //the next client may start over
+		if c.lateCommands > 0 || c.lostCommands > 0 {
+			log.Printf("%s seat inactive, %d late and %d lost commands\n", c.seatType, c.lateCommands, c.lostCommands)
 		}
+		c.lateCommands = 0 //counted per client, like the sequence
+		c.lostCommands = 0
+	}
+}
+
+// Hold keeps the seat centered until every reason it was held for is released
+func (c *VehicleSeat[T]) Hold(reason string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !c.holds[reason] {
+		log.Printf("holding %s seat: %s\n", c.seatType, reason)
+	}
+	c.holds[reason] = true
+}
+
+func (c *VehicleSeat[T]) Release(reason string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.holds[reason] {
+		log.Printf("releasing %s seat: %s\n", c.seatType, reason)
 	}
+	delete(c.holds, reason)
 }
 
 func (c *VehicleSeat[T]) ApplyCommand(state VehicleStateIFace[T]) VehicleStateIFace[T] {
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
+	if len(c.holds) > 0 {
+		c.lastCommand = models.ControlState{} //the first command after release is skipped, same as a new seat
+		return c.seatCenterer(state)
+	}
+
 	if c.active {
 		c.nextCommand.Buttons = ParseButtons(c.nextCommand.BitButton, c.buttonMasks)
 		if c.lastCommand.TimeStamp == 0 {
@@ -102,12 +186,6 @@ func (c *VehicleSeat[T]) ApplyCommand(state VehicleStateIFace[T]) VehicleStateIF
 			return state
 		}
 
-		if c.nextCommand.TimeStamp-c.lastCommand.TimeStamp > 200 {
-			log.Println("skipping command due to latency")
-			c.lastCommand = c.nextCommand
-			return state
-		}
-
 		newState := c.seatCommandParser(c.lastCommand, c.nextCommand, state)
 		c.lastCommand = c.nextCommand
 		return newState
@@ -123,8 +201,21 @@ func (c *VehicleSeat[T]) UpdateHud(state VehicleStateIFace[T], netInfo procfs.Ne
 
 	c.lock.Lock()
 	defer c.lock.Unlock()
+	hud := c.hudUpdater(state, netInfo)
+	reasons := make([]string, 0, len(c.holds))
+	for reason := range c.holds {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		hud.Alerts = append(hud.Alerts, models.HudAlert{Source: "hold", Severity: models.SeverityWarning, Text: reason})
+	}
+	if c.lateCommands > 0 || c.lostCommands > 0 {
+		hud.Alerts = append(hud.Alerts, models.HudAlert{Source: "cmd", Severity: models.SeverityInfo, Text: fmt.Sprintf("%d late %d lost", c.lateCommands, c.lostCommands)})
+	}
+
 	select {
-	case c.seat.HudChannel <- c.hudUpdater(state, netInfo):
+	case c.seat.HudChannel <- hud:
 	default:
 		log.Printf("%s seat hud channel full, skipping\n", c.seatType)
 	}
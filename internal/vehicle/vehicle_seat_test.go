@@ -0,0 +1,73 @@
+package vehicle
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Speshl/gorrc_client/internal/models"
+)
+
+func TestSeqLate(t *testing.T) {
+	tests := []struct {
+		name string
+		seq  uint32
+		last uint32
+		late bool
+	}{
+		{name: "next", seq: 11, last: 10},
+		{name: "skipped ahead", seq: 500, last: 10},
+		{name: "repeated", seq: 10, last: 10, late: true},
+		{name: "just behind", seq: 9, last: 10, late: true},
+		{name: "inside the restart window", seq: 2000 - SeqRestartWindow + 1, last: 2000, late: true},
+		{name: "restarted client", seq: 1, last: 50000},
+		{name: "wrapped around", seq: 2, last: 1<<32 - 3},
+		{name: "late across the wrap", seq: 1<<32 - 3, last: 2, late: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if SeqLate(test.seq, test.last) != test.late {
+				t.Fatalf("expected late %t for %d after %d", test.late, test.seq, test.last)
+			}
+		})
+	}
+}
+
+func TestSeatCommandCounts(t *testing.T) {
+	seat := NewVehicleSeat[int](&models.Seat{}, "test", nil, nil, nil)
+	now := time.Now()
+	receive := func(seq uint32) {
+		now = now.Add(10 * time.Millisecond)
+		seat.Receive(models.ControlState{Seq: seq}, now)
+	}
+	expect := func(late, lost uint64, lastSeq uint32) {
+		t.Helper()
+		if seat.lateCommands != late || seat.lostCommands != lost || seat.lastSeq != lastSeq {
+			t.Fatalf("expected %d late, %d lost, last %d, got %d late, %d lost, last %d", late, lost, lastSeq, seat.lateCommands, seat.lostCommands, seat.lastSeq)
+		}
+	}
+
+	receive(1)
+	receive(2)
+	receive(5) //3 and 4 lost
+	receive(4) //late
+	expect(1, 2, 5)
+
+	receive(5000)
+	receive(3) //a new client on the same seat starts over, its counts start over too
+	expect(0, 0, 3)
+	receive(4)
+	receive(3)
+	expect(1, 0, 4)
+
+	seat.CheckSafety(now.Add(SaftyTime / 2))
+	expect(1, 0, 4)
+	seat.CheckSafety(now.Add(2 * SaftyTime)) //gone quiet, the next client starts fresh
+	expect(0, 0, 0)
+	if seat.active {
+		t.Fatal("expected the seat to be inactive")
+	}
+
+	receive(1)
+	expect(0, 0, 1)
+}
@@ -1,15 +1,35 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
+	"os"
 
 	"github.com/Speshl/gorrc_client/internal/app"
+	"github.com/Speshl/gorrc_client/internal/command/pca9685"
 	"github.com/Speshl/gorrc_client/internal/config"
+	"github.com/Speshl/gorrc_client/internal/replay"
 	socketio "github.com/googollee/go-socket.io"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		err := runReplay(os.Args[2:])
+		if err != nil {
+			log.Fatalf("replay failed: %s", err.Error())
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == pca9685.WatchdogCommand {
+		err := pca9685.RunWatchdog(config.GetCommandConfig(), os.Stdin, os.NewFile(pca9685.TripFd, "watchdog trips"))
+		if err != nil {
+			log.Fatalf("watchdog failed: %s", err.Error())
+		}
+		return
+	}
+
 	cfg := config.GetConfig()
 
 	socketURI := fmt.Sprintf("http://%s", cfg.ServerCfg.Server)
@@ -19,7 +39,11 @@ func main() {
 		panic(err)
 	}
 
-	app := app.NewApp(cfg, client)
+	app, err := app.NewApp(cfg, client)
+	if err != nil {
+		err = fmt.Errorf("error creating app - %w", err)
+		panic(err)
+	}
 
 	app.RegisterHandlers()
 
@@ -31,3 +55,39 @@ func main() {
 	}
 
 }
+
+// runReplay handles: gorrc_client replay [-vehicle crawler] [-format csv|jsonl] [-tick 33ms] session.jsonl
+func runReplay(args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	vehicleType := flags.String("vehicle", "", "vehicle type to replay through, defaults to GORRC_VEHICLETYPE")
+	format := flags.String("format", replay.FormatCSV, "output format, csv or jsonl")
+	tick := flags.Duration("tick", replay.DefaultTick, "time between vehicle ticks")
+	out := flags.String("out", "", "output file, defaults to stdout")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: gorrc_client replay [flags] session.jsonl")
+	}
+
+	session, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("failed opening session: %w", err)
+	}
+	defer session.Close()
+
+	output := os.Stdout
+	if *out != "" {
+		output, err = os.Create(*out)
+		if err != nil {
+			return fmt.Errorf("failed creating output: %w", err)
+		}
+		defer output.Close()
+	}
+
+	log.SetOutput(os.Stderr) //keep vehicle logs out of the replay output
+	return replay.Run(config.GetConfig(), replay.Options{
+		VehicleType: *vehicleType,
+		Format:      *format,
+		Tick:        *tick,
+	}, session, output)
+}
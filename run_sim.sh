@@ -0,0 +1,15 @@
+#!/bin/sh
+
+file="./gorrc_client"
+
+if [ -f "$file" ] ; then
+    rm "$file"
+fi
+
+echo Compiling...
+go build .
+
+export $(grep -v '^#' gorrc_sim.env | xargs)
+export XDG_RUNTIME_DIR=""
+
+./gorrc_client